@@ -5,14 +5,17 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math/rand/v2"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"seminarska/internal/common/rpc"
 	"seminarska/proto/razpravljalnica"
 
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // ************************************
@@ -95,22 +98,63 @@ func main() {
 			if len(args) > 3 {
 				limit = int32(parseInt64(args[3]))
 			}
-			getMessages(topicID, fromID, limit)
+			getMessages(topicID, fromID, limit, false)
+		case "history":
+			if !requireArgs(args, 2) {
+				continue
+			}
+			topicID := parseInt64(args[1])
+			beforeID := int64(0)
+			limit := int32(10)
+			if len(args) > 2 {
+				beforeID = parseInt64(args[2])
+			}
+			if len(args) > 3 {
+				limit = int32(parseInt64(args[3]))
+			}
+			getMessages(topicID, beforeID, limit, true)
+		case "search":
+			if !requireArgs(args, 2) {
+				continue
+			}
+			searchMessages(args[1:])
+		case "edits":
+			if !requireArgs(args, 3) {
+				continue
+			}
+			getMessageHistory(parseInt64(args[1]), parseInt64(args[2]))
 		case "update":
 			if !requireArgs(args, 4) {
 				continue
 			}
-			updateMessage(parseInt64(args[1]), parseInt64(args[2]), strings.Join(args[3:], " "))
+			msgID, version := parseMessageRef(args[2])
+			updateMessage(parseInt64(args[1]), msgID, version, strings.Join(args[3:], " "))
+		case "retention":
+			if !requireArgs(args, 4) {
+				continue
+			}
+			setRetention(parseInt64(args[1]), args[2], parseInt64(args[3]))
+		case "deltopic":
+			if !requireArgs(args, 2) {
+				continue
+			}
+			deleteTopic(parseInt64(args[1]))
 		case "delete":
 			if !requireArgs(args, 3) {
 				continue
 			}
-			deleteMessage(parseInt64(args[1]), parseInt64(args[2]))
+			msgID, version := parseMessageRef(args[2])
+			deleteMessage(parseInt64(args[1]), msgID, version)
 		case "like":
 			if !requireArgs(args, 3) {
 				continue
 			}
 			likeMessage(parseInt64(args[1]), parseInt64(args[2]))
+		case "unlike":
+			if !requireArgs(args, 3) {
+				continue
+			}
+			unlikeMessage(parseInt64(args[1]), parseInt64(args[2]))
 		case "sub":
 			if !requireArgs(args, 2) {
 				continue
@@ -128,18 +172,26 @@ func main() {
 
 func printHelp() {
 	fmt.Println("Commands:")
-	fmt.Println("  user <name>                     - Create a new user")
-	fmt.Println("  login <name>                    - Login as an existing user")
-	fmt.Println("  topic <name>                    - Create a new topic")
-	fmt.Println("  topics                          - List all topics")
-	fmt.Println("  msg <topicId> <text>            - Post a message to a topic")
-	fmt.Println("  msgs <topicId> [fromId] [limit] - Get messages from a topic")
-	fmt.Println("  update <topicId> <msgId> <text> - Update a message")
-	fmt.Println("  delete <topicId> <msgId>        - Delete a message")
-	fmt.Println("  like <topicId> <msgId>          - Like a message")
-	fmt.Println("  sub <topicId1> [topicId2]...    - Subscribe to topic(s)")
-	fmt.Println("  help                            - Show this help")
-	fmt.Println("  exit                            - Exit the client")
+	fmt.Println("  user <name>                            - Create a new user")
+	fmt.Println("  login <name>                           - Login as an existing user")
+	fmt.Println("  topic <name>                           - Create a new topic")
+	fmt.Println("  topics                                 - List all topics")
+	fmt.Println("  retention <topicId> <maxAge> <maxMsgs> - Expire messages older than maxAge (e.g. 24h)")
+	fmt.Println("                                          or beyond the newest maxMsgs; 0 for no limit")
+	fmt.Println("  deltopic <topicId>                     - Delete a topic with all its messages")
+	fmt.Println("  msg <topicId> <text>                   - Post a message to a topic")
+	fmt.Println("  msgs <topicId> [fromId] [limit]        - Get messages from a topic")
+	fmt.Println("  history <topicId> [beforeId] [limit]   - Get the latest messages before a message")
+	fmt.Println("  search [filter=value]... <words>       - Search messages; \"quoted words\" form a phrase,")
+	fmt.Println("                                          filters: topic, user, after, before (YYYY-MM-DD), from")
+	fmt.Println("  edits <topicId> <msgId>                - Show the earlier texts of a message")
+	fmt.Println("  update <topicId> <msgId>[@ver] <text>  - Update a message, if still at version ver")
+	fmt.Println("  delete <topicId> <msgId>[@ver]         - Delete a message, if still at version ver")
+	fmt.Println("  like <topicId> <msgId>                 - Like a message")
+	fmt.Println("  unlike <topicId> <msgId>               - Remove your like from a message")
+	fmt.Println("  sub <topicId1> [topicId2]...           - Subscribe to topic(s)")
+	fmt.Println("  help                                   - Show this help")
+	fmt.Println("  exit                                   - Exit the client")
 }
 
 func getHeadClient() (razpravljalnica.MessageBoardClient, error) {
@@ -151,12 +203,17 @@ func getHeadClient() (razpravljalnica.MessageBoardClient, error) {
 	return razpravljalnica.NewMessageBoardClient(cc), nil
 }
 
-func getTailClient() (razpravljalnica.MessageBoardClient, error) {
+// getReadClient connects to one of the nodes serving reads, picked at random.
+func getReadClient() (razpravljalnica.MessageBoardClient, error) {
 	state, err := controlClient.GetClusterState(context.Background(), &emptypb.Empty{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get tail address: %v", err)
+		return nil, fmt.Errorf("failed to get read address: %v", err)
 	}
-	cc := rpc.NewClient(context.Background(), state.Tail.Address)
+	addr := state.Tail.Address
+	if readable := state.GetReadable(); len(readable) > 0 {
+		addr = readable[rand.IntN(len(readable))].Address
+	}
+	cc := rpc.NewClient(context.Background(), addr)
 	return razpravljalnica.NewMessageBoardClient(cc), nil
 }
 
@@ -176,7 +233,7 @@ func createUser(name string) {
 }
 
 func login(name string) {
-	client, err := getTailClient()
+	client, err := getReadClient()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -191,12 +248,19 @@ func login(name string) {
 }
 
 func createTopic(name string) {
+	if currentUser == nil {
+		fmt.Println("You must be logged in to create topics")
+		return
+	}
 	client, err := getHeadClient()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	topic, err := client.CreateTopic(context.Background(), &razpravljalnica.CreateTopicRequest{Name: name})
+	topic, err := client.CreateTopic(context.Background(), &razpravljalnica.CreateTopicRequest{
+		Name:   name,
+		UserId: currentUser.Id,
+	})
 	if err != nil {
 		fmt.Printf("Error creating topic: %v\n", err)
 		return
@@ -205,7 +269,7 @@ func createTopic(name string) {
 }
 
 func listTopics() {
-	client, err := getTailClient()
+	client, err := getReadClient()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -217,8 +281,46 @@ func listTopics() {
 	}
 	fmt.Println("Topics:")
 	for _, t := range res.Topics {
-		fmt.Printf("  [%d] %s\n", t.Id, t.Name)
+		if r := t.Retention; r != nil {
+			fmt.Printf("  [%d] %s (max age: %v, max messages: %d)\n",
+				t.Id, t.Name, time.Duration(r.MaxAgeSeconds)*time.Second, r.MaxMessages)
+		} else {
+			fmt.Printf("  [%d] %s\n", t.Id, t.Name)
+		}
+	}
+}
+
+func setRetention(topicID int64, maxAge string, maxMessages int64) {
+	if currentUser == nil {
+		fmt.Println("You must be logged in to set the retention of topics")
+		return
 	}
+	age := time.Duration(0)
+	if maxAge != "0" {
+		var err error
+		if age, err = time.ParseDuration(maxAge); err != nil {
+			fmt.Printf("Invalid duration: %s\n", maxAge)
+			return
+		}
+	}
+	client, err := getHeadClient()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	topic, err := client.SetTopicRetention(context.Background(), &razpravljalnica.SetTopicRetentionRequest{
+		TopicId: topicID,
+		UserId:  currentUser.Id,
+		Retention: &razpravljalnica.Retention{
+			MaxAgeSeconds: int64(age / time.Second),
+			MaxMessages:   maxMessages,
+		},
+	})
+	if err != nil {
+		fmt.Printf("Error setting retention: %v\n", err)
+		return
+	}
+	fmt.Printf("Retention of topic %s set\n", topic.Name)
 }
 
 func postMessage(topicID int64, text string) {
@@ -243,8 +345,8 @@ func postMessage(topicID int64, text string) {
 	fmt.Printf("Message posted: ID=%d\n", msg.Id)
 }
 
-func getMessages(topicID int64, fromID int64, limit int32) {
-	client, err := getTailClient()
+func getMessages(topicID int64, fromID int64, limit int32, latest bool) {
+	client, err := getReadClient()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -253,6 +355,7 @@ func getMessages(topicID int64, fromID int64, limit int32) {
 		TopicId:       topicID,
 		FromMessageId: fromID,
 		Limit:         limit,
+		Latest:        latest,
 	})
 	if err != nil {
 		fmt.Printf("Error getting messages: %v\n", err)
@@ -260,11 +363,100 @@ func getMessages(topicID int64, fromID int64, limit int32) {
 	}
 	fmt.Printf("Messages for topic %d:\n", topicID)
 	for _, m := range res.Messages {
-		fmt.Printf("  [%d] User %d: %s (likes: %d)\n", m.Id, m.UserId, m.Text, m.Likes)
+		edited := ""
+		if m.EditedAt != nil {
+			edited = " (edited)"
+		}
+		fmt.Printf("  [%d@%d] User %d: %s%s (likes: %d)\n", m.Id, m.Version, m.UserId, m.Text, edited, m.Likes)
+	}
+	if res.NextMessageId != 0 {
+		fmt.Printf("Next page from message %d\n", res.NextMessageId)
+	}
+}
+
+// number of search results shown at once
+const searchPage = 10
+
+// searchMessages reads leading filter=value arguments, and searches for the rest.
+func searchMessages(args []string) {
+	req := &razpravljalnica.SearchMessagesRequest{Limit: searchPage}
+	for len(args) > 0 {
+		name, value, found := strings.Cut(args[0], "=")
+		if !found {
+			break
+		}
+		switch name {
+		case "topic":
+			id := parseInt64(value)
+			req.TopicId = &id
+		case "user":
+			id := parseInt64(value)
+			req.UserId = &id
+		case "after", "before":
+			day, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+			if err != nil {
+				fmt.Printf("Invalid date: %s\n", value)
+				return
+			}
+			if name == "after" {
+				req.CreatedAfter = timestamppb.New(day)
+			} else {
+				req.CreatedBefore = timestamppb.New(day)
+			}
+		case "from":
+			req.Offset = int32(parseInt64(value))
+		default:
+			fmt.Printf("Unknown filter: %s\n", name)
+			return
+		}
+		args = args[1:]
+	}
+	req.Query = strings.Join(args, " ")
+
+	client, err := getReadClient()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	res, err := client.SearchMessages(context.Background(), req)
+	if err != nil {
+		fmt.Printf("Error searching messages: %v\n", err)
+		return
+	}
+	fmt.Printf("%d messages found:\n", res.Total)
+	for _, r := range res.Results {
+		m := r.Message
+		fmt.Printf("  [%d] Topic %d, User %d: %s (score: %.2f)\n", m.Id, m.TopicId, m.UserId, m.Text, r.Score)
+	}
+	if res.NextOffset != 0 {
+		fmt.Printf("More results with from=%d\n", res.NextOffset)
 	}
 }
 
-func updateMessage(topicID int64, msgID int64, text string) {
+func getMessageHistory(topicID int64, msgID int64) {
+	client, err := getReadClient()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	res, err := client.GetMessageHistory(context.Background(), &razpravljalnica.GetMessageHistoryRequest{
+		TopicId:   topicID,
+		MessageId: msgID,
+	})
+	if err != nil {
+		fmt.Printf("Error getting message history: %v\n", err)
+		return
+	}
+	fmt.Printf("History of message %d:\n", msgID)
+	for _, r := range res.Revisions {
+		fmt.Printf("  @%d %s\n    replaced by user %d at %s\n",
+			r.MessageVersion, r.Text, r.EditorId, r.EditedAt.AsTime().Local().Format(time.DateTime))
+	}
+	m := res.Message
+	fmt.Printf("  @%d %s (current)\n", m.Version, m.Text)
+}
+
+func updateMessage(topicID int64, msgID int64, version *int64, text string) {
 	if currentUser == nil {
 		fmt.Println("You must be logged in to update messages")
 		return
@@ -275,19 +467,41 @@ func updateMessage(topicID int64, msgID int64, text string) {
 		return
 	}
 	msg, err := client.UpdateMessage(context.Background(), &razpravljalnica.UpdateMessageRequest{
-		TopicId:   topicID,
-		UserId:    currentUser.Id,
-		MessageId: msgID,
-		Text:      text,
+		TopicId:         topicID,
+		UserId:          currentUser.Id,
+		MessageId:       msgID,
+		Text:            text,
+		ExpectedVersion: version,
 	})
 	if err != nil {
 		fmt.Printf("Error updating message: %v\n", err)
 		return
 	}
-	fmt.Printf("Message updated: ID=%d\n", msg.Id)
+	fmt.Printf("Message updated: ID=%d, version %d\n", msg.Id, msg.Version)
+}
+
+func deleteTopic(topicID int64) {
+	if currentUser == nil {
+		fmt.Println("You must be logged in to delete topics")
+		return
+	}
+	client, err := getHeadClient()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	_, err = client.DeleteTopic(context.Background(), &razpravljalnica.DeleteTopicRequest{
+		TopicId: topicID,
+		UserId:  currentUser.Id,
+	})
+	if err != nil {
+		fmt.Printf("Error deleting topic: %v\n", err)
+		return
+	}
+	fmt.Println("Topic deleted")
 }
 
-func deleteMessage(topicID int64, msgID int64) {
+func deleteMessage(topicID int64, msgID int64, version *int64) {
 	if currentUser == nil {
 		fmt.Println("You must be logged in to delete messages")
 		return
@@ -298,9 +512,10 @@ func deleteMessage(topicID int64, msgID int64) {
 		return
 	}
 	_, err = client.DeleteMessage(context.Background(), &razpravljalnica.DeleteMessageRequest{
-		TopicId:   topicID,
-		UserId:    currentUser.Id,
-		MessageId: msgID,
+		TopicId:         topicID,
+		UserId:          currentUser.Id,
+		MessageId:       msgID,
+		ExpectedVersion: version,
 	})
 	if err != nil {
 		fmt.Printf("Error deleting message: %v\n", err)
@@ -331,6 +546,28 @@ func likeMessage(topicID int64, msgID int64) {
 	fmt.Printf("Message liked. New likes: %d\n", msg.Likes)
 }
 
+func unlikeMessage(topicID int64, msgID int64) {
+	if currentUser == nil {
+		fmt.Println("You must be logged in to unlike messages")
+		return
+	}
+	client, err := getHeadClient()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	msg, err := client.UnlikeMessage(context.Background(), &razpravljalnica.UnlikeMessageRequest{
+		TopicId:   topicID,
+		MessageId: msgID,
+		UserId:    currentUser.Id,
+	})
+	if err != nil {
+		fmt.Printf("Error unliking message: %v\n", err)
+		return
+	}
+	fmt.Printf("Like removed. New likes: %d\n", msg.Likes)
+}
+
 func subscribe(topicIDs []int64) {
 	if currentUser == nil {
 		fmt.Println("You must be logged in to subscribe")
@@ -388,3 +625,13 @@ func parseInt64(s string) int64 {
 	}
 	return v
 }
+
+// parseMessageRef parses a message id, optionally followed by @version.
+func parseMessageRef(s string) (int64, *int64) {
+	id, ver, found := strings.Cut(s, "@")
+	if !found {
+		return parseInt64(id), nil
+	}
+	version := parseInt64(ver)
+	return parseInt64(id), &version
+}
@@ -13,6 +13,7 @@ var (
 	rpcAddr         string
 	dataExec        string
 	logFile         string
+	dataNodeDir     string
 	bootstrap       bool
 	targetDataNodes int
 	Cmd             = &cobra.Command{
@@ -30,6 +31,7 @@ func init() {
 	Cmd.Flags().StringVar(&dataExec, "data-exec", "", "Data execution path")
 	Cmd.Flags().BoolVar(&bootstrap, "bootstrap", false, "Bootstrap flag")
 	Cmd.Flags().StringVar(&logFile, "logs", os.DevNull, "Log file")
+	Cmd.Flags().StringVar(&dataNodeDir, "data-dir", "", "Data node storage directory (in-memory if empty)")
 	Cmd.Flags().IntVar(&targetDataNodes, "target-nodes", 5, "Target number of data nodes")
 
 	_ = Cmd.MarkFlagRequired("node-id")
@@ -28,6 +28,7 @@ func run(cmd *cobra.Command, _ []string) {
 	cfg := control.ChainConfig{
 		LoggerPath:      logFile,
 		DataExecutable:  dataExec,
+		DataDir:         dataNodeDir,
 		TargetNodeCount: targetDataNodes,
 	}
 	manager := control.NewChainManager(ctx, cfg, fms, r, rpcAddr)
@@ -2,9 +2,12 @@ package api
 
 import (
 	"context"
+	"math/rand/v2"
 	"seminarska/internal/common/rpc"
 	"seminarska/proto/razpravljalnica"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -35,12 +38,17 @@ func (c *Client) headAddr() (string, error) {
 	return state.Head.GetAddress(), nil
 }
 
-func (c *Client) tailAddr() (string, error) {
+// readAddr picks one of the nodes serving reads, so reads are spread across the chain.
+func (c *Client) readAddr() (string, error) {
 	state, err := c.control.GetClusterState(c.ctx, &emptypb.Empty{})
 	if err != nil {
 		return "", err
 	}
-	return state.Tail.GetAddress(), nil
+	readable := state.GetReadable()
+	if len(readable) == 0 {
+		return state.Tail.GetAddress(), nil
+	}
+	return readable[rand.IntN(len(readable))].GetAddress(), nil
 }
 
 func (c *Client) subAddr() (string, string, error) {
@@ -77,7 +85,7 @@ func (c *Client) SignUp(username string) error {
 }
 
 func (c *Client) Login(username string) error {
-	addr, err := c.tailAddr()
+	addr, err := c.readAddr()
 	if err != nil {
 		return err
 	}
@@ -94,7 +102,7 @@ func (c *Client) Login(username string) error {
 }
 
 func (c *Client) ListTopics() ([]*razpravljalnica.Topic, error) {
-	addr, err := c.tailAddr()
+	addr, err := c.readAddr()
 	if err != nil {
 		return nil, err
 	}
@@ -111,14 +119,15 @@ func (c *Client) CreateTopic(name string) error {
 		return err
 	}
 	req := &razpravljalnica.CreateTopicRequest{
-		Name: name,
+		Name:   name,
+		UserId: int64(c.userId),
 	}
 	_, err = c.getClient(addr).CreateTopic(c.ctx, req)
 	return err
 }
 
 func (c *Client) GetUsername(userId int) (string, error) {
-	addr, err := c.tailAddr()
+	addr, err := c.readAddr()
 	if err != nil {
 		return "", err
 	}
@@ -133,15 +142,17 @@ func (c *Client) GetUsername(userId int) (string, error) {
 	return user.GetName(), nil
 }
 
-func (c *Client) GetMessages(topicId int) ([]*razpravljalnica.Message, error) {
-	addr, err := c.tailAddr()
+// GetMessages returns the newest messages in a topic, oldest first.
+func (c *Client) GetMessages(topicId int, limit int) ([]*razpravljalnica.Message, error) {
+	addr, err := c.readAddr()
 	if err != nil {
 		return nil, err
 	}
 	req := &razpravljalnica.GetMessagesRequest{
 		TopicId:       int64(topicId),
 		FromMessageId: 0,
-		Limit:         0,
+		Limit:         int32(limit),
+		Latest:        true,
 	}
 	messages, err := c.getClient(addr).GetMessages(c.ctx, req)
 	if err != nil {
@@ -150,6 +161,29 @@ func (c *Client) GetMessages(topicId int) ([]*razpravljalnica.Message, error) {
 	return messages.GetMessages(), nil
 }
 
+// SearchMessages returns the messages in a topic matching the query, best first.
+func (c *Client) SearchMessages(topicId int, query string, limit int) ([]*razpravljalnica.Message, error) {
+	addr, err := c.readAddr()
+	if err != nil {
+		return nil, err
+	}
+	topic := int64(topicId)
+	req := &razpravljalnica.SearchMessagesRequest{
+		Query:   query,
+		TopicId: &topic,
+		Limit:   int32(limit),
+	}
+	res, err := c.getClient(addr).SearchMessages(c.ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*razpravljalnica.Message, len(res.GetResults()))
+	for i, result := range res.GetResults() {
+		messages[i] = result.GetMessage()
+	}
+	return messages, nil
+}
+
 func (c *Client) PostMessage(topicId int, text string) error {
 	addr, err := c.headAddr()
 	if err != nil {
@@ -164,6 +198,29 @@ func (c *Client) PostMessage(topicId int, text string) error {
 	return err
 }
 
+// ToggleLike removes the user's like from a message, or likes it if there was none.
+func (c *Client) ToggleLike(topicId int, messageId int64) error {
+	addr, err := c.headAddr()
+	if err != nil {
+		return err
+	}
+	client := c.getClient(addr)
+	_, err = client.UnlikeMessage(c.ctx, &razpravljalnica.UnlikeMessageRequest{
+		TopicId:   int64(topicId),
+		MessageId: messageId,
+		UserId:    int64(c.userId),
+	})
+	if status.Code(err) != codes.NotFound {
+		return err
+	}
+	_, err = client.LikeMessage(c.ctx, &razpravljalnica.LikeMessageRequest{
+		TopicId:   int64(topicId),
+		MessageId: messageId,
+		UserId:    int64(c.userId),
+	})
+	return err
+}
+
 func (c *Client) Subscribe(ctx context.Context, topicId int) (<-chan *razpravljalnica.Message, error) {
 	addr, token, err := c.subAddr()
 	if err != nil {
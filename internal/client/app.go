@@ -90,6 +90,12 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case messages.LoadRequest:
 		return m, tea.Batch(tea.Batch(cmds...), m.LoadMsgCmd(msg.Topic))
 
+	case messages.ToggleLikeMsg:
+		return m, tea.Batch(tea.Batch(cmds...), m.ToggleLikeCmd(msg.Topic, msg.MessageId))
+
+	case input.SearchMsg:
+		return m, tea.Batch(tea.Batch(cmds...), m.SearchCmd(msg.Topic, msg.Query))
+
 	case input.NewMessageMsg:
 		return m, tea.Batch(tea.Batch(cmds...), m.SendMessageCmd(msg.Topic, msg.Text))
 	}
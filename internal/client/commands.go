@@ -4,12 +4,15 @@ import (
 	"log"
 	"seminarska/internal/client/components/forum/chat/messages"
 	"seminarska/internal/client/components/forum/overview"
-	"sort"
+	"seminarska/proto/razpravljalnica"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"google.golang.org/grpc/status"
 )
 
+// number of most recent messages shown in a topic
+const messageHistory = 100
+
 type LoginResultMsg struct {
 	success     bool
 	explanation string
@@ -71,33 +74,47 @@ func (m AppModel) LoadResponseCmd() tea.Cmd {
 
 func (m AppModel) LoadMsgCmd(topic overview.Topic) tea.Cmd {
 	return func() tea.Msg {
-		res, err := m.client.GetMessages(topic.Id)
+		res, err := m.client.GetMessages(topic.Id, messageHistory)
 		if err != nil {
 			log.Println("failed to subscribe:", err)
 			return nil
 		}
 
-		items := make([]messages.Message, len(res))
-		for i, msg := range res {
-			username, err := m.client.GetUsername(int(msg.GetUserId()))
-			if err != nil {
-				log.Println("failed to get username:", err)
-			}
+		return messages.LoadMsg{Messages: m.toMessages(res), Topic: topic}
+	}
+}
 
-			items[i] = messages.Message{
-				MyMessage: msg.GetUserId() == int64(m.client.UserId()),
-				Text:      msg.GetText(),
-				User:      username,
-				Time:      msg.GetCreatedAt().AsTime(),
-			}
+func (m AppModel) SearchCmd(topic overview.Topic, query string) tea.Cmd {
+	return func() tea.Msg {
+		res, err := m.client.SearchMessages(topic.Id, query, messageHistory)
+		if err != nil {
+			log.Println("failed to search:", err)
+			return nil
 		}
+		return messages.SearchResultMsg{Topic: topic, Query: query, Messages: m.toMessages(res)}
+	}
+}
 
-		sort.Slice(items, func(i, j int) bool {
-			return items[i].Time.Before(items[j].Time)
-		})
+func (m AppModel) toMessages(res []*razpravljalnica.Message) []messages.Message {
+	items := make([]messages.Message, len(res))
+	for i, msg := range res {
+		username, err := m.client.GetUsername(int(msg.GetUserId()))
+		if err != nil {
+			log.Println("failed to get username:", err)
+		}
 
-		return messages.LoadMsg{Messages: items, Topic: topic}
+		items[i] = messages.Message{
+			Id:        msg.GetId(),
+			MyMessage: msg.GetUserId() == int64(m.client.UserId()),
+			Text:      msg.GetText(),
+			User:      username,
+			Time:      msg.GetCreatedAt().AsTime(),
+			Likes:     msg.GetLikes(),
+			Edited:    msg.GetEditedAt() != nil,
+		}
 	}
+
+	return items
 }
 
 func (m AppModel) SendMessageCmd(topic overview.Topic, text string) tea.Cmd {
@@ -109,3 +126,13 @@ func (m AppModel) SendMessageCmd(topic overview.Topic, text string) tea.Cmd {
 		return m.LoadMsgCmd(topic)()
 	}
 }
+
+func (m AppModel) ToggleLikeCmd(topic overview.Topic, messageId int64) tea.Cmd {
+	return func() tea.Msg {
+		if err := m.client.ToggleLike(topic.Id, messageId); err != nil {
+			log.Println("failed to toggle like:", err)
+			return nil
+		}
+		return m.LoadMsgCmd(topic)()
+	}
+}
@@ -19,3 +19,17 @@ func NewMessageCmd(text string, topic overview.Topic) tea.Cmd {
 		}
 	}
 }
+
+type SearchMsg struct {
+	Topic overview.Topic
+	Query string
+}
+
+func SearchCmd(query string, topic overview.Topic) tea.Cmd {
+	return func() tea.Msg {
+		return SearchMsg{
+			Query: query,
+			Topic: topic,
+		}
+	}
+}
@@ -2,6 +2,7 @@ package input
 
 import (
 	"seminarska/internal/client/components/forum/overview"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -10,6 +11,9 @@ import (
 
 const Height = 4
 
+// input starting with searchCommand searches the topic instead of posting
+const searchCommand = "/search "
+
 type Model struct {
 	W     int
 	input textinput.Model
@@ -18,7 +22,7 @@ type Model struct {
 
 func NewModel() Model {
 	ti := textinput.New()
-	ti.Placeholder = "Message ..."
+	ti.Placeholder = "Message ... (/search to find messages)"
 	ti.Focus()
 	ti.TextStyle = ti.TextStyle.Background(lipgloss.Color("233"))
 	ti.PlaceholderStyle = ti.PlaceholderStyle.Background(lipgloss.Color("233"))
@@ -45,6 +49,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			value := m.input.Value()
 			if value != "" && m.topic.Id != 0 {
 				m.input.Reset()
+				if query, ok := strings.CutPrefix(value, searchCommand); ok {
+					return m, SearchCmd(query, m.topic)
+				}
 				return m, NewMessageCmd(value, m.topic)
 			}
 		}
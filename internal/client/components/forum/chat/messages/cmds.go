@@ -32,3 +32,22 @@ func LoadRequestCmd(topic overview.Topic, delay time.Duration) tea.Cmd {
 		return LoadRequest{Topic: topic}
 	}
 }
+
+// ToggleLikeMsg asks for the user's like on a message to be added or removed.
+type ToggleLikeMsg struct {
+	Topic     overview.Topic
+	MessageId int64
+}
+
+func ToggleLikeCmd(topic overview.Topic, messageId int64) tea.Cmd {
+	return func() tea.Msg {
+		return ToggleLikeMsg{Topic: topic, MessageId: messageId}
+	}
+}
+
+// SearchResultMsg carries the messages found by a search, shown instead of the topic until esc.
+type SearchResultMsg struct {
+	Topic    overview.Topic
+	Query    string
+	Messages []Message
+}
@@ -1,6 +1,7 @@
 package messages
 
 import (
+	"fmt"
 	"seminarska/internal/client/components/forum/overview"
 	"time"
 
@@ -10,15 +11,21 @@ import (
 )
 
 type Message struct {
+	Id        int64
 	MyMessage bool
 	Text      string
 	User      string
 	Time      time.Time
+	Likes     int32
+	Edited    bool
 }
 
 type Model struct {
 	w, h     int
 	messages []Message
+	selected int64  // id of the selected message, the newest one when 0
+	query    string // search whose results are shown instead of the topic, if set
+	results  []Message
 	ready    bool
 	viewport viewport.Model
 	topic    overview.Topic
@@ -40,7 +47,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if !m.ready {
 			m.viewport = viewport.New(m.w-fx, m.h-fy)
 			m.viewport.KeyMap = viewport.KeyMap{}
-			m.viewport.SetContent(renderMessages(m.messages, m.w))
+			m.viewport.SetContent(m.render())
 			m.ready = true
 		} else {
 			m.viewport.Width = m.w - fx
@@ -52,16 +59,53 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, LoadRequestCmd(m.topic, 200*time.Millisecond)
 		}
 		m.messages = msg.Messages
-		if m.ready {
-			m.viewport.SetContent(renderMessages(m.messages, m.w))
+		if m.ready && m.query == "" {
+			m.viewport.SetContent(m.render())
 			m.viewport.ScrollDown(m.viewport.TotalLineCount())
 		}
 		return m, LoadRequestCmd(msg.Topic, 200*time.Millisecond)
+	case SearchResultMsg:
+		if msg.Topic != m.topic {
+			return m, nil
+		}
+		m.query, m.results = msg.Query, msg.Messages
+		m.selected = 0
+		if len(m.results) > 0 {
+			// results are ranked best first, so the best one starts selected
+			m.selected = m.results[0].Id
+		}
+		if m.ready {
+			m.viewport.SetContent(m.render())
+			m.viewport.GotoTop()
+		}
+		return m, nil
 	case overview.SelectTopicMsg:
 		m.messages = nil
+		m.query, m.results = "", nil
+		m.selected = 0
 		m.viewport.SetContent("")
 		m.topic = msg.Topic
 		return m, LoadRequestCmd(msg.Topic, 0)
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "shift+up":
+			m.moveSelection(-1)
+		case "shift+down":
+			m.moveSelection(1)
+		case "ctrl+l":
+			if i := m.selectedIndex(); i >= 0 {
+				return m, ToggleLikeCmd(m.topic, m.shown()[i].Id)
+			}
+		case "esc":
+			if m.query != "" {
+				m.query, m.results = "", nil
+				m.selected = 0
+				if m.ready {
+					m.viewport.SetContent(m.render())
+					m.viewport.ScrollDown(m.viewport.TotalLineCount())
+				}
+			}
+		}
 	}
 
 	var vpCmd tea.Cmd
@@ -70,6 +114,37 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, vpCmd
 }
 
+// shown returns the search results while a search is shown, the topic's messages otherwise.
+func (m Model) shown() []Message {
+	if m.query != "" {
+		return m.results
+	}
+	return m.messages
+}
+
+// selectedIndex returns the position of the selected message, or -1 without messages.
+func (m Model) selectedIndex() int {
+	shown := m.shown()
+	for i, msg := range shown {
+		if msg.Id == m.selected {
+			return i
+		}
+	}
+	return len(shown) - 1
+}
+
+func (m *Model) moveSelection(delta int) {
+	shown := m.shown()
+	i := m.selectedIndex() + delta
+	if i < 0 || i >= len(shown) {
+		return
+	}
+	m.selected = shown[i].Id
+	if m.ready {
+		m.viewport.SetContent(m.render())
+	}
+}
+
 func equalSlices[T comparable](a, b []T) bool {
 	if len(a) != len(b) {
 		return false
@@ -94,27 +169,43 @@ var (
 			Border(lipgloss.RoundedBorder())
 	otherMessageStyle = messageStyle.BorderForeground(lipgloss.Color("237"))
 	myMessageStyle    = messageStyle.BorderForeground(lipgloss.Color("#f7adad"))
+	selectedStyle     = messageStyle.BorderForeground(lipgloss.Color("250"))
 	senderStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("242"))
 )
 
-func renderMessages(messages []Message, w int) string {
+func (m Model) render() string {
+	selected := m.selectedIndex()
 	var msgContents []string
-	for _, msg := range messages {
+	if m.query != "" {
+		header := fmt.Sprintf("%d results for %q • esc to go back", len(m.results), m.query)
+		msgContents = append(msgContents, senderStyle.Width(m.w).Background(background).Render(header))
+	}
+	for i, msg := range m.shown() {
 		var style lipgloss.Style
-		if msg.MyMessage {
+		switch {
+		case i == selected:
+			style = selectedStyle
+		case msg.MyMessage:
 			style = myMessageStyle
-		} else {
+		default:
 			style = otherMessageStyle
 		}
 		content := style.Render(renderContent(msg))
-		row := lipgloss.NewStyle().Width(w).Background(background).Render(content)
+		row := lipgloss.NewStyle().Width(m.w).Background(background).Render(content)
 		msgContents = append(msgContents, row)
 	}
 	return lipgloss.JoinVertical(lipgloss.Top, msgContents...)
 }
 
 func renderContent(message Message) string {
-	return message.Text + "\n" + senderStyle.Render(message.User+" • "+message.Time.Local().Format("15:04"))
+	info := message.User + " • " + message.Time.Local().Format("15:04")
+	if message.Edited {
+		info += " (edited)"
+	}
+	if message.Likes > 0 {
+		info += fmt.Sprintf(" • ♥ %d", message.Likes)
+	}
+	return message.Text + "\n" + senderStyle.Render(info)
 }
 
 var style = lipgloss.NewStyle().Padding(1, 2)
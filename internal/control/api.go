@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"seminarska/internal/control/dataplane"
+	"seminarska/proto/controllink"
 	"seminarska/proto/razpravljalnica"
 
 	"google.golang.org/grpc"
@@ -14,15 +15,22 @@ type ChainState interface {
 	Head() *dataplane.NodeDescriptor
 	Mid() *dataplane.NodeDescriptor
 	Tail() *dataplane.NodeDescriptor
+	Nodes() []*dataplane.NodeDescriptor
+}
+
+// BufferReports returns the buffer status a node last reported, or nil.
+type BufferReports interface {
+	BufferStatus(nodeId string) *controllink.BufferStatus
 }
 
 type clientHandler struct {
-	state ChainState
+	state   ChainState
+	buffers BufferReports
 	razpravljalnica.UnimplementedControlPlaneServer
 }
 
-func newClientHandler(state ChainState) *clientHandler {
-	return &clientHandler{state: state}
+func newClientHandler(state ChainState, buffers BufferReports) *clientHandler {
+	return &clientHandler{state: state, buffers: buffers}
 }
 
 func (h *clientHandler) Register(grpcServer *grpc.Server) {
@@ -37,11 +45,27 @@ func (h *clientHandler) GetClusterState(
 	if head == nil || tail == nil {
 		return nil, errors.New("cluster not initialized")
 	}
+	// every node serves reads, checking dirty records with the tail
+	nodes := h.state.Nodes()
+	readable := make([]*razpravljalnica.NodeInfo, len(nodes))
+	for i, node := range nodes {
+		readable[i] = h.nodeInfo(node)
+	}
 	return &razpravljalnica.GetClusterStateResponse{
-		Head: head.NodeInfo(),
-		Tail: tail.NodeInfo(),
+		Head:     h.nodeInfo(head),
+		Tail:     h.nodeInfo(tail),
+		Readable: readable,
 	}, nil
 }
+
+// nodeInfo describes a node with what it spilled, so clients can tell a stalled chain.
+func (h *clientHandler) nodeInfo(node *dataplane.NodeDescriptor) *razpravljalnica.NodeInfo {
+	info := node.NodeInfo()
+	status := h.buffers.BufferStatus(node.Config.Id)
+	info.SpilledMessages = status.GetSpilledMessages()
+	info.SpilledBytes = status.GetSpilledBytes()
+	return info
+}
 func (h *clientHandler) GetSubcscriptionNode(
 	_ context.Context, _ *razpravljalnica.SubscriptionNodeRequest,
 ) (*razpravljalnica.SubscriptionNodeResponse, error) {
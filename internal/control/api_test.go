@@ -0,0 +1,38 @@
+package control
+
+import (
+	"context"
+	"seminarska/internal/control/dataplane"
+	"seminarska/proto/controllink"
+	"testing"
+)
+
+type fixedChain []*dataplane.NodeDescriptor
+
+func (c fixedChain) Head() *dataplane.NodeDescriptor    { return c[0] }
+func (c fixedChain) Mid() *dataplane.NodeDescriptor     { return c[len(c)/2] }
+func (c fixedChain) Tail() *dataplane.NodeDescriptor    { return c[len(c)-1] }
+func (c fixedChain) Nodes() []*dataplane.NodeDescriptor { return c }
+
+func TestClientHandler_ClusterStateSpills(t *testing.T) {
+	chain := fixedChain{
+		{Config: dataplane.NodeConfig{Id: "1"}},
+		{Config: dataplane.NodeConfig{Id: "2"}},
+	}
+	buffers := newBufferReports()
+	buffers.replace(map[string]*controllink.BufferStatus{
+		"1": {Messages: 10, SpilledMessages: 3, SpilledBytes: 300},
+	})
+	state, err := newClientHandler(chain, buffers).GetClusterState(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("cluster state: %v", err)
+	}
+	head := state.GetHead()
+	if head.GetSpilledMessages() != 3 || head.GetSpilledBytes() != 300 {
+		t.Fatalf("expected the head to report its spill, got %v", head)
+	}
+	// the tail reported nothing yet
+	if tail := state.GetReadable()[1]; tail.GetSpilledMessages() != 0 || tail.GetNodeId() != "2" {
+		t.Fatalf("unexpected tail: %v", tail)
+	}
+}
@@ -0,0 +1,32 @@
+package control
+
+import (
+	"seminarska/proto/controllink"
+	"sync"
+)
+
+// bufferReports keeps the buffer status each node reported at the last health
+// check, so it can be served with the cluster state. Only the leader checks the
+// nodes, the other control nodes have no reports.
+type bufferReports struct {
+	mx       sync.RWMutex
+	statuses map[string]*controllink.BufferStatus
+}
+
+func newBufferReports() *bufferReports {
+	return &bufferReports{statuses: make(map[string]*controllink.BufferStatus)}
+}
+
+// replace keeps the reports of one health check, forgetting nodes that are gone.
+func (r *bufferReports) replace(statuses map[string]*controllink.BufferStatus) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.statuses = statuses
+}
+
+// BufferStatus returns the last status the node reported, or nil.
+func (r *bufferReports) BufferStatus(nodeId string) *controllink.BufferStatus {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return r.statuses[nodeId]
+}
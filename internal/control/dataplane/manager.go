@@ -34,6 +34,7 @@ type NodeConfig struct {
 	ControlAddress        string `json:"controlAddress,omitempty"`
 	DataChainAddresses    string `json:"dataChainAddresses,omitempty"`
 	ClientRequestsAddress string `json:"clientRequestsAddress,omitempty"`
+	DataDir               string `json:"dataDir,omitempty"`
 }
 
 func (n NodeConfig) String() string {
@@ -45,6 +46,7 @@ type NodeDescriptor struct {
 	Role      controllink.NodeRole `json:"role,omitempty"`
 	Config    NodeConfig           `json:"config"`
 	Successor string               `json:"successor"`
+	Tail      string               `json:"tail,omitempty"` // chain address of the tail the node queries
 }
 
 func (n NodeDescriptor) String() string {
@@ -69,6 +71,7 @@ func NewNodeConfig(
 	controlAddress string,
 	dataChainAddresses string,
 	clientRequestsAddress string,
+	dataDir string,
 ) NodeConfig {
 	return NodeConfig{
 		Id: id, LoggerPath: loggerPath,
@@ -76,6 +79,7 @@ func NewNodeConfig(
 		DataChainAddresses:    dataChainAddresses,
 		ClientRequestsAddress: clientRequestsAddress,
 		SubscriptionToken:     subscriptionToken,
+		DataDir:               dataDir,
 	}
 }
 
@@ -85,6 +89,7 @@ func (c *NodeManager) StartNewDataNode(cfg NodeConfig) (*NodeDescriptor, error)
 		"-id", cfg.Id, "-o", cfg.LoggerPath, "-control",
 		cfg.ControlAddress, "-chain", cfg.DataChainAddresses,
 		"-service", cfg.ClientRequestsAddress, "-token", cfg.SubscriptionToken,
+		"-data", cfg.DataDir,
 	)
 
 	cmd.SysProcAttr = &syscall.SysProcAttr{
@@ -113,6 +118,22 @@ func (c *NodeManager) Ping(node *NodeDescriptor) error {
 	return err
 }
 
+// GetDigest returns the node's digest through index, or its latest one for index 0.
+func (c *NodeManager) GetDigest(node *NodeDescriptor, index int32) (*controllink.Digest, error) {
+	control := controllink.NewControlServiceClient(rpc.NewClient(context.Background(), node.Config.ControlAddress))
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	return control.GetDigest(ctx, &controllink.DigestRequest{Index: index})
+}
+
+// GetBufferStatus returns how many unconfirmed messages the node keeps in memory and on disk.
+func (c *NodeManager) GetBufferStatus(node *NodeDescriptor) (*controllink.BufferStatus, error) {
+	control := controllink.NewControlServiceClient(rpc.NewClient(context.Background(), node.Config.ControlAddress))
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	return control.GetBufferStatus(ctx, &emptypb.Empty{})
+}
+
 func (c *NodeManager) SwitchNodeRole(node *NodeDescriptor, newRole controllink.NodeRole) error {
 	if node.Role == newRole {
 		return nil
@@ -142,6 +163,19 @@ func (c *NodeManager) SwitchDataNodeSuccessor(node *NodeDescriptor, successor *N
 	return err
 }
 
+// SwitchDataNodeTail tells the node where the tail of the chain is, for the reads it serves.
+func (c *NodeManager) SwitchDataNodeTail(node *NodeDescriptor, tail *NodeDescriptor) error {
+	if node.Tail == tail.Config.DataChainAddresses {
+		return nil
+	}
+	control := controllink.NewControlServiceClient(rpc.NewClient(context.Background(), node.Config.ControlAddress))
+	_, err := control.SwitchTail(context.Background(), &controllink.SwitchTailCommand{Address: tail.Config.DataChainAddresses})
+	if err == nil {
+		node.Tail = tail.Config.DataChainAddresses
+	}
+	return err
+}
+
 func (c *NodeManager) DisconnectDataNodeSuccessor(node *NodeDescriptor) error {
 	return c.SwitchDataNodeSuccessor(node, nil)
 }
@@ -5,19 +5,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
+	"path/filepath"
 	"seminarska/internal/common/rpc"
 	"seminarska/internal/control/dataplane"
 	"seminarska/proto/controllink"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type ChainConfig struct {
 	LoggerPath      string
 	DataExecutable  string
+	DataDir         string
 	TargetNodeCount int
 }
 
@@ -27,6 +33,7 @@ type ChainManager struct {
 	nodeManager *dataplane.NodeManager
 	raft        *raft.Raft
 	server      *rpc.Server
+	buffers     *bufferReports
 	done        chan struct{}
 }
 
@@ -37,12 +44,14 @@ func NewChainManager(
 	raft *raft.Raft,
 	addr string,
 ) *ChainManager {
+	buffers := newBufferReports()
 	m := &ChainManager{
 		cfg:         cfg,
 		nodeManager: dataplane.NewNodeManager(cfg.DataExecutable),
 		done:        make(chan struct{}),
 		fsm:         fsm,
-		server:      rpc.NewServer(ctx, newClientHandler(fsm), addr),
+		buffers:     buffers,
+		server:      rpc.NewServer(ctx, newClientHandler(fsm, buffers), addr),
 		raft:        raft,
 	}
 	go m.init(ctx)
@@ -81,8 +90,16 @@ func (m *ChainManager) runHealthCheck() {
 			deadNodes = append(deadNodes, i)
 		}
 	}
-	m.replaceDeadNodes(s, deadNodes)
-	m.addMissingNodes(s)
+	// dead nodes are restarted on their data, diverged ones start over
+	restarts := make([]dataplane.NodeConfig, len(deadNodes))
+	for i, node := range deadNodes {
+		restarts[i] = s.Nodes[node].Config
+	}
+	m.replaceNodes(s, deadNodes, "is dead")
+	m.replaceNodes(s, m.divergedNodes(s), "diverged from the chain")
+	m.reportBuffers(s)
+	m.addMissingNodes(s, restarts)
+	m.announceTail(s)
 	m.sendStateUpdate(s)
 }
 
@@ -98,17 +115,138 @@ func (m *ChainManager) checkNode(node *dataplane.NodeDescriptor) (err error) {
 	return
 }
 
-func (m *ChainManager) replaceDeadNodes(s *ChainSnapshot, deadNodes []int) {
-	if len(deadNodes) == 0 {
+func (m *ChainManager) replaceNodes(s *ChainSnapshot, nodes []int, reason string) {
+	if len(nodes) == 0 {
 		return
 	}
-	for _, i := range deadNodes {
-		log.Println("Node", s.Nodes[i].Config.Id, "is dead")
+	for _, i := range nodes {
+		log.Println("Node", s.Nodes[i].Config.Id, reason)
 	}
-	m.deleteDeadNodes(s, deadNodes)
+	m.deleteDeadNodes(s, nodes)
 	m.rerouteChain(s)
 }
 
+// divergedNodes compares the digests of the nodes at the latest index all of
+// them applied. The largest group of equal digests is taken as correct, on a
+// tie the one with the tail, whose state clients read. The nodes outside it
+// are returned, together with the nodes that cannot produce a digest at that
+// index. Nodes that cannot be reached are left out of the comparison.
+func (m *ChainManager) divergedNodes(s *ChainSnapshot) []int {
+	ids := make([]string, len(s.Nodes))
+	for i, node := range s.Nodes {
+		ids[i] = node.Config.Id
+	}
+	return compareDigests(ids, func(i int, index int32) (*controllink.Digest, error) {
+		return m.nodeManager.GetDigest(s.Nodes[i], index)
+	})
+}
+
+// compareDigests does the comparison of divergedNodes for the nodes with the
+// given ids, reading their digests through index (0 for the latest) with digest.
+func compareDigests(ids []string, digest func(i int, index int32) (*controllink.Digest, error)) []int {
+	if len(ids) < 2 {
+		return nil
+	}
+	var missing, compared []int
+	common := int32(math.MaxInt32)
+	for i, id := range ids {
+		latest, err := digest(i, 0)
+		if err != nil {
+			if digestMissing(err) {
+				log.Println("Node", id, "has no digest:", err)
+				missing = append(missing, i)
+			} else {
+				log.Println("Cannot compare node", id, "with the chain:", err)
+			}
+			continue
+		}
+		compared = append(compared, i)
+		common = min(common, latest.GetIndex())
+	}
+	if len(compared) == 0 {
+		// no node is known to be right
+		return nil
+	}
+	if len(compared) < 2 || common == 0 {
+		return missing
+	}
+
+	groups := make(map[string][]int)
+	for _, i := range compared {
+		at, err := digest(i, common)
+		if err != nil {
+			if digestMissing(err) {
+				log.Println("Node", ids[i], "has no digest at message", common, ":", err)
+				missing = append(missing, i)
+			} else {
+				log.Println("Cannot compare node", ids[i], "at message", common, ":", err)
+			}
+			continue
+		}
+		groups[string(at.GetValue())] = append(groups[string(at.GetValue())], i)
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	tail := len(ids) - 1
+	var correct string
+	for value, group := range groups {
+		if len(group) > len(groups[correct]) || len(group) == len(groups[correct]) && slices.Contains(group, tail) {
+			correct = value
+		}
+	}
+	diverged := missing
+	for value, group := range groups {
+		if value != correct {
+			diverged = append(diverged, group...)
+		}
+	}
+	slices.Sort(diverged)
+	return diverged
+}
+
+// digestMissing reports whether a node answered that it has no digest for the
+// index asked, as opposed to not answering at all: it imported its state without
+// one, or it never applied the index or forgot it.
+func digestMissing(err error) bool {
+	code := status.Code(err)
+	return code == codes.NotFound || code == codes.FailedPrecondition
+}
+
+// reportBuffers collects the buffer status of the nodes for the cluster state and
+// warns about nodes that spill unconfirmed messages to disk, which means the
+// nodes after them stopped confirming.
+func (m *ChainManager) reportBuffers(s *ChainSnapshot) {
+	statuses := make(map[string]*controllink.BufferStatus, len(s.Nodes))
+	for _, node := range s.Nodes {
+		status, err := m.nodeManager.GetBufferStatus(node)
+		if err != nil {
+			log.Println("Cannot get buffer status of node", node.Config.Id, ":", err)
+			continue
+		}
+		statuses[node.Config.Id] = status
+		if status.GetSpilledMessages() > 0 {
+			log.Println("Node", node.Config.Id, "spilled", status.GetSpilledMessages(),
+				"unconfirmed messages to disk,", status.GetSpilledBytes(), "bytes; the chain after it is stalled")
+		}
+	}
+	m.buffers.replace(statuses)
+}
+
+// announceTail tells the nodes where the tail is, which they query before
+// reading records with unconfirmed changes.
+func (m *ChainManager) announceTail(s *ChainSnapshot) {
+	if len(s.Nodes) == 0 {
+		return
+	}
+	tail := s.Nodes[len(s.Nodes)-1]
+	for _, node := range s.Nodes {
+		if err := m.nodeManager.SwitchDataNodeTail(node, tail); err != nil {
+			log.Println("Cannot tell node", node.Config.Id, "where the tail is:", err)
+		}
+	}
+}
+
 func (m *ChainManager) deleteDeadNodes(
 	s *ChainSnapshot,
 	deadNodes []int,
@@ -128,10 +266,10 @@ func (m *ChainManager) deleteDeadNodes(
 	s.Nodes = dst
 }
 
-func (m *ChainManager) addNode(s *ChainSnapshot) {
+func (m *ChainManager) addNode(s *ChainSnapshot, restart *dataplane.NodeConfig) {
 	defer func() { s.Counter++ }()
 
-	node, err := m.spawnNewNode(s)
+	node, err := m.spawnNewNode(s, restart)
 	if err != nil {
 		log.Println("Failed to start new node:", err)
 		return
@@ -148,13 +286,23 @@ func (m *ChainManager) addNode(s *ChainSnapshot) {
 
 }
 
-func (m *ChainManager) spawnNewNode(s *ChainSnapshot) (*dataplane.NodeDescriptor, error) {
+// spawnNewNode starts a node on fresh ports. A node that replaces a dead one
+// keeps its id and data directory, so it reopens its log and snapshots and
+// rejoins from them; otherwise the node gets a new id and an empty directory.
+func (m *ChainManager) spawnNewNode(s *ChainSnapshot, restart *dataplane.NodeConfig) (*dataplane.NodeDescriptor, error) {
 	nextNodeId := fmt.Sprintf("data_%d", s.Counter)
 	secret := strconv.Itoa(rand.Int())
 	p1, p2, p3 := getNodePorts(s.Counter)
+	dataDir := ""
+	if m.cfg.DataDir != "" {
+		dataDir = filepath.Join(m.cfg.DataDir, nextNodeId)
+	}
+	if restart != nil {
+		nextNodeId, dataDir = restart.Id, restart.DataDir
+	}
 	nodeConfig := dataplane.NewNodeConfig(
 		nextNodeId, m.cfg.LoggerPath,
-		secret, p1, p2, p3,
+		secret, p1, p2, p3, dataDir,
 	)
 	return m.nodeManager.StartNewDataNode(nodeConfig)
 }
@@ -249,9 +397,15 @@ func (m *ChainManager) rerouteChain(s *ChainSnapshot) {
 	}
 }
 
-func (m *ChainManager) addMissingNodes(s *ChainSnapshot) {
-	for range m.cfg.TargetNodeCount - len(s.Nodes) {
-		m.addNode(s)
+// addMissingNodes starts nodes until the chain has its target length, first
+// the restarts of the given dead nodes.
+func (m *ChainManager) addMissingNodes(s *ChainSnapshot, restarts []dataplane.NodeConfig) {
+	for i := range m.cfg.TargetNodeCount - len(s.Nodes) {
+		var restart *dataplane.NodeConfig
+		if i < len(restarts) {
+			restart = &restarts[i]
+		}
+		m.addNode(s, restart)
 	}
 }
 
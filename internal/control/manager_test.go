@@ -0,0 +1,88 @@
+package control
+
+import (
+	"seminarska/internal/control/dataplane"
+	"seminarska/internal/data/storage"
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/controllink"
+	"seminarska/proto/datalink"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChainManager_RestartNode(t *testing.T) {
+	// the node process is not needed, only the directory it is given
+	m := &ChainManager{
+		cfg:         ChainConfig{DataDir: t.TempDir()},
+		nodeManager: dataplane.NewNodeManager("true"),
+	}
+	s := &ChainSnapshot{}
+	node, err := m.spawnNewNode(s, nil)
+	if err != nil {
+		t.Fatalf("spawn: %v", err)
+	}
+	s.Counter++
+
+	database, err := storage.OpenAppDatabase(node.Config.DataDir, storage.CheckpointPolicy{}, storage.MemoryEngine)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	h := database.ReplicationHandler()
+	msg := entities.EntityToDatalink(entities.NewUser("ana"))
+	msg.MessageIndex, msg.Op = 1, datalink.Operation_Create
+	if err := h.OnMessage(msg); err != nil {
+		t.Fatalf("message: %v", err)
+	}
+	h.OnConfirmation(&datalink.Confirmation{MessageIndex: 1, Ok: true})
+	if err := database.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	restarted, err := m.spawnNewNode(s, &node.Config)
+	if err != nil {
+		t.Fatalf("respawn: %v", err)
+	}
+	if restarted.Config.Id != node.Config.Id || restarted.Config.DataDir != node.Config.DataDir {
+		t.Fatalf("expected the restart to keep %v, got %v", node.Config, restarted.Config)
+	}
+	database, err = storage.OpenAppDatabase(restarted.Config.DataDir, storage.CheckpointPolicy{}, storage.MemoryEngine)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer database.Close()
+	if database.LastRestoredIndex() <= 0 {
+		t.Fatalf("expected the restarted node to restore from its log")
+	}
+}
+
+func TestCompareDigests_MissingIndex(t *testing.T) {
+	// the digests each node holds by message index; node 1 never applied
+	// message 5, and node 3 cannot be reached
+	histories := []map[int32]string{
+		{4: "d4", 5: "d5"},
+		{4: "d4", 6: "x6", 7: "x7"},
+		{4: "d4", 5: "d5", 6: "d6"},
+		nil,
+	}
+	latest := []int32{5, 7, 6}
+	digest := func(i int, index int32) (*controllink.Digest, error) {
+		if histories[i] == nil {
+			return nil, status.Error(codes.Unavailable, "connection refused")
+		}
+		if index == 0 {
+			index = latest[i]
+		}
+		value, ok := histories[i][index]
+		if !ok {
+			return nil, status.Error(codes.NotFound, "digest not kept for index")
+		}
+		return &controllink.Digest{Index: index, Value: []byte(value)}, nil
+	}
+	diverged := compareDigests([]string{"1", "2", "3", "4"}, digest)
+	if !slices.Equal(diverged, []int{1}) {
+		t.Fatalf("expected only node 1 to diverge, got %v", diverged)
+	}
+}
@@ -0,0 +1,99 @@
+package chain
+
+import (
+	"fmt"
+	"seminarska/proto/datalink"
+
+	"google.golang.org/grpc"
+)
+
+// messageBatches sends messages to the successor in batches and expands the
+// confirmation batches it returns.
+type messageBatches struct {
+	stream grpc.BidiStreamingClient[datalink.MessageBatch, datalink.ConfirmationBatch]
+}
+
+func (m *messageBatches) Send(batch []*datalink.Message) error {
+	return m.stream.Send(&datalink.MessageBatch{Messages: batch})
+}
+
+func (m *messageBatches) Recv() ([]*datalink.Confirmation, error) {
+	batch, err := m.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return expandConfirmations(batch)
+}
+
+// confirmationBatches receives messages from the predecessor in batches and
+// sends back the confirmations of consecutive messages as one batch.
+type confirmationBatches struct {
+	stream grpc.BidiStreamingServer[datalink.MessageBatch, datalink.ConfirmationBatch]
+}
+
+func (c *confirmationBatches) Send(batch []*datalink.Confirmation) error {
+	for len(batch) > 0 {
+		n := 1
+		for n < len(batch) && batch[n].GetMessageIndex() == batch[n-1].GetMessageIndex()+1 {
+			n++
+		}
+		if err := c.stream.Send(packConfirmations(batch[:n])); err != nil {
+			return err
+		}
+		batch = batch[n:]
+	}
+	return nil
+}
+
+func (c *confirmationBatches) Recv() ([]*datalink.Message, error) {
+	batch, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return batch.GetMessages(), nil
+}
+
+// packConfirmations packs confirmations of consecutive messages into a batch.
+func packConfirmations(confirmations []*datalink.Confirmation) *datalink.ConfirmationBatch {
+	batch := &datalink.ConfirmationBatch{
+		First: confirmations[0].GetMessageIndex(),
+		Last:  confirmations[len(confirmations)-1].GetMessageIndex(),
+	}
+	for _, conf := range confirmations {
+		if conf.GetOk() {
+			batch.RequestIds = append(batch.RequestIds, conf.GetRequestId())
+		} else {
+			batch.Failures = append(batch.Failures, conf)
+		}
+	}
+	return batch
+}
+
+// expandConfirmations returns a confirmation for every message of the batch, in
+// index order.
+func expandConfirmations(batch *datalink.ConfirmationBatch) ([]*datalink.Confirmation, error) {
+	count := int(batch.GetLast() - batch.GetFirst() + 1)
+	if count != len(batch.GetFailures())+len(batch.GetRequestIds()) {
+		return nil, fmt.Errorf("confirmation batch of %d messages holds %d failures and %d request ids",
+			count, len(batch.GetFailures()), len(batch.GetRequestIds()))
+	}
+	confirmations := make([]*datalink.Confirmation, 0, count)
+	failures, requestIds := batch.GetFailures(), batch.GetRequestIds()
+	for index := batch.GetFirst(); index <= batch.GetLast(); index++ {
+		if len(failures) > 0 && failures[0].GetMessageIndex() == index {
+			confirmations = append(confirmations, failures[0])
+			failures = failures[1:]
+			continue
+		}
+		if len(requestIds) == 0 {
+			return nil, fmt.Errorf("confirmation batch lacks message %d", index)
+		}
+		confirmations = append(confirmations, &datalink.Confirmation{
+			MessageIndex: index,
+			RequestId:    requestIds[0],
+			Ok:           true,
+		})
+		requestIds = requestIds[1:]
+	}
+	return confirmations, nil
+}
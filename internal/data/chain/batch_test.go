@@ -0,0 +1,33 @@
+package chain
+
+import (
+	"testing"
+
+	"seminarska/proto/datalink"
+)
+
+func TestConfirmationBatch_RoundTrip(t *testing.T) {
+	confirmations := []*datalink.Confirmation{
+		{MessageIndex: 2, RequestId: "2", Ok: true},
+		{MessageIndex: 3, RequestId: "3", Ok: false},
+		{MessageIndex: 4, RequestId: "4", Ok: true},
+	}
+
+	batch := packConfirmations(confirmations)
+	if batch.GetFirst() != 2 || batch.GetLast() != 4 || len(batch.GetFailures()) != 1 {
+		t.Fatalf("unexpected batch: %v", batch)
+	}
+	got, err := expandConfirmations(batch)
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if len(got) != len(confirmations) {
+		t.Fatalf("expected %d confirmations got %d", len(confirmations), len(got))
+	}
+	for i, conf := range got {
+		want := confirmations[i]
+		if conf.GetMessageIndex() != want.GetMessageIndex() || conf.GetRequestId() != want.GetRequestId() || conf.GetOk() != want.GetOk() {
+			t.Fatalf("confirmation %d: expected %v got %v", i, want, conf)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package chain
 
 import (
 	"errors"
+	"log"
 	"math"
 	"strconv"
 	"sync"
@@ -19,16 +20,60 @@ var (
 	ErrNoBufferedMessages = errors.New("no buffered messages")
 )
 
+// Spill keeps the oldest values of a replay buffer that reached its limits,
+// in index order.
+type Spill[T Indexable] interface {
+	Append(values ...T) error
+	// ReadAfter returns the values with an index above index
+	ReadAfter(index int32) ([]T, error)
+	// DropBefore forgets the values with an index below index
+	DropBefore(index int32) error
+	// Size returns the number of values held and their size in bytes
+	Size() (int, int64)
+}
+
+// Occupancy reports how much a replay buffer holds in memory and on disk.
+type Occupancy struct {
+	Values        int
+	Bytes         int64
+	SpilledValues int
+	SpilledBytes  int64
+}
+
 type ReplayBuffer[T Indexable] struct {
 	buffer []T
 	mx     *sync.RWMutex
 	size   int
+	// with a spill the buffer is also bounded by bytes, and values over
+	// either limit are spilled instead of dropped
+	spill    Spill[T]
+	sizeOf   func(T) int
+	maxBytes int64
+	bytes    int64
 }
 
 func NewReplayBuffer[T Indexable](size int) *ReplayBuffer[T] {
 	return &ReplayBuffer[T]{mx: &sync.RWMutex{}, size: size}
 }
 
+// NewSpillingReplayBuffer keeps at most size values and maxBytes of them, as
+// measured by sizeOf, in memory; 0 leaves the bytes unbounded. Older values are written to spill and read
+// back from it when they are replayed.
+func NewSpillingReplayBuffer[T Indexable](
+	size int,
+	maxBytes int64,
+	sizeOf func(T) int,
+	spill Spill[T],
+) *ReplayBuffer[T] {
+	return &ReplayBuffer[T]{
+		mx:       &sync.RWMutex{},
+		size:     size,
+		spill:    spill,
+		sizeOf:   sizeOf,
+		maxBytes: maxBytes,
+	}
+}
+
 func (b *ReplayBuffer[T]) Add(messages ...T) error {
 	b.mx.Lock()
 	defer b.mx.Unlock()
@@ -37,13 +82,38 @@ func (b *ReplayBuffer[T]) Add(messages ...T) error {
 			return errors.Join(ErrIndexOutOfOrder, errors.New(strconv.Itoa(int(msg.GetMessageIndex()))))
 		}
 		b.buffer = append(b.buffer, msg)
-		if len(b.buffer) > b.size {
-			b.buffer = b.buffer[1:]
+		if b.spill == nil {
+			if len(b.buffer) > b.size {
+				b.buffer = b.buffer[1:]
+			}
+			continue
+		}
+		b.bytes += int64(b.sizeOf(msg))
+		if err := b.evict(); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// evict spills the oldest values until the buffer is within its limits. The
+// newest value always stays in memory. A value that cannot be spilled is lost,
+// so a replay that needs it fails.
+func (b *ReplayBuffer[T]) evict() error {
+	over := 0
+	for over < len(b.buffer)-1 &&
+		(len(b.buffer)-over > b.size || b.maxBytes > 0 && b.bytes > b.maxBytes) {
+		b.bytes -= int64(b.sizeOf(b.buffer[over]))
+		over++
+	}
+	if over == 0 {
+		return nil
+	}
+	evicted := b.buffer[:over]
+	b.buffer = b.buffer[over:]
+	return b.spill.Append(evicted...)
+}
+
 func (b *ReplayBuffer[T]) LastMessageIndex() (int32, error) {
 	b.mx.RLock()
 	defer b.mx.RUnlock()
@@ -56,18 +126,31 @@ func (b *ReplayBuffer[T]) LastMessageIndex() (int32, error) {
 func (b *ReplayBuffer[T]) MessagesAfter(index int32) ([]T, error) {
 	b.mx.RLock()
 	defer b.mx.RUnlock()
-	for i, msg := range b.buffer {
+	buffer := b.buffer
+	if b.spilled() && (len(buffer) == 0 || buffer[0].GetMessageIndex() > index) {
+		// the value at index itself is read too, to tell whether the result is complete
+		spilled, err := b.spill.ReadAfter(index - 1)
+		if err != nil {
+			return nil, err
+		}
+		buffer = append(spilled, buffer...)
+	}
+	return messagesAfter(buffer, index)
+}
+
+func messagesAfter[T Indexable](buffer []T, index int32) ([]T, error) {
+	for i, msg := range buffer {
 		if msg.GetMessageIndex() == index {
-			if i == len(b.buffer)-1 {
+			if i == len(buffer)-1 {
 				return []T{}, nil
 			}
-			return b.buffer[i+1:], nil
+			return buffer[i+1:], nil
 		}
 		if msg.GetMessageIndex() == index+1 {
-			return b.buffer[i:], nil
+			return buffer[i:], nil
 		}
 		if msg.GetMessageIndex() > index {
-			return b.buffer[i:], ErrIncompleteResult
+			return buffer[i:], ErrIncompleteResult
 		}
 	}
 	return nil, ErrNoBufferedMessages
@@ -76,10 +159,39 @@ func (b *ReplayBuffer[T]) MessagesAfter(index int32) ([]T, error) {
 func (b *ReplayBuffer[T]) ClearBefore(index int32) {
 	b.mx.Lock()
 	defer b.mx.Unlock()
+	if b.spilled() {
+		if err := b.spill.DropBefore(index); err != nil {
+			log.Println("Failed to drop spilled values:", err)
+		}
+	}
 	for i, msg := range b.buffer {
 		if msg.GetMessageIndex() >= index {
+			if b.spill != nil {
+				for _, v := range b.buffer[:i] {
+					b.bytes -= int64(b.sizeOf(v))
+				}
+			}
 			b.buffer = b.buffer[i:]
 			return
 		}
 	}
 }
+
+// Occupancy reports the values held in memory and spilled to disk.
+func (b *ReplayBuffer[T]) Occupancy() Occupancy {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+	o := Occupancy{Values: len(b.buffer), Bytes: b.bytes}
+	if b.spill != nil {
+		o.SpilledValues, o.SpilledBytes = b.spill.Size()
+	}
+	return o
+}
+
+func (b *ReplayBuffer[T]) spilled() bool {
+	if b.spill == nil {
+		return false
+	}
+	n, _ := b.spill.Size()
+	return n > 0
+}
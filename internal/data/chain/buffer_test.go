@@ -72,3 +72,67 @@ func TestReplayBuffer_ClearBeforeAndSize(t *testing.T) {
 		t.Fatalf("unexpected last after clear: %d %v", li2, err)
 	}
 }
+
+type memorySpill struct {
+	values []*idxMsg
+}
+
+func (s *memorySpill) Append(values ...*idxMsg) error {
+	s.values = append(s.values, values...)
+	return nil
+}
+
+func (s *memorySpill) ReadAfter(index int32) ([]*idxMsg, error) {
+	var out []*idxMsg
+	for _, v := range s.values {
+		if v.idx > index {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func (s *memorySpill) DropBefore(index int32) error {
+	for len(s.values) > 0 && s.values[0].idx < index {
+		s.values = s.values[1:]
+	}
+	return nil
+}
+
+func (s *memorySpill) Size() (int, int64) {
+	return len(s.values), int64(len(s.values)) * 10
+}
+
+func TestReplayBuffer_Spill(t *testing.T) {
+	spill := &memorySpill{}
+	b := NewSpillingReplayBuffer[*idxMsg](3, 25, func(*idxMsg) int { return 10 }, spill)
+	for i := int32(1); i <= 5; i++ {
+		if err := b.Add(&idxMsg{idx: i}); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	// 25 bytes fit two values in memory
+	o := b.Occupancy()
+	if o.Values != 2 || o.Bytes != 20 || o.SpilledValues != 3 {
+		t.Fatalf("unexpected occupancy: %+v", o)
+	}
+	msgs, err := b.MessagesAfter(1)
+	if err != nil || len(msgs) != 4 || msgs[0].GetMessageIndex() != 2 {
+		t.Fatalf("expected messages 2 to 5 got %v (%v)", msgs, err)
+	}
+	if msgs, err := b.MessagesAfter(4); err != nil || len(msgs) != 1 {
+		t.Fatalf("expected message 5 got %v (%v)", msgs, err)
+	}
+
+	b.ClearBefore(3)
+	if o := b.Occupancy(); o.SpilledValues != 1 || o.Values != 2 {
+		t.Fatalf("unexpected occupancy after clear: %+v", o)
+	}
+	if _, err := b.MessagesAfter(1); err != ErrIncompleteResult {
+		t.Fatalf("expected incomplete result got %v", err)
+	}
+	b.ClearBefore(5)
+	if o := b.Occupancy(); o.SpilledValues != 0 || o.Values != 1 || o.Bytes != 10 {
+		t.Fatalf("unexpected occupancy after clear: %+v", o)
+	}
+}
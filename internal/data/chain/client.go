@@ -13,13 +13,15 @@ import (
 )
 
 type Client struct {
-	ctx      context.Context
-	state    *NodeDFA
-	addr     chan string
-	requests chan *datalink.Message
-	replies  chan *datalink.Confirmation
-	data     handshake.ClientData
-	done     chan struct{}
+	ctx       context.Context
+	state     *NodeDFA
+	addr      chan string
+	requests  chan *datalink.Message
+	replies   chan *datalink.Confirmation
+	data      handshake.ClientData
+	transfers *handshake.Transfers
+	batching  stream.Batching
+	done      chan struct{}
 }
 
 func NewClient(
@@ -27,15 +29,18 @@ func NewClient(
 	state *NodeDFA,
 	data handshake.ClientData,
 	buffer int,
+	batching stream.Batching,
 ) *Client {
 	c := &Client{
-		ctx:      ctx,
-		state:    state,
-		data:     data,
-		addr:     make(chan string),
-		requests: make(chan *datalink.Message, buffer),
-		replies:  make(chan *datalink.Confirmation, buffer),
-		done:     make(chan struct{}),
+		ctx:       ctx,
+		state:     state,
+		data:      data,
+		transfers: handshake.NewTransfers(handshake.ChunkRecords),
+		batching:  batching,
+		addr:      make(chan string),
+		requests:  make(chan *datalink.Message, buffer),
+		replies:   make(chan *datalink.Confirmation, buffer),
+		done:      make(chan struct{}),
 	}
 	go c.run()
 	return c
@@ -95,7 +100,8 @@ func (c *Client) superviseConnection(addr string, ctx context.Context) {
 		rpcClient := rpc.NewClient(ctx, addr)
 		link := datalink.NewDataLinkClient(rpcClient)
 
-		if err := c.doHandshake(link); err != nil {
+		delivered, err := c.doHandshake(link, ctx)
+		if err != nil {
 			log.Println("handshake failed: ", err, " retrying in 5 seconds")
 			select {
 			case <-ctx.Done():
@@ -105,7 +111,7 @@ func (c *Client) superviseConnection(addr string, ctx context.Context) {
 			}
 		}
 
-		if err := c.superviseStream(link, ctx); err != nil {
+		if err := c.superviseStream(link, ctx, delivered); err != nil {
 			if errors.Is(err, errAddressChange) ||
 				errors.Is(err, context.Canceled) {
 				return
@@ -122,26 +128,44 @@ func (c *Client) superviseConnection(addr string, ctx context.Context) {
 
 }
 
-func (c *Client) doHandshake(link datalink.DataLinkClient) error {
+// doHandshake returns the index of the last message the successor holds. The
+// confirmations it was missing are queued with the ones from the stream, so
+// they are passed on to the predecessor as well.
+func (c *Client) doHandshake(link datalink.DataLinkClient, ctx context.Context) (int32, error) {
 	handshakeStream, err := link.Handshake(c.ctx)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	delivered, confirmations, err := handshake.Client(handshakeStream, c.data, c.transfers)
+	if err != nil {
+		return 0, err
+	}
+	for _, conf := range confirmations {
+		select {
+		case c.replies <- conf:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
 	}
-	return handshake.Client(handshakeStream, c.data)
+	return delivered, nil
 }
 
-func (c *Client) superviseStream(link datalink.DataLinkClient, ctx context.Context) error {
+// superviseStream sends the queued messages after delivered. A message that
+// could not be sent is not acknowledged by the successor, so the next
+// handshake resends it.
+func (c *Client) superviseStream(link datalink.DataLinkClient, ctx context.Context, delivered int32) error {
 	s, err := link.Replicate(ctx)
 	if err != nil {
 		return err
 	}
-	supervisor := stream.NewSupervisor(c.requests, c.replies)
-	defer func() {
-		if supervisor.DroppedMessage() != nil {
-			panic("dropped message")
-		}
-	}()
-	return supervisor.Run(ctx, s)
+	sent := newSequence[*datalink.Message](delivered)
+	supervisor := stream.NewSupervisor(c.requests, c.replies).Filter(sent.next)
+	batches := &messageBatches{stream: s}
+	err = supervisor.RunBatched(ctx, batches, c.batching)
+	if dropped := supervisor.DroppedMessage(); dropped != nil {
+		log.Println("Message", (*dropped).GetMessageIndex(), "was not delivered, resending after reconnect")
+	}
+	return err
 }
 
 func (c *Client) SetNextNode(addr string) error {
@@ -2,7 +2,9 @@ package handshake
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"seminarska/internal/data/storage/schema"
 	"seminarska/proto/datalink"
 
 	"google.golang.org/grpc"
@@ -14,17 +16,31 @@ type clientStream = grpc.BidiStreamingClient[
 ]
 
 type clientHandshake struct {
-	stream      clientStream
-	data        ClientData
-	serverHello *datalink.ServerHelo
+	stream        clientStream
+	data          ClientData
+	transfers     *Transfers
+	serverHello   *datalink.ServerHelo
+	delivered     int32
+	confirmations []*datalink.Confirmation
 }
 
-func Client(stream clientStream, data ClientData) error {
+// Client runs the handshake with the successor. It returns the index of the last
+// message the successor holds afterwards and the confirmations it is missing,
+// which are processed like the ones that arrive over the replication stream.
+func Client(
+	stream clientStream,
+	data ClientData,
+	transfers *Transfers,
+) (int32, []*datalink.Confirmation, error) {
 	handshake := &clientHandshake{
-		stream: stream,
-		data:   data,
+		stream:    stream,
+		data:      data,
+		transfers: transfers,
 	}
-	return run(handshake)
+	if err := run(handshake); err != nil {
+		return 0, nil, err
+	}
+	return handshake.delivered, handshake.confirmations, nil
 }
 
 func (c *clientHandshake) sendHello() error {
@@ -41,23 +57,54 @@ func (c *clientHandshake) receiveHello() error {
 		return errors.New("invalid handshake message: expected server hello")
 	}
 	c.serverHello = serverHello.Hello
+	// the successor could not read what is sent to it, so the link is refused
+	if v := c.serverHello.GetSchemaVersion(); v < schema.Version {
+		return fmt.Errorf("%w: successor reads schema versions up to %d, this node writes %d",
+			schema.ErrUnsupported, v, schema.Version)
+	}
 	return nil
 }
 
 func (c *clientHandshake) sendMissingData() error {
 	hello := c.serverHello
 	if hello.GetRequestTransfer() {
-		log.Println("Sending full DB snapshot")
-		return c.stream.Send(c.snapshotMsg())
+		return c.sendSnapshot(hello.GetResume())
 	}
 	log.Println("successor: last message index:", c.serverHello.GetLastMsgIndex())
-	return c.stream.Send(c.syncMsg(hello.GetLastMsgIndex()))
+	messages, err := c.data.GetMessagesAfter(hello.GetLastMsgIndex())
+	if err != nil {
+		log.Println("Cannot replay messages to the successor, sending a snapshot instead:", err)
+		return c.sendSnapshot(hello.GetResume())
+	}
+	c.delivered = hello.GetLastMsgIndex()
+	if len(messages) > 0 {
+		c.delivered = messages[len(messages)-1].GetMessageIndex()
+	}
+	return c.stream.Send(c.syncMsg(messages))
 }
 
-func (c *clientHandshake) receiveMissingData() error {
-	if c.serverHello.GetRequestTransfer() {
-		return nil
+func (c *clientHandshake) sendSnapshot(resume *datalink.SnapshotResume) error {
+	chunks, err := c.transfers.outgoingChunks(resume, c.data.GetSnapshot)
+	if err != nil {
+		return err
+	}
+	if chunks[0].GetSequence() == 0 {
+		log.Println("Sending DB snapshot in", len(chunks), "chunks")
+	} else {
+		log.Println("Resuming DB snapshot at chunk", chunks[0].GetSequence())
 	}
+	for _, chunk := range chunks {
+		if err := c.stream.Send(c.chunkMsg(chunk)); err != nil {
+			return err
+		}
+	}
+	c.delivered = c.transfers.sent()
+	return nil
+}
+
+// receiveMissingData reads the confirmations the successor sends after its hello,
+// also when it requested a transfer.
+func (c *clientHandshake) receiveMissingData() error {
 	received, err := c.stream.Recv()
 	if err != nil {
 		return err
@@ -67,7 +114,7 @@ func (c *clientHandshake) receiveMissingData() error {
 		return errors.New("invalid handshake message: expected server sync")
 	}
 	log.Println("Received missing confirmations")
-	c.data.ProcessConfirmations(confirmations.Sync.GetConfirmations())
+	c.confirmations = confirmations.Sync.GetConfirmations()
 	return nil
 }
 
@@ -76,25 +123,26 @@ func (c *clientHandshake) helloMsg() *datalink.ClientHandshakeMsg {
 		Payload: &datalink.ClientHandshakeMsg_Hello{
 			Hello: &datalink.ClientHello{
 				LastConfIndex: c.data.LastConfirmationIndex(),
+				SchemaVersion: schema.Version,
 			},
 		},
 	}
 }
 
-func (c *clientHandshake) syncMsg(after int32) *datalink.ClientHandshakeMsg {
+func (c *clientHandshake) syncMsg(messages []*datalink.Message) *datalink.ClientHandshakeMsg {
 	return &datalink.ClientHandshakeMsg{
 		Payload: &datalink.ClientHandshakeMsg_Sync{
 			Sync: &datalink.ClientSync{
-				Messages: c.data.GetMessagesAfter(after),
+				Messages: messages,
 			},
 		},
 	}
 }
 
-func (c *clientHandshake) snapshotMsg() *datalink.ClientHandshakeMsg {
+func (c *clientHandshake) chunkMsg(chunk *datalink.SnapshotChunk) *datalink.ClientHandshakeMsg {
 	return &datalink.ClientHandshakeMsg{
-		Payload: &datalink.ClientHandshakeMsg_Db{
-			Db: c.data.GetSnapshot(),
+		Payload: &datalink.ClientHandshakeMsg_Chunk{
+			Chunk: chunk,
 		},
 	}
 }
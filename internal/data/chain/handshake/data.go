@@ -5,14 +5,13 @@ import "seminarska/proto/datalink"
 type ServerData interface {
 	LastMessageIndex() int32
 	GetConfirmationsAfter(int32) []*datalink.Confirmation
-	ProcessMessages([]*datalink.Message)
 	DatabaseImporter
 }
 
 type ClientData interface {
 	LastConfirmationIndex() int32
-	GetMessagesAfter(int32) []*datalink.Message
-	ProcessConfirmations([]*datalink.Confirmation)
+	// GetMessagesAfter fails unless it can return every message after the index
+	GetMessagesAfter(int32) ([]*datalink.Message, error)
 	DatabaseExporter
 }
 
@@ -20,8 +19,12 @@ type DatabaseExporter interface {
 	GetSnapshot() *datalink.DatabaseSnapshot
 }
 
+// DatabaseImporter applies a snapshot that arrives in chunks: BeginSnapshot empties
+// the database, the chunks are applied in order and FinishSnapshot completes it.
 type DatabaseImporter interface {
-	SetFromSnapshot(snapshot *datalink.DatabaseSnapshot)
+	BeginSnapshot() error
+	ApplySnapshotChunk(chunk *datalink.DatabaseSnapshot) error
+	FinishSnapshot(opCount int32) error
 }
 
 type DatabaseTransfer interface {
@@ -0,0 +1,152 @@
+package handshake
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"seminarska/internal/data/storage/schema"
+	"seminarska/proto/datalink"
+
+	"google.golang.org/grpc"
+)
+
+// pipe carries handshake messages between a client and a server in memory.
+type pipe struct {
+	up   chan *datalink.ClientHandshakeMsg
+	down chan *datalink.ServerHandshakeMsg
+}
+
+func newPipe() *pipe {
+	return &pipe{
+		up:   make(chan *datalink.ClientHandshakeMsg, 16),
+		down: make(chan *datalink.ServerHandshakeMsg, 16),
+	}
+}
+
+type pipeServer struct {
+	grpc.ServerStream
+	*pipe
+}
+
+func (p pipeServer) Send(msg *datalink.ServerHandshakeMsg) error {
+	p.down <- msg
+	return nil
+}
+
+func (p pipeServer) Recv() (*datalink.ClientHandshakeMsg, error) {
+	msg, ok := <-p.up
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+type pipeClient struct {
+	grpc.ClientStream
+	*pipe
+}
+
+func (p pipeClient) Send(msg *datalink.ClientHandshakeMsg) error {
+	p.up <- msg
+	return nil
+}
+
+func (p pipeClient) Recv() (*datalink.ServerHandshakeMsg, error) {
+	msg, ok := <-p.down
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+func (p pipeClient) Context() context.Context { return context.Background() }
+
+type fakeServerData struct {
+	fakeImporter
+	lastMessage   int32
+	confirmations []*datalink.Confirmation
+}
+
+func (f *fakeServerData) LastMessageIndex() int32 { return f.lastMessage }
+
+func (f *fakeServerData) GetConfirmationsAfter(index int32) []*datalink.Confirmation {
+	var after []*datalink.Confirmation
+	for _, c := range f.confirmations {
+		if c.GetMessageIndex() > index {
+			after = append(after, c)
+		}
+	}
+	return after
+}
+
+type fakeClientData struct {
+	lastConfirmation int32
+}
+
+func (f *fakeClientData) LastConfirmationIndex() int32 { return f.lastConfirmation }
+
+func (f *fakeClientData) GetMessagesAfter(int32) ([]*datalink.Message, error) {
+	return nil, errors.New("log truncated")
+}
+
+func (f *fakeClientData) GetSnapshot() *datalink.DatabaseSnapshot { return testSnapshot() }
+
+func TestHandshake_TransferKeepsConfirmations(t *testing.T) {
+	// the successor holds messages, but an import it started was never finished
+	server := &fakeServerData{lastMessage: 9, confirmations: []*datalink.Confirmation{
+		{MessageIndex: 6, Ok: true}, {MessageIndex: 7, Ok: true}, {MessageIndex: 8, Ok: true},
+	}}
+	serverTransfers := NewTransfers(2)
+	serverTransfers.required = true
+
+	p := newPipe()
+	type serverResult struct {
+		delivered int32
+		err       error
+	}
+	done := make(chan serverResult, 1)
+	go func() {
+		delivered, _, err := Server(pipeServer{pipe: p}, server, serverTransfers)
+		done <- serverResult{delivered, err}
+	}()
+	sent, confirmations, err := Client(pipeClient{pipe: p}, &fakeClientData{lastConfirmation: 6}, NewTransfers(2))
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("server: %v", res.err)
+	}
+
+	if server.begun != 1 || server.finished != 9 || sent != 9 {
+		t.Fatalf("expected a transfer up to 9, got %+v and %d sent", server.fakeImporter, sent)
+	}
+	if len(confirmations) != 2 || confirmations[0].GetMessageIndex() != 7 || confirmations[1].GetMessageIndex() != 8 {
+		t.Fatalf("expected the predecessor to receive confirmations 7 and 8, got %v", confirmations)
+	}
+	if res.delivered != 8 {
+		t.Fatalf("expected the predecessor to hold confirmation 8, got %d", res.delivered)
+	}
+}
+
+func TestHandshake_SyncInsteadOfTransfer(t *testing.T) {
+	p := newPipe()
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := Server(pipeServer{pipe: p}, &fakeServerData{lastMessage: -1}, NewTransfers(2))
+		done <- err
+	}()
+	// a predecessor that ignores the request and sends messages instead
+	p.up <- &datalink.ClientHandshakeMsg{Payload: &datalink.ClientHandshakeMsg_Hello{
+		Hello: &datalink.ClientHello{SchemaVersion: schema.Version},
+	}}
+	if hello := (<-p.down).GetHello(); !hello.GetRequestTransfer() {
+		t.Fatalf("expected an empty successor to request a transfer")
+	}
+	p.up <- &datalink.ClientHandshakeMsg{Payload: &datalink.ClientHandshakeMsg_Sync{Sync: &datalink.ClientSync{}}}
+	if err := <-done; err == nil {
+		t.Fatalf("expected the server to refuse messages in place of the snapshot")
+	}
+}
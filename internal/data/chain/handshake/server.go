@@ -2,7 +2,9 @@ package handshake
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"seminarska/internal/data/storage/schema"
 	"seminarska/proto/datalink"
 
 	"google.golang.org/grpc"
@@ -16,15 +18,30 @@ type serverStream = grpc.BidiStreamingServer[
 type serverHandshake struct {
 	data        ServerData
 	stream      serverStream
+	transfers   *Transfers
 	clientHello *datalink.ClientHello
+	transfer    bool // requested in the hello, so only a snapshot is accepted
+	delivered   int32
+	messages    []*datalink.Message
 }
 
-func Server(stream serverStream, data ServerData) error {
+// Server runs the handshake with the predecessor. It returns the index of the
+// last confirmation the predecessor holds afterwards and the messages it sent,
+// which are processed like the ones that arrive over the replication stream.
+func Server(
+	stream serverStream,
+	data ServerData,
+	transfers *Transfers,
+) (int32, []*datalink.Message, error) {
 	handshake := &serverHandshake{
-		data:   data,
-		stream: stream,
+		data:      data,
+		stream:    stream,
+		transfers: transfers,
 	}
-	return run(handshake)
+	if err := run(handshake); err != nil {
+		return 0, nil, err
+	}
+	return handshake.delivered, handshake.messages, nil
 }
 
 func (s *serverHandshake) receiveHello() error {
@@ -38,61 +55,99 @@ func (s *serverHandshake) receiveHello() error {
 	}
 	s.clientHello = clientHello.Hello
 	log.Println("Client: last confirmation index:", s.clientHello.GetLastConfIndex())
+	if err := schema.Check(s.clientHello.GetSchemaVersion()); err != nil {
+		return fmt.Errorf("refusing predecessor: %w", err)
+	}
 	return nil
 }
 
 func (s *serverHandshake) sendHello() error {
-	return s.stream.Send(s.helloMsg())
+	msg := s.helloMsg()
+	s.transfer = msg.GetHello().GetRequestTransfer()
+	return s.stream.Send(msg)
 }
 
 func (s *serverHandshake) receiveMissingData() error {
-	received, err := s.stream.Recv()
-	if err != nil {
-		return err
+	for {
+		received, err := s.stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		switch r := received.Payload.(type) {
+		case *datalink.ClientHandshakeMsg_Sync:
+			if s.transfer {
+				return errors.New("invalid handshake message: expected the requested snapshot")
+			}
+			log.Println("Received missing messages")
+			s.messages = r.Sync.GetMessages()
+			return nil
+		case *datalink.ClientHandshakeMsg_Chunk:
+			done, pending, err := s.transfers.receive(r.Chunk, s.data)
+			if err != nil {
+				return err
+			}
+			if done {
+				log.Println("Received DB snapshot in", r.Chunk.GetTotal(), "chunks")
+				s.messages = pending
+				return nil
+			}
+		case *datalink.ClientHandshakeMsg_Db:
+			log.Println("Received full DB snapshot")
+			return s.importSnapshot(r.Db)
+		default:
+			return errors.New("invalid handshake message: expected client sync or db snapshot")
+		}
 	}
+}
 
-	switch r := received.Payload.(type) {
-	case *datalink.ClientHandshakeMsg_Sync:
-		log.Println("Received missing messages")
-		s.data.ProcessMessages(r.Sync.GetMessages())
-	case *datalink.ClientHandshakeMsg_Db:
-		log.Println("Received full DB snapshot")
-		s.data.SetFromSnapshot(r.Db)
-	default:
-		return errors.New("invalid handshake message: expected client sync or db snapshot")
+// importSnapshot applies a snapshot sent whole, as a single chunk.
+func (s *serverHandshake) importSnapshot(snapshot *datalink.DatabaseSnapshot) error {
+	if err := s.transfers.importWhole(snapshot, s.data); err != nil {
+		return err
 	}
+	s.messages = snapshot.GetPendingRequests()
 	return nil
 }
 
+// sendMissingData sends the confirmations the predecessor lacks. They are sent
+// whether or not a transfer was requested, as the predecessor reads them either way.
 func (s *serverHandshake) sendMissingData() error {
-	if s.data.LastMessageIndex() == -1 {
-		return nil
-	}
 	hello := s.clientHello
 	if hello == nil {
 		panic("illegal data")
 	}
-	last := hello.GetLastConfIndex()
-	return s.stream.Send(s.syncMsg(last))
+	s.delivered = hello.GetLastConfIndex()
+	var confirmations []*datalink.Confirmation
+	if s.data.LastMessageIndex() != -1 {
+		confirmations = s.data.GetConfirmationsAfter(s.delivered)
+	}
+	if len(confirmations) > 0 {
+		s.delivered = confirmations[len(confirmations)-1].GetMessageIndex()
+	}
+	return s.stream.Send(s.syncMsg(confirmations))
 }
 
 func (s *serverHandshake) helloMsg() *datalink.ServerHandshakeMsg {
 	lastMsg := s.data.LastMessageIndex()
+	// a snapshot that broke off left the database incomplete, so it has to be finished
+	resume := s.transfers.resume()
+	hello := &datalink.ServerHelo{
+		LastMsgIndex:    lastMsg,
+		RequestTransfer: lastMsg == -1 || resume != nil || s.transfers.transferRequired(),
+		Resume:          resume,
+		SchemaVersion:   schema.Version,
+	}
 	return &datalink.ServerHandshakeMsg{
-		Payload: &datalink.ServerHandshakeMsg_Hello{
-			Hello: &datalink.ServerHelo{
-				LastMsgIndex:    lastMsg,
-				RequestTransfer: lastMsg == -1,
-			},
-		},
+		Payload: &datalink.ServerHandshakeMsg_Hello{Hello: hello},
 	}
 }
 
-func (s *serverHandshake) syncMsg(after int32) *datalink.ServerHandshakeMsg {
+func (s *serverHandshake) syncMsg(confirmations []*datalink.Confirmation) *datalink.ServerHandshakeMsg {
 	return &datalink.ServerHandshakeMsg{
 		Payload: &datalink.ServerHandshakeMsg_Sync{
 			Sync: &datalink.ServerSync{
-				Confirmations: s.data.GetConfirmationsAfter(after),
+				Confirmations: confirmations,
 			},
 		},
 	}
@@ -0,0 +1,308 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"seminarska/proto/datalink"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// ChunkBytes bounds the encoded records in a snapshot chunk, which keeps chunks
+// far below gRPC's message size limit of 4 MB.
+const ChunkBytes = 1 << 20
+
+// ChunkRecords bounds the records in a snapshot chunk as well, so a chunk of
+// small records is not slow to import either.
+const ChunkRecords = 500
+
+// maxResumeAge bounds how long a broken transfer can be resumed; the predecessor
+// may no longer hold the messages that followed an older snapshot.
+const maxResumeAge = time.Minute
+
+var (
+	ErrChunkOrder = errors.New("snapshot chunk out of order")
+	ErrChecksum   = errors.New("snapshot checksum mismatch")
+)
+
+// Transfers remembers snapshot transfers across handshakes, so a transfer that
+// broke off resumes where it stopped instead of starting over.
+type Transfers struct {
+	mx           sync.Mutex
+	chunkRecords int
+	chunkBytes   int
+	sending      *outgoing
+	receiving    *incoming
+	// required is set from the start of an import until it is finished, as
+	// the database is incomplete in between, even if the import failed
+	required bool
+}
+
+type outgoing struct {
+	chunks  []*datalink.SnapshotChunk
+	opCount int32
+	created time.Time
+}
+
+type incoming struct {
+	id      string
+	next    int32
+	opCount int32
+	pending []*datalink.Message
+	sum     hash.Hash
+}
+
+func NewTransfers(chunkRecords int) *Transfers {
+	return &Transfers{chunkRecords: chunkRecords, chunkBytes: ChunkBytes}
+}
+
+// outgoingChunks returns the chunks the successor is missing: the rest of the
+// transfer it resumes, or every chunk of a new snapshot.
+func (t *Transfers) outgoingChunks(
+	resume *datalink.SnapshotResume,
+	export func() *datalink.DatabaseSnapshot,
+) ([]*datalink.SnapshotChunk, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if out := t.sending; out != nil && resume != nil && time.Since(out.created) < maxResumeAge &&
+		resume.GetTransferId() == out.chunks[0].GetTransferId() &&
+		resume.GetNextChunk() >= 0 && int(resume.GetNextChunk()) < len(out.chunks) {
+		return out.chunks[resume.GetNextChunk():], nil
+	}
+	snapshot := export()
+	chunks, err := newChunks(snapshot, t.chunkRecords, t.chunkBytes)
+	if err != nil {
+		return nil, err
+	}
+	t.sending = &outgoing{chunks: chunks, opCount: heldCount(snapshot), created: time.Now()}
+	return chunks, nil
+}
+
+// sent forgets the outgoing transfer once all of it is sent and returns the
+// index of the last message it holds.
+func (t *Transfers) sent() int32 {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	opCount := t.sending.opCount
+	t.sending = nil
+	return opCount
+}
+
+// resume returns the progress of a transfer that broke off, or nil.
+func (t *Transfers) resume() *datalink.SnapshotResume {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.receiving == nil {
+		return nil
+	}
+	return &datalink.SnapshotResume{
+		TransferId: t.receiving.id,
+		NextChunk:  t.receiving.next,
+	}
+}
+
+// transferRequired reports whether an import started and was not finished, so
+// the node needs a snapshot whatever messages it holds.
+func (t *Transfers) transferRequired() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.required
+}
+
+// begin empties the database for an import, which is required from now on.
+// The caller holds the lock.
+func (t *Transfers) begin(data DatabaseImporter) error {
+	t.required = true
+	return data.BeginSnapshot()
+}
+
+// finish completes an import; only then the database is whole again. The
+// caller holds the lock.
+func (t *Transfers) finish(data DatabaseImporter, opCount int32) error {
+	if err := data.FinishSnapshot(opCount); err != nil {
+		return err
+	}
+	t.required = false
+	return nil
+}
+
+// importWhole applies a snapshot sent in a single message.
+func (t *Transfers) importWhole(snapshot *datalink.DatabaseSnapshot, data DatabaseImporter) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.receiving = nil
+	if err := t.begin(data); err != nil {
+		return err
+	}
+	if err := data.ApplySnapshotChunk(snapshot); err != nil {
+		return err
+	}
+	return t.finish(data, confirmedCount(snapshot.GetOpCount(), snapshot.GetPendingRequests()))
+}
+
+// receive applies a chunk to the database and reports whether it completed the transfer.
+// A chunk starting a new transfer empties the database first. A completed transfer
+// returns the messages that were pending in the snapshot, which are processed
+// after it like any other message. Each chunk is checked against its checksum
+// before it is applied; a chunk that fails to apply abandons the transfer, and
+// the next handshake requests a new one.
+func (t *Transfers) receive(
+	chunk *datalink.SnapshotChunk,
+	data DatabaseImporter,
+) (bool, []*datalink.Message, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	in := t.receiving
+	if in == nil || in.id != chunk.GetTransferId() {
+		if chunk.GetSequence() != 0 {
+			return false, nil, ErrChunkOrder
+		}
+		if err := t.begin(data); err != nil {
+			return false, nil, err
+		}
+		in = &incoming{id: chunk.GetTransferId(), sum: sha256.New()}
+		t.receiving = in
+	}
+	if chunk.GetSequence() != in.next {
+		return false, nil, ErrChunkOrder
+	}
+	if sum := sha256.Sum256(chunk.GetPart()); !bytes.Equal(sum[:], chunk.GetPartChecksum()) {
+		// nothing of the chunk was applied, so it can be sent again
+		return false, nil, ErrChecksum
+	}
+	part := &datalink.DatabaseSnapshot{}
+	if err := proto.Unmarshal(chunk.GetPart(), part); err != nil {
+		t.receiving = nil
+		return false, nil, err
+	}
+	if err := data.ApplySnapshotChunk(part); err != nil {
+		// the database holds part of the chunk, so the transfer starts over
+		t.receiving = nil
+		return false, nil, err
+	}
+	if chunk.GetSequence() == 0 {
+		in.opCount = part.GetOpCount()
+	}
+	in.pending = append(in.pending, part.GetPendingRequests()...)
+	in.sum.Write(chunk.GetPart())
+	in.next++
+	if in.next < chunk.GetTotal() {
+		return false, nil, nil
+	}
+	t.receiving = nil
+	if !bytes.Equal(in.sum.Sum(nil), chunk.GetChecksum()) {
+		return false, nil, ErrChecksum
+	}
+	if err := t.finish(data, confirmedCount(in.opCount, in.pending)); err != nil {
+		return false, nil, err
+	}
+	return true, in.pending, nil
+}
+
+// confirmedCount returns the index of the last operation the relations of a
+// snapshot hold; the pending messages follow it.
+func confirmedCount(opCount int32, pending []*datalink.Message) int32 {
+	if len(pending) > 0 {
+		return pending[0].GetMessageIndex() - 1
+	}
+	return opCount
+}
+
+// heldCount returns the index of the last message a successor holds once it
+// imported a snapshot and processed its pending messages.
+func heldCount(snapshot *datalink.DatabaseSnapshot) int32 {
+	if pending := snapshot.GetPendingRequests(); len(pending) > 0 {
+		return max(snapshot.GetOpCount(), pending[len(pending)-1].GetMessageIndex())
+	}
+	return snapshot.GetOpCount()
+}
+
+func newChunks(snapshot *datalink.DatabaseSnapshot, records, bytes int) ([]*datalink.SnapshotChunk, error) {
+	parts := splitSnapshot(snapshot, records, bytes)
+	id := uuid.NewString()
+	sum := sha256.New()
+	chunks := make([]*datalink.SnapshotChunk, len(parts))
+	for i, part := range parts {
+		data, err := proto.Marshal(part)
+		if err != nil {
+			return nil, err
+		}
+		sum.Write(data)
+		partSum := sha256.Sum256(data)
+		chunks[i] = &datalink.SnapshotChunk{
+			TransferId:   id,
+			Sequence:     int32(i),
+			Total:        int32(len(parts)),
+			Part:         data,
+			PartChecksum: partSum[:],
+		}
+	}
+	checksum := sum.Sum(nil)
+	for _, chunk := range chunks {
+		chunk.Checksum = checksum
+	}
+	return chunks, nil
+}
+
+// splitSnapshot splits a snapshot into parts of records of a single relation, at
+// most records of them and at most bytes of them encoded; a record larger than
+// that gets a part of its own. The first part only carries the op count and
+// digest, the rest follow in the order the relations are imported. Every part
+// carries the schema version, since each is migrated on its own.
+func splitSnapshot(snapshot *datalink.DatabaseSnapshot, records, bytes int) []*datalink.DatabaseSnapshot {
+	parts := []*datalink.DatabaseSnapshot{{
+		OpCount:     snapshot.GetOpCount(),
+		DigestIndex: snapshot.GetDigestIndex(),
+		Digest:      snapshot.GetDigest(),
+
+		SchemaVersion: snapshot.GetSchemaVersion(),
+	}}
+	split := func(sizes []int, fill func(part *datalink.DatabaseSnapshot, from, to int)) {
+		for from := 0; from < len(sizes); {
+			to, size := from+1, sizes[from]
+			for to < len(sizes) && to-from < records && size+sizes[to] <= bytes {
+				size += sizes[to]
+				to++
+			}
+			part := &datalink.DatabaseSnapshot{SchemaVersion: snapshot.GetSchemaVersion()}
+			fill(part, from, to)
+			parts = append(parts, part)
+			from = to
+		}
+	}
+	split(encodedSizes(snapshot.Users), func(part *datalink.DatabaseSnapshot, from, to int) {
+		part.Users = snapshot.Users[from:to]
+	})
+	split(encodedSizes(snapshot.Topics), func(part *datalink.DatabaseSnapshot, from, to int) {
+		part.Topics = snapshot.Topics[from:to]
+	})
+	split(encodedSizes(snapshot.Messages), func(part *datalink.DatabaseSnapshot, from, to int) {
+		part.Messages = snapshot.Messages[from:to]
+	})
+	split(encodedSizes(snapshot.Likes), func(part *datalink.DatabaseSnapshot, from, to int) {
+		part.Likes = snapshot.Likes[from:to]
+	})
+	split(encodedSizes(snapshot.Revisions), func(part *datalink.DatabaseSnapshot, from, to int) {
+		part.Revisions = snapshot.Revisions[from:to]
+	})
+	split(encodedSizes(snapshot.PendingRequests), func(part *datalink.DatabaseSnapshot, from, to int) {
+		part.PendingRequests = snapshot.PendingRequests[from:to]
+	})
+	return parts
+}
+
+// encodedSizes returns the size of each record within an encoded snapshot, which
+// includes the tag and length in front of it.
+func encodedSizes[T proto.Message](records []T) []int {
+	sizes := make([]int, len(records))
+	for i, record := range records {
+		sizes[i] = protowire.SizeTag(protowire.MaxValidNumber) + protowire.SizeBytes(proto.Size(record))
+	}
+	return sizes
+}
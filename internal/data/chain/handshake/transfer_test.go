@@ -0,0 +1,247 @@
+package handshake
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"seminarska/proto/datalink"
+	"seminarska/proto/razpravljalnica"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeImporter struct {
+	begun     int
+	users     []int64
+	messages  []int64
+	finished  int32
+	failApply error
+}
+
+func (f *fakeImporter) BeginSnapshot() error {
+	f.begun++
+	f.users, f.messages, f.finished = nil, nil, 0
+	return nil
+}
+
+func (f *fakeImporter) ApplySnapshotChunk(chunk *datalink.DatabaseSnapshot) error {
+	if f.failApply != nil {
+		return f.failApply
+	}
+	for _, u := range chunk.Users {
+		f.users = append(f.users, u.Id)
+	}
+	for _, m := range chunk.Messages {
+		f.messages = append(f.messages, m.Id)
+	}
+	return nil
+}
+
+func (f *fakeImporter) FinishSnapshot(opCount int32) error {
+	f.finished = opCount
+	return nil
+}
+
+func testSnapshot() *datalink.DatabaseSnapshot {
+	snapshot := &datalink.DatabaseSnapshot{OpCount: 9}
+	for i := int64(1); i <= 3; i++ {
+		snapshot.Users = append(snapshot.Users, &razpravljalnica.User{Id: i})
+	}
+	for i := int64(4); i <= 8; i++ {
+		snapshot.Messages = append(snapshot.Messages, &razpravljalnica.Message{Id: i})
+	}
+	return snapshot
+}
+
+func TestTransfers_Chunks(t *testing.T) {
+	sender, receiver := NewTransfers(2), NewTransfers(2)
+	chunks, err := sender.outgoingChunks(nil, testSnapshot)
+	if err != nil {
+		t.Fatalf("chunks: %v", err)
+	}
+	// the op count, two chunks of users and three of messages
+	if len(chunks) != 6 {
+		t.Fatalf("expected 6 chunks got %d", len(chunks))
+	}
+	data := &fakeImporter{}
+	for i, chunk := range chunks {
+		done, _, err := receiver.receive(chunk, data)
+		if err != nil {
+			t.Fatalf("receive chunk %d: %v", i, err)
+		}
+		if done != (i == len(chunks)-1) {
+			t.Fatalf("chunk %d: unexpected done %v", i, done)
+		}
+	}
+	if data.begun != 1 || len(data.users) != 3 || len(data.messages) != 5 || data.finished != 9 {
+		t.Fatalf("unexpected import: %+v", data)
+	}
+	if receiver.resume() != nil {
+		t.Fatalf("finished transfer should not be resumed")
+	}
+}
+
+func TestTransfers_Resume(t *testing.T) {
+	sender, receiver := NewTransfers(2), NewTransfers(2)
+	chunks, _ := sender.outgoingChunks(nil, testSnapshot)
+	data := &fakeImporter{}
+	for _, chunk := range chunks[:3] {
+		if _, _, err := receiver.receive(chunk, data); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+	}
+	// the connection breaks, the next handshake resumes the transfer
+	resume := receiver.resume()
+	if resume.GetNextChunk() != 3 {
+		t.Fatalf("expected to resume at 3 got %d", resume.GetNextChunk())
+	}
+	rest, err := sender.outgoingChunks(resume, func() *datalink.DatabaseSnapshot {
+		t.Fatalf("resumed transfer should not export a new snapshot")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("chunks: %v", err)
+	}
+	if len(rest) != 3 || rest[0].GetSequence() != 3 {
+		t.Fatalf("unexpected resumed chunks: %d from %d", len(rest), rest[0].GetSequence())
+	}
+	if _, _, err := receiver.receive(rest[1], data); !errors.Is(err, ErrChunkOrder) {
+		t.Fatalf("expected chunk order error got %v", err)
+	}
+	for _, chunk := range rest {
+		if _, _, err := receiver.receive(chunk, data); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+	}
+	if data.begun != 1 || len(data.users) != 3 || len(data.messages) != 5 || data.finished != 9 {
+		t.Fatalf("unexpected import: %+v", data)
+	}
+	sender.sent()
+
+	// an unknown transfer is sent from the start
+	chunks, _ = sender.outgoingChunks(resume, testSnapshot)
+	if len(chunks) != 6 || chunks[0].GetTransferId() == resume.GetTransferId() {
+		t.Fatalf("expected a new transfer")
+	}
+}
+
+func TestTransfers_Checksum(t *testing.T) {
+	sender, receiver := NewTransfers(4), NewTransfers(4)
+	chunks, _ := sender.outgoingChunks(nil, testSnapshot)
+	chunks[len(chunks)-1].Checksum = []byte("corrupt")
+	data := &fakeImporter{}
+	var err error
+	for _, chunk := range chunks {
+		if _, _, err = receiver.receive(chunk, data); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected checksum error got %v", err)
+	}
+	if data.finished != 0 || receiver.resume() != nil {
+		t.Fatalf("corrupt transfer should neither finish nor resume")
+	}
+}
+
+func TestSplitSnapshot_SchemaVersion(t *testing.T) {
+	snapshot := testSnapshot()
+	snapshot.SchemaVersion = 3
+	for i, part := range splitSnapshot(snapshot, 2, ChunkBytes) {
+		if part.GetSchemaVersion() != 3 {
+			t.Fatalf("part %d: expected schema version 3 got %d", i, part.GetSchemaVersion())
+		}
+	}
+}
+
+func TestSplitSnapshot_Bytes(t *testing.T) {
+	snapshot := &datalink.DatabaseSnapshot{}
+	for i, size := range []int{100, 100, 100, 500, 100} {
+		snapshot.Messages = append(snapshot.Messages, &razpravljalnica.Message{
+			Id:   int64(i + 1),
+			Text: strings.Repeat("x", size),
+		})
+	}
+	const budget = 250
+	var ids [][]int64
+	for _, part := range splitSnapshot(snapshot, 10, budget)[1:] {
+		var partIds []int64
+		for _, m := range part.GetMessages() {
+			partIds = append(partIds, m.GetId())
+		}
+		ids = append(ids, partIds)
+		if size := proto.Size(part); len(partIds) > 1 && size > budget {
+			t.Fatalf("part %v is %d bytes, over the budget of %d", partIds, size, budget)
+		}
+	}
+	// two small messages fit the budget, the large one gets a part of its own
+	want := [][]int64{{1, 2}, {3}, {4}, {5}}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("expected parts %v got %v", want, ids)
+	}
+}
+
+func TestTransfers_Pending(t *testing.T) {
+	sender, receiver := NewTransfers(2), NewTransfers(2)
+	chunks, _ := sender.outgoingChunks(nil, func() *datalink.DatabaseSnapshot {
+		snapshot := testSnapshot()
+		snapshot.PendingRequests = []*datalink.Message{{MessageIndex: 8}, {MessageIndex: 9}}
+		return snapshot
+	})
+	data := &fakeImporter{}
+	var pending []*datalink.Message
+	for _, chunk := range chunks {
+		_, p, err := receiver.receive(chunk, data)
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		pending = append(pending, p...)
+	}
+	// the relations hold the state before the first pending message
+	if data.finished != 7 || len(pending) != 2 || pending[0].GetMessageIndex() != 8 {
+		t.Fatalf("unexpected import: finished at %d with %d pending", data.finished, len(pending))
+	}
+	if delivered := sender.sent(); delivered != 9 {
+		t.Fatalf("expected the successor to hold 9 got %d", delivered)
+	}
+}
+
+func TestTransfers_Corrupted(t *testing.T) {
+	sender, receiver := NewTransfers(2), NewTransfers(2)
+	chunks, _ := sender.outgoingChunks(nil, testSnapshot)
+	data := &fakeImporter{}
+	if _, _, err := receiver.receive(chunks[0], data); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	corrupted := proto.Clone(chunks[1]).(*datalink.SnapshotChunk)
+	corrupted.Part[len(corrupted.Part)-1] ^= 1
+	if _, _, err := receiver.receive(corrupted, data); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected checksum error got %v", err)
+	}
+	if len(data.users) != 0 {
+		t.Fatalf("corrupted chunk was applied: %v", data.users)
+	}
+	if resume := receiver.resume(); resume.GetNextChunk() != 1 {
+		t.Fatalf("expected to resume at the corrupted chunk, got %v", resume)
+	}
+
+	// a chunk that fails to apply abandons the transfer, but a new one is still required
+	data.failApply = errors.New("disk full")
+	if _, _, err := receiver.receive(chunks[1], data); err == nil {
+		t.Fatalf("expected apply error")
+	}
+	if receiver.resume() != nil || !receiver.transferRequired() {
+		t.Fatalf("expected a new transfer to be required")
+	}
+	data.failApply = nil
+	for _, chunk := range chunks {
+		if _, _, err := receiver.receive(chunk, data); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+	}
+	if receiver.transferRequired() || data.finished != 9 {
+		t.Fatalf("expected the finished transfer to clear the requirement")
+	}
+}
@@ -0,0 +1,22 @@
+package chain
+
+// sequence numbers the values sent over a link by their message index. It
+// starts at the last index the peer acknowledged in the handshake, which also
+// resent everything after it, so a value queued before that is not sent twice.
+// It is used by a single sending goroutine.
+type sequence[T Indexable] struct {
+	last int32
+}
+
+func newSequence[T Indexable](acknowledged int32) *sequence[T] {
+	return &sequence[T]{last: acknowledged}
+}
+
+// next reports whether v was not sent yet and marks it sent.
+func (s *sequence[T]) next(v T) bool {
+	if v.GetMessageIndex() <= s.last {
+		return false
+	}
+	s.last = v.GetMessageIndex()
+	return true
+}
@@ -2,16 +2,23 @@ package chain
 
 import (
 	"context"
+	"errors"
 	"log"
 	"seminarska/internal/data/chain/handshake"
+	"seminarska/internal/data/chain/stream"
 	"seminarska/proto/controllink"
 	"seminarska/proto/datalink"
 	"sync"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // MessageProducer produces messages at the head of the chain
 type MessageProducer interface {
 	Messages() <-chan *datalink.Message
+	// RunAtHead runs the producer's own work while the node is the head, until ctx is done
+	RunAtHead(ctx context.Context)
 }
 
 // MessageInterceptor intercepts messages and confirmations at each node of the chain
@@ -20,6 +27,24 @@ type MessageInterceptor interface {
 	OnConfirmation(confirmation *datalink.Confirmation)
 }
 
+// Database is the local replica the chain transfers and recovers from
+type Database interface {
+	handshake.DatabaseTransfer
+	Versions
+	LastRestoredIndex() int32
+	// LoggedMessagesAfter returns the confirmed messages after index that were persisted
+	LoggedMessagesAfter(index int32) ([]*datalink.Message, error)
+}
+
+// BufferLimits bound the messages a node keeps in memory until they are
+// confirmed; older ones are spilled. Without a spill every message is kept in
+// memory, so a stalled tail grows the buffer without limit.
+type BufferLimits struct {
+	Messages int
+	Bytes    int64 // 0 leaves the bytes unbounded
+	Spill    Spill[*datalink.Message]
+}
+
 type Node struct {
 	ctx         context.Context
 	producer    MessageProducer
@@ -28,25 +53,35 @@ type Node struct {
 	done        chan struct{}
 	state       *NodeDFA
 	interceptor *BufferedInterceptor
+	versions    Versions
+	tail        *tailLink
 }
 
 func NewNode(
 	ctx context.Context,
 	messageProducer MessageProducer,
 	messageInterceptor MessageInterceptor,
-	transfer handshake.DatabaseTransfer,
+	database Database,
+	limits BufferLimits,
+	batching stream.Batching,
 	listenerAddress string,
 ) *Node {
 	dfa := NewNodeDFA()
-	interceptor := NewBufferedInterceptor(transfer, messageInterceptor)
+	messages := NewReplayBuffer[*datalink.Message](MaxSize)
+	if limits.Spill != nil {
+		messages = NewSpillingReplayBuffer(limits.Messages, limits.Bytes, messageSize, limits.Spill)
+	}
+	interceptor := newBufferedInterceptor(database, messageInterceptor, messages)
 	n := &Node{
 		ctx:         ctx,
 		producer:    messageProducer,
 		done:        make(chan struct{}),
 		state:       dfa,
-		chainClient: NewClient(ctx, dfa, interceptor, 1000),
-		chainServer: NewServer(ctx, dfa, listenerAddress, interceptor, 1000),
+		chainClient: NewClient(ctx, dfa, interceptor, 1000, batching),
+		chainServer: NewServer(ctx, dfa, listenerAddress, interceptor, database, 1000, batching),
 		interceptor: interceptor,
+		versions:    database,
+		tail:        newTailLink(ctx),
 	}
 	go n.run()
 	return n
@@ -60,8 +95,6 @@ func (n *Node) run() {
 		wg       sync.WaitGroup
 	)
 
-	// FIXME when a mid node disconnect a message in its predecessors outbound channel will be sent twice
-
 	for {
 		select {
 		case state := <-n.state.States():
@@ -106,27 +139,34 @@ func (n *Node) run() {
 }
 
 func (n *Node) runAsHead(ctx context.Context) {
+	defer n.startProducer(ctx)()
 	for {
 		select {
 		case msg := <-n.producer.Messages():
 			_ = n.interceptor.OnMessage(msg)
 			n.chainClient.Outbound() <- msg
 		case conf := <-n.chainClient.Inbound():
-			n.interceptor.OnConfirmation(conf)
+			_ = n.interceptor.OnConfirmation(conf)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// Messages and confirmations resent after a reconnect are dropped, since they
+// were already passed on.
 func (n *Node) runAsMid(ctx context.Context) {
 	for {
 		select {
 		case msg := <-n.chainServer.Inbound():
-			_ = n.interceptor.OnMessage(msg)
+			if errors.Is(n.interceptor.OnMessage(msg), ErrDuplicate) {
+				continue
+			}
 			n.chainClient.Outbound() <- msg
 		case conf := <-n.chainClient.Inbound():
-			n.interceptor.OnConfirmation(conf)
+			if errors.Is(n.interceptor.OnConfirmation(conf), ErrDuplicate) {
+				continue
+			}
 			n.chainServer.Outbound() <- conf
 		case <-ctx.Done():
 			return
@@ -139,23 +179,13 @@ func (n *Node) runAsTail(ctx context.Context) {
 		select {
 		case msg := <-n.chainServer.Inbound():
 			err := n.interceptor.OnMessage(msg)
-			if err != nil {
-				errConf := &datalink.Confirmation{
-					MessageIndex: msg.GetMessageIndex(),
-					RequestId:    msg.GetRequestId(),
-					Ok:           false, Error: err.Error(),
-				}
-				n.interceptor.OnConfirmation(errConf)
-				n.chainServer.Outbound() <- errConf
-			} else {
-				conf := &datalink.Confirmation{
-					MessageIndex: msg.GetMessageIndex(),
-					RequestId:    msg.GetRequestId(),
-					Ok:           true,
-				}
-				n.interceptor.OnConfirmation(conf)
-				n.chainServer.Outbound() <- conf
+			if errors.Is(err, ErrDuplicate) {
+				// its confirmation was sent when it first arrived
+				continue
 			}
+			conf := newConfirmation(msg, err)
+			_ = n.interceptor.OnConfirmation(conf)
+			n.chainServer.Outbound() <- conf
 		case <-ctx.Done():
 			return
 		}
@@ -163,29 +193,61 @@ func (n *Node) runAsTail(ctx context.Context) {
 }
 
 func (n *Node) runAsSingleNode(ctx context.Context) {
+	defer n.startProducer(ctx)()
 	for {
 		select {
 		case msg := <-n.producer.Messages():
 			err := n.interceptor.OnMessage(msg)
 			if err != nil {
 				log.Println("Failed to process message: ", err)
-				n.interceptor.OnConfirmation(&datalink.Confirmation{
-					MessageIndex: msg.GetMessageIndex(),
-					Ok:           false, Error: err.Error(),
-					RequestId: msg.GetRequestId(),
-				})
-			} else {
-				n.interceptor.OnConfirmation(&datalink.Confirmation{
-					MessageIndex: msg.GetMessageIndex(), Ok: true,
-					RequestId: msg.GetRequestId(),
-				})
 			}
+			_ = n.interceptor.OnConfirmation(newConfirmation(msg, err))
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// startProducer runs the producer's work at the head and returns a function
+// that waits for it to stop after ctx is done.
+func (n *Node) startProducer(ctx context.Context) (wait func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.producer.RunAtHead(ctx)
+	}()
+	return func() { <-done }
+}
+
+// newConfirmation confirms msg, or rejects it with the error and its status code.
+func newConfirmation(msg *datalink.Message, err error) *datalink.Confirmation {
+	conf := &datalink.Confirmation{
+		MessageIndex: msg.GetMessageIndex(),
+		RequestId:    msg.GetRequestId(),
+		Ok:           err == nil,
+	}
+	if err != nil {
+		conf.Error = status.Convert(err).Message()
+		conf.Code = uint32(status.Code(err))
+	}
+	return conf
+}
+
+func messageSize(msg *datalink.Message) int {
+	return proto.Size(msg)
+}
+
+// BufferStatus reports the messages the node keeps until they are confirmed.
+func (n *Node) BufferStatus() *controllink.BufferStatus {
+	o := n.interceptor.messages.Occupancy()
+	return &controllink.BufferStatus{
+		Messages:        int32(o.Values),
+		Bytes:           o.Bytes,
+		SpilledMessages: int32(o.SpilledValues),
+		SpilledBytes:    o.SpilledBytes,
+	}
+}
+
 func (n *Node) SetNextNode(addr string) error {
 	return n.chainClient.SetNextNode(addr)
 }
@@ -5,6 +5,7 @@ import (
 	"log"
 	"seminarska/internal/data/chain/handshake"
 	"seminarska/proto/datalink"
+	"slices"
 	"sync/atomic"
 )
 
@@ -32,34 +33,69 @@ func (c *OpCounter) Revert() {
 	atomic.AddInt32(&c.n, -1)
 }
 
+var (
+	ErrMissingMessages = errors.New("missing messages are neither buffered nor logged")
+	ErrDuplicate       = errors.New("message already received")
+)
+
 type BufferedInterceptor struct {
 	messages        *ReplayBuffer[*datalink.Message]
 	confirmations   *ReplayBuffer[*datalink.Confirmation]
 	baseInterceptor MessageInterceptor
 	opCounter       *OpCounter
+	// index of the state recovered from disk or imported from a snapshot
+	restoredIndex int32
+	database      Database
 	handshake.DatabaseTransfer
 }
 
 func NewBufferedInterceptor(
-	databaseTransfer handshake.DatabaseTransfer,
+	database Database,
+	interceptor MessageInterceptor,
+) *BufferedInterceptor {
+	return newBufferedInterceptor(database, interceptor, NewReplayBuffer[*datalink.Message](MaxSize))
+}
+
+// newBufferedInterceptor keeps the messages that are not confirmed yet in messages.
+func newBufferedInterceptor(
+	database Database,
 	interceptor MessageInterceptor,
+	messages *ReplayBuffer[*datalink.Message],
 ) *BufferedInterceptor {
-	return &BufferedInterceptor{
+	o := &BufferedInterceptor{
 		baseInterceptor:  interceptor,
-		DatabaseTransfer: databaseTransfer,
+		database:         database,
+		DatabaseTransfer: database,
 		opCounter:        NewOpCounter(0),
-		messages:         NewReplayBuffer[*datalink.Message](MaxSize),
+		messages:         messages,
 		confirmations:    NewReplayBuffer[*datalink.Confirmation](1000),
 	}
+	// resume after the state recovered from disk, so the handshake
+	// continues from there instead of requesting a full transfer
+	if restored := database.LastRestoredIndex(); restored > 0 {
+		log.Println("Restored state up to message:", restored)
+		o.restoredIndex = restored
+		o.opCounter.Reset(restored)
+	}
+	return o
 }
 
+// OnMessage processes a message, numbering it if it comes from the producer. A
+// message at or below the last index is a resend and fails with ErrDuplicate
+// without being processed again.
 func (o *BufferedInterceptor) OnMessage(message *datalink.Message) error {
-	if message.MessageIndex == 0 {
+	switch current := o.opCounter.Current(); {
+	case message.MessageIndex == 0:
 		message.MessageIndex = o.opCounter.Next()
-	} else {
-		if message.MessageIndex != o.opCounter.Next() {
+	case message.MessageIndex <= current:
+		log.Println("Ignoring duplicate message:", message.MessageIndex)
+		return ErrDuplicate
+	default:
+		if message.MessageIndex != current+1 {
+			// messages replayed from a log skip the ones that failed
 			log.Println("Received message with wrong index:", message.MessageIndex)
 		}
+		o.opCounter.Reset(message.MessageIndex)
 	}
 	log.Println("Received message:", message.MessageIndex)
 	if err := o.messages.Add(message); err != nil {
@@ -72,27 +108,46 @@ func (o *BufferedInterceptor) OnMessage(message *datalink.Message) error {
 	return o.baseInterceptor.OnMessage(message)
 }
 
-func (o *BufferedInterceptor) OnConfirmation(confirmation *datalink.Confirmation) {
+// OnConfirmation processes a confirmation; one that was already received fails
+// with ErrDuplicate.
+func (o *BufferedInterceptor) OnConfirmation(confirmation *datalink.Confirmation) error {
 	log.Println("Received confirmation: ", confirmation.GetMessageIndex())
 	if err := o.confirmations.Add(confirmation); err != nil {
 		if errors.Is(err, ErrIndexOutOfOrder) {
 			log.Println("Ignoring duplicate confirmation:", confirmation.GetMessageIndex())
-			return
+			return ErrDuplicate
 		}
 		log.Println("Failed to buffer confirmation:", err)
-		return
+		return err
 	}
 	o.messages.ClearBefore(confirmation.GetMessageIndex()) // no need to keep old confirmed messages - every node has them
 	o.baseInterceptor.OnConfirmation(confirmation)
+	return nil
 }
 
-func (o *BufferedInterceptor) GetMessagesAfter(i int32) []*datalink.Message {
-	messages, err := o.messages.MessagesAfter(i)
-	if err != nil {
-		log.Println("Error getting messages after", i, ":", err)
-		return nil
+// GetMessagesAfter returns every message after i. Confirmed messages that were
+// cleared from the buffer are read from the log; if the log does not hold them
+// either, it fails with ErrMissingMessages rather than leave a hole.
+func (o *BufferedInterceptor) GetMessagesAfter(i int32) ([]*datalink.Message, error) {
+	buffered, err := o.messages.MessagesAfter(i)
+	if err == nil {
+		return buffered, nil
+	}
+	if errors.Is(err, ErrNoBufferedMessages) && i >= o.opCounter.Current() {
+		return nil, nil
 	}
-	return messages
+	logged, logErr := o.database.LoggedMessagesAfter(i)
+	if logErr != nil {
+		return nil, errors.Join(ErrMissingMessages, logErr)
+	}
+	if len(buffered) > 0 {
+		first := buffered[0].GetMessageIndex()
+		logged = slices.DeleteFunc(logged, func(m *datalink.Message) bool {
+			return m.GetMessageIndex() >= first
+		})
+	}
+	log.Println("Replaying", len(logged), "messages after", i, "from the log")
+	return append(logged, buffered...), nil
 }
 
 func (o *BufferedInterceptor) GetConfirmationsAfter(i int32) []*datalink.Confirmation {
@@ -107,7 +162,7 @@ func (o *BufferedInterceptor) GetConfirmationsAfter(i int32) []*datalink.Confirm
 func (o *BufferedInterceptor) ProcessMessages(messages []*datalink.Message) {
 	for _, msg := range messages {
 		err := o.OnMessage(msg)
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrDuplicate) {
 			log.Println("Failed to process message: ", err)
 		}
 	}
@@ -115,14 +170,14 @@ func (o *BufferedInterceptor) ProcessMessages(messages []*datalink.Message) {
 
 func (o *BufferedInterceptor) ProcessConfirmations(confirmations []*datalink.Confirmation) {
 	for _, conf := range confirmations {
-		o.OnConfirmation(conf)
+		_ = o.OnConfirmation(conf)
 	}
 }
 
 func (o *BufferedInterceptor) LastMessageIndex() int32 {
 	i, err := o.messages.LastMessageIndex()
 	if err != nil {
-		return -1
+		return o.lastRestoredIndex()
 	}
 	return i
 }
@@ -130,18 +185,43 @@ func (o *BufferedInterceptor) LastMessageIndex() int32 {
 func (o *BufferedInterceptor) LastConfirmationIndex() int32 {
 	i, err := o.confirmations.LastMessageIndex()
 	if err != nil {
-		return -1
+		return o.lastRestoredIndex()
 	}
 	return i
 }
 
+func (o *BufferedInterceptor) lastRestoredIndex() int32 {
+	if o.restoredIndex == 0 {
+		return -1
+	}
+	return o.restoredIndex
+}
+
+// GetSnapshot returns the confirmed state together with the messages still
+// pending, so the successor receives those as well. Both follow from the index
+// the relations were read at: a confirmation recorded while they were read may
+// not be applied yet, so its message is sent as pending rather than skipped.
 func (o *BufferedInterceptor) GetSnapshot() *datalink.DatabaseSnapshot {
 	snapshot := o.DatabaseTransfer.GetSnapshot()
-	snapshot.OpCount = o.opCounter.Current()
+	applied := snapshot.GetDigestIndex()
+	snapshot.OpCount = applied
+	// confirmations are applied one at a time and the one being applied stays
+	// buffered, so messages missing after applied were confirmed as failed
+	pending, err := o.messages.MessagesAfter(applied)
+	if err != nil && !errors.Is(err, ErrIncompleteResult) && !errors.Is(err, ErrNoBufferedMessages) {
+		log.Println("Failed to read the pending messages for a snapshot:", err)
+	}
+	snapshot.PendingRequests = pending
 	return snapshot
 }
 
-func (o *BufferedInterceptor) SetFromSnapshot(snapshot *datalink.DatabaseSnapshot) {
-	o.opCounter.Reset(snapshot.GetOpCount())
-	o.DatabaseTransfer.SetFromSnapshot(snapshot)
+// FinishSnapshot completes the import of a snapshot holding the operations
+// through opCount. The messages that were pending in it follow separately.
+func (o *BufferedInterceptor) FinishSnapshot(opCount int32) error {
+	if err := o.DatabaseTransfer.FinishSnapshot(opCount); err != nil {
+		return err
+	}
+	o.opCounter.Reset(opCount)
+	o.restoredIndex = opCount
+	return nil
 }
@@ -1,17 +1,49 @@
 package chain
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"seminarska/proto/datalink"
 )
 
 type fakeTransfer struct {
-	lastMsg int32
+	lastMsg  int32
+	restored int32
+	logged   []*datalink.Message
+	// applied is the index the relations are read at, after which onSnapshot runs
+	applied    int32
+	onSnapshot func()
 }
 
-func (f *fakeTransfer) GetSnapshot() *datalink.DatabaseSnapshot    { return &datalink.DatabaseSnapshot{} }
-func (f *fakeTransfer) SetFromSnapshot(*datalink.DatabaseSnapshot) {}
+func (f *fakeTransfer) GetSnapshot() *datalink.DatabaseSnapshot {
+	snapshot := &datalink.DatabaseSnapshot{DigestIndex: f.applied}
+	if f.onSnapshot != nil {
+		f.onSnapshot()
+	}
+	return snapshot
+}
+
+func (f *fakeTransfer) BeginSnapshot() error                                { return nil }
+func (f *fakeTransfer) ApplySnapshotChunk(*datalink.DatabaseSnapshot) error { return nil }
+func (f *fakeTransfer) FinishSnapshot(int32) error                          { return nil }
+func (f *fakeTransfer) LastRestoredIndex() int32                            { return f.restored }
+func (f *fakeTransfer) Applied() int32                                      { return f.lastMsg }
+func (f *fakeTransfer) AwaitApplied(context.Context, int32) error           { return nil }
+
+func (f *fakeTransfer) LoggedMessagesAfter(index int32) ([]*datalink.Message, error) {
+	if f.logged == nil {
+		return nil, errors.New("no log")
+	}
+	var out []*datalink.Message
+	for _, m := range f.logged {
+		if m.MessageIndex > index {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
 
 type nopInterceptor struct{}
 
@@ -40,7 +72,7 @@ func TestBufferedInterceptor_ProcessAndGetAfter(t *testing.T) {
 	bi := NewBufferedInterceptor(&fakeTransfer{}, &nopInterceptor{})
 	msgs := []*datalink.Message{{MessageIndex: 10}, {MessageIndex: 11}}
 	bi.ProcessMessages(msgs)
-	if got := bi.GetMessagesAfter(9); len(got) != 2 {
+	if got, _ := bi.GetMessagesAfter(9); len(got) != 2 {
 		t.Fatalf("expected 2 got %d", len(got))
 	}
 	confs := []*datalink.Confirmation{{MessageIndex: 20}, {MessageIndex: 21}}
@@ -51,14 +83,154 @@ func TestBufferedInterceptor_ProcessAndGetAfter(t *testing.T) {
 }
 
 func TestBufferedInterceptor_SnapshotReset(t *testing.T) {
-	bi := NewBufferedInterceptor(&fakeTransfer{}, &nopInterceptor{})
+	bi := NewBufferedInterceptor(&fakeTransfer{applied: 5}, &nopInterceptor{})
 	bi.opCounter.Reset(5)
 	snap := bi.GetSnapshot()
 	if snap.GetOpCount() != 5 {
 		t.Fatalf("expected snap opcount 5 got %d", snap.GetOpCount())
 	}
-	bi.SetFromSnapshot(&datalink.DatabaseSnapshot{OpCount: 2})
+	bi.FinishSnapshot(2)
 	if bi.opCounter.Current() != 2 {
 		t.Fatalf("expected opcount 2 got %d", bi.opCounter.Current())
 	}
 }
+
+func TestBufferedInterceptor_Restore(t *testing.T) {
+	bi := NewBufferedInterceptor(&fakeTransfer{restored: 7}, &nopInterceptor{})
+	if bi.LastMessageIndex() != 7 || bi.LastConfirmationIndex() != 7 {
+		t.Fatalf("expected resume at 7 got %d/%d", bi.LastMessageIndex(), bi.LastConfirmationIndex())
+	}
+	if got, _ := bi.GetMessagesAfter(7); len(got) != 0 {
+		t.Fatalf("expected no messages after restored index got %d", len(got))
+	}
+	if err := bi.OnMessage(&datalink.Message{RequestId: "r8"}); err != nil {
+		t.Fatalf("onmessage: %v", err)
+	}
+	if bi.LastMessageIndex() != 8 {
+		t.Fatalf("expected next index 8 got %d", bi.LastMessageIndex())
+	}
+}
+
+func TestBufferedInterceptor_MessagesAfterGap(t *testing.T) {
+	transfer := &fakeTransfer{}
+	bi := NewBufferedInterceptor(transfer, &nopInterceptor{})
+	bi.ProcessMessages([]*datalink.Message{{MessageIndex: 1}, {MessageIndex: 2}, {MessageIndex: 3}, {MessageIndex: 4}})
+	bi.ProcessConfirmations([]*datalink.Confirmation{{MessageIndex: 3, Ok: true}})
+	if got, err := bi.GetMessagesAfter(2); err != nil || len(got) != 2 {
+		t.Fatalf("expected 2 buffered messages got %d (%v)", len(got), err)
+	}
+	// messages 1 and 2 were cleared and there is no log to replay them from
+	if _, err := bi.GetMessagesAfter(0); !errors.Is(err, ErrMissingMessages) {
+		t.Fatalf("expected missing messages got %v", err)
+	}
+	// message 2 failed, so it is not in the log
+	transfer.logged = []*datalink.Message{{MessageIndex: 1}, {MessageIndex: 3}}
+	got, err := bi.GetMessagesAfter(0)
+	if err != nil {
+		t.Fatalf("messages after: %v", err)
+	}
+	var indexes []int32
+	for _, m := range got {
+		indexes = append(indexes, m.MessageIndex)
+	}
+	if len(indexes) != 3 || indexes[0] != 1 || indexes[1] != 3 || indexes[2] != 4 {
+		t.Fatalf("unexpected messages: %v", indexes)
+	}
+	if got, err := bi.GetMessagesAfter(4); err != nil || len(got) != 0 {
+		t.Fatalf("expected nothing after the last message got %d (%v)", len(got), err)
+	}
+}
+
+func TestBufferedInterceptor_SnapshotPending(t *testing.T) {
+	sender := NewBufferedInterceptor(&fakeTransfer{applied: 1}, &nopInterceptor{})
+	sender.ProcessMessages([]*datalink.Message{{MessageIndex: 1}, {MessageIndex: 2}, {MessageIndex: 3}})
+	sender.ProcessConfirmations([]*datalink.Confirmation{{MessageIndex: 1, Ok: true}})
+	snap := sender.GetSnapshot()
+	if snap.GetOpCount() != 1 || len(snap.GetPendingRequests()) != 2 {
+		t.Fatalf("expected op count 1 with 2 pending got %d with %d", snap.GetOpCount(), len(snap.GetPendingRequests()))
+	}
+
+	receiver := NewBufferedInterceptor(&fakeTransfer{}, &nopInterceptor{})
+	if err := receiver.BeginSnapshot(); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := receiver.ApplySnapshotChunk(snap); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	// the relations hold the state through the first message, the pending
+	// messages are processed after the import like any others
+	receiver.FinishSnapshot(1)
+	if receiver.opCounter.Current() != 1 {
+		t.Fatalf("expected the confirmed state at 1 got %d", receiver.opCounter.Current())
+	}
+	receiver.ProcessMessages(snap.GetPendingRequests())
+	if receiver.opCounter.Current() != 3 || receiver.LastMessageIndex() != 3 {
+		t.Fatalf("expected pending messages processed up to 3 got %d", receiver.opCounter.Current())
+	}
+	if got, err := receiver.GetMessagesAfter(1); err != nil || len(got) != 2 {
+		t.Fatalf("expected pending messages buffered got %d (%v)", len(got), err)
+	}
+}
+
+func TestBufferedInterceptor_SnapshotConfirmedMeanwhile(t *testing.T) {
+	transfer := &fakeTransfer{applied: 1}
+	sender := NewBufferedInterceptor(transfer, &nopInterceptor{})
+	sender.ProcessMessages([]*datalink.Message{{MessageIndex: 1}, {MessageIndex: 2}, {MessageIndex: 3}})
+	sender.ProcessConfirmations([]*datalink.Confirmation{{MessageIndex: 1, Ok: true}})
+	// message 2 is confirmed after the relations were read at 1, before
+	// the pending messages are; its receipt is not applied yet
+	transfer.onSnapshot = func() {
+		sender.ProcessConfirmations([]*datalink.Confirmation{{MessageIndex: 2, Ok: true}})
+	}
+	snap := sender.GetSnapshot()
+	pending := snap.GetPendingRequests()
+	if snap.GetOpCount() != 1 || len(pending) != 2 || pending[0].GetMessageIndex() != 2 {
+		t.Fatalf("expected the state at 1 followed by 2 and 3, got %d followed by %v", snap.GetOpCount(), pending)
+	}
+}
+
+type countingInterceptor struct {
+	messages, confirmations int
+}
+
+func (c *countingInterceptor) OnMessage(*datalink.Message) error     { c.messages++; return nil }
+func (c *countingInterceptor) OnConfirmation(*datalink.Confirmation) { c.confirmations++ }
+
+func TestBufferedInterceptor_Duplicates(t *testing.T) {
+	base := &countingInterceptor{}
+	bi := NewBufferedInterceptor(&fakeTransfer{}, base)
+	bi.ProcessMessages([]*datalink.Message{{MessageIndex: 1}, {MessageIndex: 2}})
+	// message 1 is resent after a reconnect
+	if err := bi.OnMessage(&datalink.Message{MessageIndex: 1}); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected duplicate got %v", err)
+	}
+	if err := bi.OnMessage(&datalink.Message{MessageIndex: 3}); err != nil {
+		t.Fatalf("onmessage: %v", err)
+	}
+	if base.messages != 3 || bi.opCounter.Current() != 3 {
+		t.Fatalf("expected 3 messages processed up to 3 got %d up to %d", base.messages, bi.opCounter.Current())
+	}
+	if err := bi.OnConfirmation(&datalink.Confirmation{MessageIndex: 1, Ok: true}); err != nil {
+		t.Fatalf("onconfirmation: %v", err)
+	}
+	if err := bi.OnConfirmation(&datalink.Confirmation{MessageIndex: 1, Ok: true}); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected duplicate got %v", err)
+	}
+	if base.confirmations != 1 {
+		t.Fatalf("expected 1 confirmation processed got %d", base.confirmations)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	// the successor holds message 3 after the handshake
+	sent := newSequence[*datalink.Message](3)
+	var indexes []int32
+	for _, i := range []int32{2, 3, 4, 4, 5} {
+		if sent.next(&datalink.Message{MessageIndex: i}) {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) != 2 || indexes[0] != 4 || indexes[1] != 5 {
+		t.Fatalf("unexpected sent indexes: %v", indexes)
+	}
+}
@@ -0,0 +1,101 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"seminarska/internal/common/rpc"
+	"seminarska/proto/datalink"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Any node serves reads. Records without pending changes are read locally, as
+// a newer confirmed value would have passed the node on its way to the tail.
+// For dirty records the node asks the tail which operations it applied and
+// waits for their confirmations to reach it.
+
+var ErrNoTail = errors.New("tail of the chain unknown")
+
+// Versions tell which confirmed operations the replica applied. Replicas apply
+// them in the same order, so the index of the latest one versions the replica.
+type Versions interface {
+	Applied() int32
+	AwaitApplied(ctx context.Context, index int32) error
+}
+
+// tailLink is the connection to the tail, replaced whenever the tail changes.
+type tailLink struct {
+	ctx    context.Context
+	mx     sync.Mutex
+	addr   string
+	client datalink.DataLinkClient
+	cancel context.CancelFunc
+}
+
+func newTailLink(ctx context.Context) *tailLink {
+	return &tailLink{ctx: ctx}
+}
+
+func (t *tailLink) set(addr string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if addr == t.addr {
+		return
+	}
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.addr, t.client, t.cancel = addr, nil, nil
+	if addr == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(t.ctx)
+	t.client = datalink.NewDataLinkClient(rpc.NewClient(ctx, addr))
+	t.cancel = cancel
+}
+
+func (t *tailLink) get() (datalink.DataLinkClient, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.client == nil {
+		return nil, ErrNoTail
+	}
+	return t.client, nil
+}
+
+// SetTail sets the chain listener address of the tail, empty if unknown.
+func (n *Node) SetTail(addr string) error {
+	n.tail.set(addr)
+	return nil
+}
+
+// AwaitTail waits until the replica applied every operation the tail applied
+// when it was asked, so reads that follow see what the tail had confirmed. The
+// tail itself has every confirmed operation.
+func (n *Node) AwaitTail(ctx context.Context) error {
+	if n.state.Confirms() {
+		return nil
+	}
+	client, err := n.tail.get()
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	applied, err := client.GetAppliedIndex(ctx, &emptypb.Empty{})
+	if err != nil {
+		return err
+	}
+	return n.versions.AwaitApplied(ctx, applied.GetIndex())
+}
+
+// GetAppliedIndex answers the version query of a node reading dirty records.
+// Only the tail answers, as other nodes may not have applied every confirmed
+// operation.
+func (l *listener) GetAppliedIndex(_ context.Context, _ *emptypb.Empty) (*datalink.AppliedIndex, error) {
+	if !l.state.Confirms() {
+		return nil, status.Error(codes.FailedPrecondition, "not the tail of the chain")
+	}
+	return &datalink.AppliedIndex{Index: l.versions.Applied()}, nil
+}
@@ -0,0 +1,56 @@
+package chain
+
+import (
+	"context"
+	"testing"
+
+	"seminarska/internal/data/chain/stream"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type fakeVersions struct {
+	applied int32
+	awaited []int32
+}
+
+func (f *fakeVersions) Applied() int32 { return f.applied }
+
+func (f *fakeVersions) AwaitApplied(_ context.Context, index int32) error {
+	f.awaited = append(f.awaited, index)
+	return nil
+}
+
+func TestListener_GetAppliedIndex(t *testing.T) {
+	dfa := NewNodeDFA()
+	l := newListener(dfa, nil, &fakeVersions{applied: 7}, 1, stream.Batching{Size: 1})
+	applied, err := l.GetAppliedIndex(context.Background(), &emptypb.Empty{})
+	if err != nil || applied.GetIndex() != 7 {
+		t.Fatalf("expected the tail to answer 7, got %v (%v)", applied, err)
+	}
+	if err := dfa.Emit(RoleRelay); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	if _, err := l.GetAppliedIndex(context.Background(), &emptypb.Empty{}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a relay to refuse, got %v", err)
+	}
+}
+
+func TestNode_AwaitTail(t *testing.T) {
+	versions := &fakeVersions{}
+	n := &Node{state: NewNodeDFA(), versions: versions, tail: newTailLink(context.Background())}
+	if err := n.AwaitTail(context.Background()); err != nil {
+		t.Fatalf("expected the tail to read right away, got %v", err)
+	}
+	if err := n.state.Emit(RoleRelay); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	if err := n.AwaitTail(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable without a tail, got %v", err)
+	}
+	if len(versions.awaited) != 0 {
+		t.Fatalf("expected no waiting, got %v", versions.awaited)
+	}
+}
@@ -25,9 +25,11 @@ func NewServer(
 	state *NodeDFA,
 	addr string,
 	data handshake.ServerData,
+	versions Versions,
 	buffer int,
+	batching stream.Batching,
 ) *Server {
-	l := newListener(state, data, buffer)
+	l := newListener(state, data, versions, buffer, batching)
 	return &Server{
 		l:         l,
 		rpcServer: rpc.NewServer(ctx, l, addr),
@@ -51,14 +53,19 @@ type session struct {
 	cancel        context.CancelCauseFunc
 	ctx           context.Context
 	handshakeDone chan struct{}
+	// index of the last confirmation the predecessor holds after the handshake
+	delivered int32
 }
 
 type listener struct {
 	datalink.UnimplementedDataLinkServer
-	outbound chan *datalink.Confirmation
-	inbound  chan *datalink.Message
-	state    *NodeDFA
-	data     handshake.ServerData
+	outbound  chan *datalink.Confirmation
+	inbound   chan *datalink.Message
+	state     *NodeDFA
+	data      handshake.ServerData
+	versions  Versions
+	transfers *handshake.Transfers
+	batching  stream.Batching
 
 	mx             sync.Mutex
 	currentSession *session
@@ -67,13 +74,18 @@ type listener struct {
 func newListener(
 	state *NodeDFA,
 	data handshake.ServerData,
+	versions Versions,
 	buffer int,
+	batching stream.Batching,
 ) *listener {
 	return &listener{
-		outbound: make(chan *datalink.Confirmation, buffer),
-		inbound:  make(chan *datalink.Message, buffer),
-		state:    state,
-		data:     data,
+		batching:  batching,
+		outbound:  make(chan *datalink.Confirmation, buffer),
+		inbound:   make(chan *datalink.Message, buffer),
+		state:     state,
+		data:      data,
+		versions:  versions,
+		transfers: handshake.NewTransfers(handshake.ChunkRecords),
 	}
 }
 
@@ -101,11 +113,21 @@ func (l *listener) Handshake(s datalink.DataLink_HandshakeServer) error {
 	l.currentSession = newSess
 	l.mx.Unlock()
 
-	err := handshake.Server(s, l.data)
+	delivered, messages, err := handshake.Server(s, l.data, l.transfers)
 	if err != nil {
 		return err
 	}
+	// the missing messages are processed like the ones from the stream, so
+	// they are passed on to the successor or confirmed as well
+	for _, msg := range messages {
+		select {
+		case l.inbound <- msg:
+		case <-newSess.ctx.Done():
+			return context.Cause(newSess.ctx)
+		}
+	}
 
+	newSess.delivered = delivered
 	close(newSess.handshakeDone)
 
 	<-newSess.ctx.Done()
@@ -144,7 +166,8 @@ func (l *listener) Replicate(s datalink.DataLink_ReplicateServer) error {
 		}
 	}()
 
-	supervisor := stream.NewSupervisor(l.outbound, l.inbound)
+	sent := newSequence[*datalink.Confirmation](sess.delivered)
+	supervisor := stream.NewSupervisor(l.outbound, l.inbound).Filter(sent.next)
 
-	return supervisor.Run(sess.ctx, s)
+	return supervisor.RunBatched(sess.ctx, &confirmationBatches{stream: s}, l.batching)
 }
@@ -149,6 +149,14 @@ func (d *NodeDFA) Emit(e event) error {
 	return nil
 }
 
+// Confirms reports whether the node confirms messages, so it holds every
+// confirmed operation.
+func (d *NodeDFA) Confirms() bool {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.lastState.Role == Confirmer || d.lastState.Role == ReaderConfirmer
+}
+
 func illegalTransitionError(state NodeState, t event) error {
 	return fmt.Errorf("illegal transition: %s%s", t, state)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 )
 
 type BidiStream[Req any, Res any] interface {
@@ -14,6 +15,7 @@ type BidiStream[Req any, Res any] interface {
 type Supervisor[O any, I any] struct {
 	outbound       chan O
 	inbound        chan I
+	keep           func(O) bool
 	mx             sync.Mutex
 	droppedMessage *O
 }
@@ -22,7 +24,14 @@ func NewSupervisor[O any, I any](
 	outbound chan O,
 	inbound chan I,
 ) *Supervisor[O, I] {
-	return &Supervisor[O, I]{outbound, inbound, sync.Mutex{}, nil}
+	return &Supervisor[O, I]{outbound: outbound, inbound: inbound}
+}
+
+// Filter makes the supervisor drop the outbound values keep rejects instead of
+// sending them. It has to be set before Run.
+func (c *Supervisor[O, I]) Filter(keep func(O) bool) *Supervisor[O, I] {
+	c.keep = keep
+	return c
 }
 
 func (c *Supervisor[O, I]) DroppedMessage() *O {
@@ -31,16 +40,30 @@ func (c *Supervisor[O, I]) DroppedMessage() *O {
 	return c.droppedMessage
 }
 
+// Batching groups the values a supervisor sends. A batch holds the values
+// already queued, up to Size, and waits at most Linger for more to arrive.
+type Batching struct {
+	Size   int
+	Linger time.Duration
+}
+
 func (c *Supervisor[O, I]) Run(ctx context.Context, stream BidiStream[O, I]) error {
+	return c.RunBatched(ctx, single[O, I]{stream}, Batching{Size: 1})
+}
+
+// RunBatched sends the outbound values over a stream of batches and unpacks the
+// batches it receives into inbound.
+func (c *Supervisor[O, I]) RunBatched(ctx context.Context, stream BidiStream[[]O, []I], batching Batching) error {
 	streamCtx, cancel := context.WithCancelCause(ctx)
-	go c.transmit(stream, streamCtx, cancel)
+	go c.transmit(stream, batching, streamCtx, cancel)
 	go c.receive(stream, streamCtx, cancel)
 	<-streamCtx.Done()
 	return streamCtx.Err()
 }
 
 func (c *Supervisor[O, I]) transmit(
-	stream BidiStream[O, I],
+	stream BidiStream[[]O, []I],
+	batching Batching,
 	ctx context.Context,
 	cancel context.CancelCauseFunc,
 ) {
@@ -49,9 +72,13 @@ func (c *Supervisor[O, I]) transmit(
 		case <-ctx.Done():
 			return
 		case msg := <-c.outbound:
-			if err := stream.Send(msg); err != nil {
+			if c.keep != nil && !c.keep(msg) {
+				continue
+			}
+			batch := c.fill(ctx, []O{msg}, batching)
+			if err := stream.Send(batch); err != nil {
 				c.mx.Lock()
-				c.droppedMessage = &msg
+				c.droppedMessage = &batch[0]
 				c.mx.Unlock()
 				cancel(errors.Join(errors.New("failed to send message"), err))
 				return
@@ -60,18 +87,51 @@ func (c *Supervisor[O, I]) transmit(
 	}
 }
 
+// fill adds outbound values to the batch until it is full or lingered long enough.
+func (c *Supervisor[O, I]) fill(ctx context.Context, batch []O, batching Batching) []O {
+	var linger <-chan time.Time
+	if batching.Linger > 0 {
+		timer := time.NewTimer(batching.Linger)
+		defer timer.Stop()
+		linger = timer.C
+	}
+	for len(batch) < batching.Size {
+		var msg O
+		select {
+		case msg = <-c.outbound:
+		default:
+			if linger == nil {
+				return batch
+			}
+			select {
+			case msg = <-c.outbound:
+			case <-linger:
+				return batch
+			case <-ctx.Done():
+				return batch
+			}
+		}
+		if c.keep == nil || c.keep(msg) {
+			batch = append(batch, msg)
+		}
+	}
+	return batch
+}
+
 func (c *Supervisor[O, I]) receive(
-	stream BidiStream[O, I],
+	stream BidiStream[[]O, []I],
 	ctx context.Context,
 	cancel context.CancelCauseFunc,
 ) {
 	for {
-		msg, err := stream.Recv()
+		batch, err := stream.Recv()
 		if err != nil {
 			cancel(errors.Join(errors.New("failed to receive confirmation"), err))
 			return
 		}
-		c.inbound <- msg
+		for _, msg := range batch {
+			c.inbound <- msg
+		}
 		select {
 		case <-ctx.Done():
 			return
@@ -80,3 +140,25 @@ func (c *Supervisor[O, I]) receive(
 		}
 	}
 }
+
+// single sends every value of a batch on its own.
+type single[O any, I any] struct {
+	stream BidiStream[O, I]
+}
+
+func (s single[O, I]) Send(batch []O) error {
+	for _, msg := range batch {
+		if err := s.stream.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s single[O, I]) Recv() ([]I, error) {
+	msg, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return []I{msg}, nil
+}
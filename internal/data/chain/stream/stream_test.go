@@ -105,3 +105,50 @@ func TestSupervisor_ReceiveFailure(t *testing.T) {
 		t.Fatalf("expected error from run due to recv failure")
 	}
 }
+
+func TestSupervisor_Filter(t *testing.T) {
+	out := make(chan int, 3)
+	in := make(chan string, 1)
+	s := NewSupervisor[int, string](out, in).Filter(func(v int) bool { return v%2 == 0 })
+	fs := &fakeStream[int, string]{sendCh: make(chan int, 3), recvCh: make(chan string)}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go func() { _ = s.Run(ctx, fs) }()
+
+	out <- 1
+	out <- 2
+	out <- 3
+	if got := <-fs.sendCh; got != 2 {
+		t.Fatalf("expected 2 got %d", got)
+	}
+	select {
+	case got := <-fs.sendCh:
+		t.Fatalf("filtered value sent: %d", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSupervisor_Batching(t *testing.T) {
+	out := make(chan int, 5)
+	in := make(chan string, 2)
+	s := NewSupervisor[int, string](out, in)
+	fs := &fakeStream[[]int, []string]{sendCh: make(chan []int, 5), recvCh: make(chan []string, 1)}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	for i := range 5 {
+		out <- i
+	}
+	fs.recvCh <- []string{"a", "b"}
+	go func() { _ = s.RunBatched(ctx, fs, Batching{Size: 3, Linger: 10 * time.Millisecond}) }()
+
+	if got := <-fs.sendCh; len(got) != 3 || got[0] != 0 || got[2] != 2 {
+		t.Fatalf("expected first batch [0 1 2] got %v", got)
+	}
+	if got := <-fs.sendCh; len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Fatalf("expected second batch [3 4] got %v", got)
+	}
+	if a, b := <-in, <-in; a != "a" || b != "b" {
+		t.Fatalf("expected a, b got %s, %s", a, b)
+	}
+}
@@ -1,6 +1,9 @@
 package config
 
-import "flag"
+import (
+	"flag"
+	"time"
+)
 
 type NodeConfig struct {
 	NodeId                 string
@@ -9,6 +12,16 @@ type NodeConfig struct {
 	ControlListenerAddress string
 	LogPath                string
 	Token                  string
+	DataDir                string
+	Engine                 string
+	SnapshotInterval       time.Duration
+	SnapshotOps            int
+	BufferMessages         int
+	BufferBytes            int64
+	BatchSize              int
+	BatchLinger            time.Duration
+	MaxInFlightWrites      int
+	WriteRetryAfter        time.Duration
 }
 
 func Load() NodeConfig {
@@ -18,6 +31,16 @@ func Load() NodeConfig {
 	controlListenerAddress := flag.String("control", ":0", "Control listener address")
 	token := flag.String("token", "", "Token")
 	logPath := flag.String("o", "", "Log path")
+	dataDir := flag.String("data", "", "Data directory (in-memory only if empty)")
+	engine := flag.String("storage-engine", "memory", "Storage engine of the relations: memory or bolt, a scratch file in -data that is rebuilt on every start")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "Time between on-disk snapshots (0 to disable)")
+	snapshotOps := flag.Int("snapshot-ops", 1000, "Confirmed operations between on-disk snapshots (0 to disable)")
+	bufferMessages := flag.Int("buffer-messages", 10000, "Unconfirmed messages kept in memory before spilling to disk")
+	bufferBytes := flag.Int64("buffer-bytes", 64<<20, "Bytes of unconfirmed messages kept in memory before spilling to disk (0 for no limit)")
+	batchSize := flag.Int("batch-size", 256, "Messages or confirmations sent to a neighbour at most in one batch")
+	batchLinger := flag.Duration("batch-linger", 0, "How long a batch waits for more messages before it is sent (0 sends what is queued)")
+	maxInFlightWrites := flag.Int("max-inflight-writes", 1000, "Writes the head accepts before they are confirmed, others are refused (0 for no limit)")
+	writeRetryAfter := flag.Duration("write-retry-after", time.Second, "Delay suggested to clients whose writes are refused")
 	flag.Parse()
 
 	return NodeConfig{
@@ -27,5 +50,15 @@ func Load() NodeConfig {
 		ControlListenerAddress: *controlListenerAddress,
 		Token:                  *token,
 		LogPath:                *logPath,
+		DataDir:                *dataDir,
+		Engine:                 *engine,
+		SnapshotInterval:       *snapshotInterval,
+		SnapshotOps:            *snapshotOps,
+		BufferMessages:         *bufferMessages,
+		BufferBytes:            *bufferBytes,
+		BatchSize:              *batchSize,
+		BatchLinger:            *batchLinger,
+		MaxInFlightWrites:      *maxInFlightWrites,
+		WriteRetryAfter:        *writeRetryAfter,
 	}
 }
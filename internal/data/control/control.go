@@ -2,30 +2,53 @@ package control
 
 import (
 	"context"
+	"errors"
 	"seminarska/internal/common/rpc"
+	"seminarska/internal/data/storage/replication"
 	"seminarska/proto/controllink"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type CommandHandler interface {
 	SetNextNode(address string) error
 	SetRole(role controllink.NodeRole) error
+	SetTail(address string) error
+}
+
+// DigestSource reports the digest of the replica's confirmed operations.
+type DigestSource interface {
+	Digest(index int32) (int32, []byte, error)
+}
+
+// BufferReporter reports the messages the node keeps until they are confirmed.
+type BufferReporter interface {
+	BufferStatus() *controllink.BufferStatus
 }
 
 type Server struct {
 	rpcServer *rpc.Server
 }
 
-func NewServer(ctx context.Context, addr string, h CommandHandler) *Server {
-	l := &listener{handler: h}
+func NewServer(
+	ctx context.Context,
+	addr string,
+	h CommandHandler,
+	digests DigestSource,
+	buffers BufferReporter,
+) *Server {
+	l := &listener{handler: h, digests: digests, buffers: buffers}
 	return &Server{rpcServer: rpc.NewServer(ctx, l, addr)}
 }
 
 type listener struct {
 	controllink.UnimplementedControlServiceServer
 	handler CommandHandler
+	digests DigestSource
+	buffers BufferReporter
 }
 
 func (l *listener) Register(grpcServer *grpc.Server) {
@@ -46,6 +69,28 @@ func (l *listener) SwitchRole(
 	return &emptypb.Empty{}, l.handler.SetRole(req.GetRole())
 }
 
+func (l *listener) SwitchTail(
+	_ context.Context,
+	req *controllink.SwitchTailCommand,
+) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, l.handler.SetTail(req.GetAddress())
+}
+
 func (l *listener) Ping(_ context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
 	return &emptypb.Empty{}, nil
 }
+
+func (l *listener) GetBufferStatus(_ context.Context, _ *emptypb.Empty) (*controllink.BufferStatus, error) {
+	return l.buffers.BufferStatus(), nil
+}
+
+func (l *listener) GetDigest(_ context.Context, req *controllink.DigestRequest) (*controllink.Digest, error) {
+	index, value, err := l.digests.Digest(req.GetIndex())
+	switch {
+	case errors.Is(err, replication.ErrDigestForgotten):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &controllink.Digest{Index: index, Value: value}, nil
+}
@@ -3,6 +3,8 @@ package requests
 import (
 	"context"
 	"errors"
+	"seminarska/internal/data/storage"
+	"seminarska/internal/data/storage/db"
 	"seminarska/internal/data/storage/entities"
 	"seminarska/proto/datalink"
 	"seminarska/proto/razpravljalnica"
@@ -24,26 +26,23 @@ func (l *listener) CreateUser(
 	return entities.EntityToDatalink(user).GetUser(), nil
 }
 
-func (l *listener) GetUser(_ context.Context, req *razpravljalnica.GetUserRequest) (*razpravljalnica.User, error) {
+func (l *listener) GetUser(ctx context.Context, req *razpravljalnica.GetUserRequest) (*razpravljalnica.User, error) {
 	if req.UserId == nil && req.Username == nil {
 		return nil, errors.New("bad request")
 	}
+	dirty := l.db.Users().HasDirty()
+	if req.UserId != nil {
+		dirty = l.db.Users().IsDirty(req.GetUserId())
+	}
+	if err := l.awaitClean(ctx, dirty); err != nil {
+		return nil, err
+	}
 	var user *entities.User
 	var err error
 	if req.UserId != nil {
 		user, err = l.db.Users().Get(req.GetUserId())
 	} else {
-		var users []*entities.User
-		users, err = l.db.Users().GetPredicate(func(user *entities.User) bool {
-			return user.Name == req.GetUsername()
-		}, 1)
-		if err == nil {
-			if len(users) == 1 {
-				user = users[0]
-			} else {
-				err = errors.New("not found")
-			}
-		}
+		user, err = l.db.GetUserByName(req.GetUsername())
 	}
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "user not found")
@@ -55,13 +54,53 @@ func (l *listener) CreateTopic(
 	ctx context.Context,
 	request *razpravljalnica.CreateTopicRequest,
 ) (*razpravljalnica.Topic, error) {
-	topic, err := l.db.CreateTopic(ctx, request.GetName())
+	topic, err := l.db.CreateTopic(ctx, request.GetUserId(), request.GetName())
 	if err != nil {
 		return nil, err
 	}
 	return entities.EntityToDatalink(topic).GetTopic(), nil
 }
 
+func (l *listener) SetTopicRetention(
+	ctx context.Context,
+	request *razpravljalnica.SetTopicRetentionRequest,
+) (*razpravljalnica.Topic, error) {
+	retention := request.GetRetention()
+	if retention.GetMaxAgeSeconds() < 0 || retention.GetMaxMessages() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "retention limits must be non-negative")
+	}
+	topic, err := l.db.SetTopicRetention(
+		ctx, request.GetUserId(), request.GetTopicId(), entities.RetentionFromProto(retention), request.ExpectedVersion,
+	)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "topic not found")
+	}
+	if errors.Is(err, storage.ErrNotOwner) {
+		return nil, status.Error(codes.PermissionDenied, "only the owner can set the retention of a topic")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entities.EntityToDatalink(topic).GetTopic(), nil
+}
+
+func (l *listener) DeleteTopic(
+	ctx context.Context,
+	request *razpravljalnica.DeleteTopicRequest,
+) (*emptypb.Empty, error) {
+	err := l.db.DeleteTopic(ctx, request.GetUserId(), request.GetTopicId())
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "topic not found")
+	}
+	if errors.Is(err, storage.ErrNotOwner) {
+		return nil, status.Error(codes.PermissionDenied, "only the owner can delete a topic")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
 func (l *listener) PostMessage(
 	ctx context.Context,
 	request *razpravljalnica.PostMessageRequest,
@@ -77,11 +116,15 @@ func (l *listener) UpdateMessage(
 	ctx context.Context,
 	request *razpravljalnica.UpdateMessageRequest,
 ) (*razpravljalnica.Message, error) {
-	msg, err := l.db.UpdateMessage(ctx, request.GetUserId(), request.GetMessageId(), request.GetText())
-	if err != nil {
-		return nil, err
+	msg, err := l.db.UpdateMessage(
+		ctx, request.GetUserId(), request.GetMessageId(), request.GetText(), request.ExpectedVersion,
+	)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "message not found")
+	}
+	if errors.Is(err, storage.ErrNotOwner) {
+		return nil, status.Error(codes.PermissionDenied, "only the author can edit a message")
 	}
-	likes, err := l.db.GetLikes(request.MessageId)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +132,6 @@ func (l *listener) UpdateMessage(
 	if out == nil {
 		panic("illegal state:")
 	}
-	out.Likes = int32(likes)
 	return out, nil
 }
 
@@ -97,15 +139,49 @@ func (l *listener) DeleteMessage(
 	ctx context.Context,
 	request *razpravljalnica.DeleteMessageRequest,
 ) (*emptypb.Empty, error) {
-	err := l.db.DeleteMessage(ctx, request.GetUserId(), request.GetMessageId())
-	return &emptypb.Empty{}, err
+	err := l.db.DeleteMessage(ctx, request.GetUserId(), request.GetMessageId(), request.ExpectedVersion)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "message not found")
+	}
+	if errors.Is(err, storage.ErrNotOwner) {
+		return nil, status.Error(codes.PermissionDenied, "only the author can delete a message")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (l *listener) GetMessageHistory(
+	ctx context.Context,
+	request *razpravljalnica.GetMessageHistoryRequest,
+) (*razpravljalnica.GetMessageHistoryResponse, error) {
+	dirty := l.db.Messages().IsDirty(request.GetMessageId()) || l.db.Revisions().HasDirty()
+	if err := l.awaitClean(ctx, dirty); err != nil {
+		return nil, err
+	}
+	msg, revisions, err := l.db.GetMessageHistory(request.GetTopicId(), request.GetMessageId())
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "message not found in topic")
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*razpravljalnica.Revision, len(revisions))
+	for i, revision := range revisions {
+		out[i] = entities.RevisionToProto(revision)
+	}
+	return &razpravljalnica.GetMessageHistoryResponse{
+		Message:   entities.EntityToDatalink(msg).GetMessage(),
+		Revisions: out,
+	}, nil
 }
 
 func (l *listener) LikeMessage(
 	ctx context.Context,
 	request *razpravljalnica.LikeMessageRequest,
 ) (*razpravljalnica.Message, error) {
-	err := l.db.LikeMessage(ctx, request.MessageId, request.UserId)
+	err := l.db.LikeMessage(ctx, request.GetUserId(), request.GetMessageId())
 	if err != nil {
 		return nil, err
 	}
@@ -117,14 +193,34 @@ func (l *listener) LikeMessage(
 	if out == nil {
 		panic("illegal state:")
 	}
-	out.Likes++
 	return out, nil
 }
 
+func (l *listener) UnlikeMessage(
+	ctx context.Context,
+	request *razpravljalnica.UnlikeMessageRequest,
+) (*razpravljalnica.Message, error) {
+	err := l.db.UnlikeMessage(ctx, request.GetUserId(), request.GetMessageId())
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "like not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg, err := l.db.GetMessage(request.GetMessageId())
+	if err != nil {
+		return nil, err
+	}
+	return entities.EntityToDatalink(msg).GetMessage(), nil
+}
+
 func (l *listener) ListTopics(
-	_ context.Context,
+	ctx context.Context,
 	_ *emptypb.Empty,
 ) (*razpravljalnica.ListTopicsResponse, error) {
+	if err := l.awaitClean(ctx, l.db.Topics().HasDirty()); err != nil {
+		return nil, err
+	}
 	topics, err := l.db.GetTopics()
 	if err != nil {
 		return nil, err
@@ -139,28 +235,68 @@ func (l *listener) ListTopics(
 }
 
 func (l *listener) GetMessages(
-	_ context.Context,
+	ctx context.Context,
 	request *razpravljalnica.GetMessagesRequest,
 ) (*razpravljalnica.GetMessagesResponse, error) {
-	messages, err := l.db.GetMessages(request.GetFromMessageId(), request.GetTopicId(), request.GetLimit())
-	if err != nil {
+	if err := l.awaitClean(ctx, l.db.Messages().HasDirty()); err != nil {
 		return nil, err
 	}
+	messages, next, err := l.db.GetMessages(
+		request.GetTopicId(), request.GetFromMessageId(),
+		request.GetLimit(), request.GetLatest(),
+	)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	out := make([]*razpravljalnica.Message, len(messages))
 	for i, message := range messages {
 		msg := entities.EntityToDatalink(message).GetMessage()
 		if msg == nil {
 			panic("illegal state")
 		}
-		likes, err := l.db.GetLikes(message.Id())
-		if err != nil {
-			return nil, err
-		}
-		msg.Likes = int32(likes)
 		out[i] = msg
 	}
 	return &razpravljalnica.GetMessagesResponse{
-		Messages: out,
+		Messages:      out,
+		NextMessageId: next,
+	}, nil
+}
+
+func (l *listener) SearchMessages(
+	ctx context.Context,
+	request *razpravljalnica.SearchMessagesRequest,
+) (*razpravljalnica.SearchMessagesResponse, error) {
+	if err := l.awaitClean(ctx, l.db.Messages().HasDirty()); err != nil {
+		return nil, err
+	}
+	search := storage.MessageSearch{
+		Query:   request.GetQuery(),
+		TopicId: request.TopicId,
+		UserId:  request.UserId,
+		Offset:  request.GetOffset(),
+		Limit:   request.GetLimit(),
+	}
+	if request.CreatedAfter != nil {
+		search.CreatedAfter = request.CreatedAfter.AsTime()
+	}
+	if request.CreatedBefore != nil {
+		search.CreatedBefore = request.CreatedBefore.AsTime()
+	}
+	matches, next, total, err := l.db.SearchMessages(search)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	out := make([]*razpravljalnica.SearchResult, len(matches))
+	for i, match := range matches {
+		out[i] = &razpravljalnica.SearchResult{
+			Message: entities.EntityToDatalink(match.Value).GetMessage(),
+			Score:   match.Score,
+		}
+	}
+	return &razpravljalnica.SearchMessagesResponse{
+		Results:    out,
+		NextOffset: next,
+		Total:      int32(total),
 	}, nil
 }
 
@@ -173,12 +309,16 @@ func (l *listener) SubscribeTopic(
 	}
 	for e := range l.db.SubscribeTopic(g.Context(), request.GetTopicId()) {
 		var op razpravljalnica.OpType
-		switch e.Operation {
-		case datalink.Operation_Delete:
+		switch {
+		case e.Like && e.Operation == datalink.Operation_Create:
+			op = razpravljalnica.OpType_OP_LIKE
+		case e.Like && e.Operation == datalink.Operation_Delete:
+			op = razpravljalnica.OpType_OP_UNLIKE
+		case e.Operation == datalink.Operation_Delete:
 			op = razpravljalnica.OpType_OP_DELETE
-		case datalink.Operation_Update:
+		case e.Operation == datalink.Operation_Update:
 			op = razpravljalnica.OpType_OP_UPDATE
-		case datalink.Operation_Create:
+		case e.Operation == datalink.Operation_Create:
 			op = razpravljalnica.OpType_OP_POST
 		}
 
@@ -186,12 +326,7 @@ func (l *listener) SubscribeTopic(
 			Message: entities.EntityToDatalink(e.Message).GetMessage(),
 			Op:      op,
 		}
-		likes, err := l.db.GetLikes(e.Message.Id())
-		if err != nil {
-			return err
-		}
-		rMessage.Message.Likes = int32(likes)
-		err = g.Send(rMessage)
+		err := g.Send(rMessage)
 		if err != nil {
 			return err
 		}
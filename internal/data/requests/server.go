@@ -9,9 +9,15 @@ import (
 	"google.golang.org/grpc"
 )
 
+// TailReader brings the replica up to the operations the tail applied.
+type TailReader interface {
+	AwaitTail(ctx context.Context) error
+}
+
 type listener struct {
 	subToken string
 	db       *storage.AppDatabase
+	tail     TailReader
 	razpravljalnica.UnimplementedMessageBoardServer
 }
 
@@ -28,14 +34,25 @@ func NewServer(
 	database *storage.AppDatabase,
 	addr string,
 	subToken string,
+	tail TailReader,
 ) *Server {
-	l := &listener{db: database, subToken: subToken}
+	l := &listener{db: database, subToken: subToken, tail: tail}
 	s := rpc.NewServer(ctx, l, addr)
 	return &Server{
 		rpcServer: s,
 	}
 }
 
+// awaitClean lets reads at any node see what the tail confirmed. Reads of
+// records without pending changes are served as they are; otherwise the
+// replica first catches up with the tail.
+func (l *listener) awaitClean(ctx context.Context, dirty bool) error {
+	if !dirty {
+		return nil
+	}
+	return l.tail.AwaitTail(ctx)
+}
+
 func (s *Server) Done() <-chan struct{} {
 	return s.rpcServer.Done()
 }
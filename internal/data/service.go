@@ -2,11 +2,15 @@ package data
 
 import (
 	"context"
+	"log"
 	"seminarska/internal/data/chain"
+	"seminarska/internal/data/chain/stream"
 	"seminarska/internal/data/config"
 	"seminarska/internal/data/control"
 	"seminarska/internal/data/requests"
 	"seminarska/internal/data/storage"
+	"seminarska/internal/data/storage/replication"
+	"seminarska/internal/data/storage/wal"
 )
 
 type Service struct {
@@ -14,24 +18,44 @@ type Service struct {
 	database       *storage.AppDatabase
 	node           *chain.Node
 	control        *control.Server
+	spill          *wal.Segment
 	ctx            context.Context
 }
 
 func NewService(ctx context.Context, config config.NodeConfig) *Service {
-	database := storage.NewAppDatabase()
+	database := openDatabase(config)
+	database.ReplicationHandler().LimitWrites(replication.AdmissionLimits{
+		MaxInFlight: config.MaxInFlightWrites,
+		RetryAfter:  config.WriteRetryAfter,
+	})
+	// unconfirmed messages over the limits are spilled next to the data, or to a temporary file
+	spill, err := wal.OpenSegment(config.DataDir)
+	if err != nil {
+		log.Fatalln("Failed to open replay spill file:", err)
+	}
 	node := chain.NewNode(
 		ctx,
 		database.ReplicationHandler(),
 		database.ReplicationHandler(),
 		database,
+		chain.BufferLimits{
+			Messages: config.BufferMessages,
+			Bytes:    config.BufferBytes,
+			Spill:    spill,
+		},
+		stream.Batching{
+			Size:   config.BatchSize,
+			Linger: config.BatchLinger,
+		},
 		config.ChainListenerAddress,
 	)
 	s := &Service{
 		ctx:            ctx,
 		database:       database,
-		requestsServer: requests.NewServer(ctx, database, config.ServiceAddress, config.Token),
-		control:        control.NewServer(ctx, config.ControlListenerAddress, node),
+		requestsServer: requests.NewServer(ctx, database, config.ServiceAddress, config.Token, node),
+		control:        control.NewServer(ctx, config.ControlListenerAddress, node, database, node),
 		node:           node,
+		spill:          spill,
 	}
 	return s
 }
@@ -42,6 +66,30 @@ func (n *Service) Done() <-chan struct{} {
 		defer close(done)
 		<-n.node.Done()
 		<-n.requestsServer.Done()
+		if err := n.database.Close(); err != nil {
+			log.Println("Failed to close database:", err)
+		}
+		if err := n.spill.Close(); err != nil {
+			log.Println("Failed to remove replay spill file:", err)
+		}
 	}()
 	return done
 }
+
+func openDatabase(config config.NodeConfig) *storage.AppDatabase {
+	engine := storage.Engine(config.Engine)
+	if config.DataDir == "" {
+		if engine != storage.MemoryEngine {
+			log.Fatalln("Storage engine", engine, "needs a data directory")
+		}
+		return storage.NewAppDatabase()
+	}
+	database, err := storage.OpenAppDatabase(config.DataDir, storage.CheckpointPolicy{
+		Interval: config.SnapshotInterval,
+		Ops:      config.SnapshotOps,
+	}, engine)
+	if err != nil {
+		log.Fatalln("Failed to open database:", err)
+	}
+	return database
+}
@@ -0,0 +1,83 @@
+// Package boltstore keeps the confirmed values of relations in a bolt file. The
+// file is scratch space rather than a durable copy of the relations: snapshots
+// and the write-ahead log are what survive a restart.
+package boltstore
+
+import (
+	"encoding/binary"
+	"os"
+	"seminarska/internal/data/storage/entities"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Open opens a bolt file at path, discarding whatever an earlier run left in
+// it: relations are rebuilt from snapshots and the log on every start, so a
+// leftover file could only hold values the log replays again. For the same
+// reason the file is never synced; a crash loses nothing the log does not have.
+func Open(path string) (*bolt.DB, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = true
+	return db, nil
+}
+
+// Store keeps the entities of one relation in a bucket, keyed by id.
+type Store[E entities.Entity] struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func NewStore[E entities.Entity](db *bolt.DB, bucket string) (*Store[E], error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store[E]{db: db, bucket: []byte(bucket)}, nil
+}
+
+func (s *Store[E]) Get(id int64) (e E, found bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(s.bucket).Get(key(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		// data is only valid within the transaction, Unmarshal copies it
+		e, err = entities.Unmarshal[E](data)
+		return err
+	})
+	return
+}
+
+func (s *Store[E]) Put(e E) error {
+	data, err := entities.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put(key(e.Id()), data)
+	})
+}
+
+func (s *Store[E]) Delete(id int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete(key(id))
+	})
+}
+
+// key encodes ids big-endian, so the bucket is ordered by id.
+func key(id int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(id))
+	return k
+}
@@ -0,0 +1,77 @@
+package boltstore
+
+import (
+	"path/filepath"
+	"seminarska/internal/data/storage/entities"
+	"testing"
+	"time"
+)
+
+func TestStore_PutGetDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relations.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	messages, err := NewStore[*entities.Message](db, "messages")
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	revisions, err := NewStore[*entities.Revision](db, "revisions")
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+
+	created := time.Unix(1700000000, 0).UTC()
+	message := entities.NewMessage(2, 3, "hello", created)
+	message.SetId(7)
+	message.SetVersion(2)
+	message.Likes = 4
+	if err := messages.Put(message); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	revision := entities.NewRevision(7, 1, "helo", 3, created)
+	revision.SetId(8)
+	if err := revisions.Put(revision); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	got, found, err := messages.Get(7)
+	if err != nil || !found {
+		t.Fatalf("get: found=%v err=%v", found, err)
+	}
+	if got.Text != "hello" || got.Version() != 2 || got.Likes != 4 || !got.CreatedAt.Equal(created) {
+		t.Fatalf("unexpected message: %+v", got)
+	}
+	if _, found, _ := messages.Get(8); found {
+		t.Fatalf("buckets should be separate")
+	}
+	gotRevision, found, err := revisions.Get(8)
+	if err != nil || !found || gotRevision.Text != "helo" || gotRevision.MessageId != 7 {
+		t.Fatalf("unexpected revision: %+v found=%v err=%v", gotRevision, found, err)
+	}
+
+	if err := messages.Delete(7); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, found, _ := messages.Get(7); found {
+		t.Fatalf("deleted message still found")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// a reopened file starts empty, relations are rebuilt from the log
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	revisions, err = NewStore[*entities.Revision](db, "revisions")
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if _, found, _ := revisions.Get(8); found {
+		t.Fatalf("reopened store kept old values")
+	}
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"seminarska/internal/data/storage/wal"
+	"seminarska/proto/datalink"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CheckpointPolicy decides when a snapshot of the database is written to disk.
+// A checkpoint is taken once either limit is reached; zero disables a limit.
+type CheckpointPolicy struct {
+	Interval time.Duration
+	Ops      int
+}
+
+// checkpointer takes checkpoints in the background, so confirmations only count
+// towards them and never wait for a snapshot to be written.
+type checkpointer struct {
+	mx     sync.Mutex // held while a snapshot is saved
+	policy CheckpointPolicy
+	store  *wal.SnapshotStore
+	log    *wal.Log
+	ops    atomic.Int64
+	// importing is set while a transferred snapshot replaces the database,
+	// which is incomplete until the import finishes
+	importing bool
+	wake      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+	stopped   sync.WaitGroup
+}
+
+func newCheckpointer(policy CheckpointPolicy, store *wal.SnapshotStore, l *wal.Log) *checkpointer {
+	return &checkpointer{
+		policy: policy,
+		store:  store,
+		log:    l,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// onPersisted counts a confirmed operation and wakes the checkpointer once
+// enough of them were logged.
+func (c *checkpointer) onPersisted(int32) {
+	if ops := c.ops.Add(1); c.policy.Ops > 0 && ops >= int64(c.policy.Ops) {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run takes a checkpoint whenever enough operations were logged or the interval
+// passed with any logged, until stop is called.
+func (c *checkpointer) run(d *AppDatabase) {
+	c.stopped.Add(1)
+	go func() {
+		defer c.stopped.Done()
+		var tick <-chan time.Time
+		if c.policy.Interval > 0 {
+			ticker := time.NewTicker(c.policy.Interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-tick:
+			case <-c.wake:
+			}
+			if c.ops.Load() > 0 {
+				d.checkpoint()
+			}
+		}
+	}()
+}
+
+func (c *checkpointer) stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+	c.stopped.Wait()
+}
+
+// checkpoint saves the confirmed state; the relations are read while no
+// confirmation is applied, so the snapshot holds exactly the operations up to
+// the index it is saved at.
+func (d *AppDatabase) checkpoint() {
+	c := d.checkpoints
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.importing {
+		return
+	}
+	c.ops.Store(0)
+	snapshot := d.GetSnapshot()
+	snapshot.OpCount = snapshot.GetDigestIndex()
+	if err := c.save(snapshot); err != nil {
+		log.Println("Failed to checkpoint at message", snapshot.GetOpCount(), err)
+		return
+	}
+	log.Println("Checkpoint at message", snapshot.GetOpCount())
+}
+
+// beginTransfer stops checkpoints until the transferred snapshot is saved.
+func (c *checkpointer) beginTransfer() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.importing = true
+}
+
+// saveTransferred persists a snapshot imported through the handshake. The log
+// holds nothing that predates it, so it is cleared up to the snapshot.
+func (c *checkpointer) saveTransferred(snapshot *datalink.DatabaseSnapshot) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if err := c.store.Save(snapshot); err != nil {
+		return fmt.Errorf("saving transferred snapshot: %w", err)
+	}
+	c.importing = false
+	if err := c.log.Compact(snapshot.GetOpCount()); err != nil {
+		log.Println("Failed to compact log", err)
+	}
+	return nil
+}
+
+func (c *checkpointer) save(snapshot *datalink.DatabaseSnapshot) error {
+	if err := c.store.Save(snapshot); err != nil {
+		return err
+	}
+	through, err := c.store.Oldest()
+	if err != nil {
+		return err
+	}
+	return c.log.Compact(through)
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+)
+
+func TestAppDatabase_CheckpointInterval(t *testing.T) {
+	d, err := OpenAppDatabase(t.TempDir(), CheckpointPolicy{Interval: 10 * time.Millisecond}, MemoryEngine)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer d.Close()
+	h := d.ReplicationHandler()
+	msg := entities.EntityToDatalink(entities.NewUser("ana"))
+	msg.MessageIndex, msg.Op = 1, datalink.Operation_Create
+	if err := h.OnMessage(msg); err != nil {
+		t.Fatalf("message: %v", err)
+	}
+	h.OnConfirmation(&datalink.Confirmation{MessageIndex: 1, Ok: true})
+
+	// nothing else is confirmed, so the checkpoint comes from the interval alone
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot, err := d.checkpoints.store.Latest()
+		if err != nil {
+			t.Fatalf("latest snapshot: %v", err)
+		}
+		if snapshot != nil {
+			if snapshot.GetOpCount() != 1 || len(snapshot.GetUsers()) != 1 {
+				t.Fatalf("expected the checkpoint to hold message 1, got %d with %d users",
+					snapshot.GetOpCount(), len(snapshot.GetUsers()))
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no checkpoint was taken")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
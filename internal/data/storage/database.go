@@ -1,16 +1,37 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"seminarska/internal/data/storage/boltstore"
 	"seminarska/internal/data/storage/db"
 	"seminarska/internal/data/storage/entities"
 	"seminarska/internal/data/storage/replication"
+	"seminarska/internal/data/storage/wal"
 )
 
+// Engine selects where relations keep their confirmed values. Pending changes
+// and indexes are always kept in memory. Neither engine is durable on its own:
+// the bolt engine only moves values out of memory and is emptied on every
+// start, as the relations are rebuilt from snapshots and the log.
+type Engine string
+
+const (
+	MemoryEngine Engine = "memory"
+	BoltEngine   Engine = "bolt"
+)
+
+var ErrUnknownEngine = errors.New("unknown storage engine")
+
 type relations struct {
-	users    *db.Relation[*entities.User]
-	messages *db.Relation[*entities.Message]
-	topics   *db.Relation[*entities.Topic]
-	likes    *db.Relation[*entities.Like]
+	users     *db.Relation[*entities.User]
+	messages  *db.Relation[*entities.Message]
+	topics    *db.Relation[*entities.Topic]
+	likes     *db.Relation[*entities.Like]
+	revisions *db.Relation[*entities.Revision]
 }
 
 func (d *relations) Users() *db.Relation[*entities.User] {
@@ -29,24 +50,145 @@ func (d *relations) Likes() *db.Relation[*entities.Like] {
 	return d.likes
 }
 
+func (d *relations) Revisions() *db.Relation[*entities.Revision] {
+	return d.revisions
+}
+
+func memoryRelations() *relations {
+	return &relations{
+		users:     db.NewRelation[*entities.User](),
+		messages:  db.NewRelation[*entities.Message](),
+		topics:    db.NewRelation[*entities.Topic](),
+		likes:     db.NewRelation[*entities.Like](),
+		revisions: db.NewRelation[*entities.Revision](),
+	}
+}
+
+// boltRelations keeps the relations in a bolt file at path, which is closed by the returned closer.
+func boltRelations(path string) (*relations, io.Closer, error) {
+	file, err := boltstore.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, err := boltstore.NewStore[*entities.User](file, "users")
+	if err != nil {
+		return nil, nil, errors.Join(err, file.Close())
+	}
+	messages, err := boltstore.NewStore[*entities.Message](file, "messages")
+	if err != nil {
+		return nil, nil, errors.Join(err, file.Close())
+	}
+	topics, err := boltstore.NewStore[*entities.Topic](file, "topics")
+	if err != nil {
+		return nil, nil, errors.Join(err, file.Close())
+	}
+	likes, err := boltstore.NewStore[*entities.Like](file, "likes")
+	if err != nil {
+		return nil, nil, errors.Join(err, file.Close())
+	}
+	revisions, err := boltstore.NewStore[*entities.Revision](file, "revisions")
+	if err != nil {
+		return nil, nil, errors.Join(err, file.Close())
+	}
+	return &relations{
+		users:     db.NewStoredRelation[*entities.User](users),
+		messages:  db.NewStoredRelation[*entities.Message](messages),
+		topics:    db.NewStoredRelation[*entities.Topic](topics),
+		likes:     db.NewStoredRelation[*entities.Like](likes),
+		revisions: db.NewStoredRelation[*entities.Revision](revisions),
+	}, file, nil
+}
+
 type AppDatabase struct {
 	*relations
-	chain *replication.Handler
+	chain         *replication.Handler
+	checkpoints   *checkpointer
+	engine        io.Closer
+	restoredIndex int32
 }
 
 func NewAppDatabase() *AppDatabase {
-	relations := &relations{
-		users:    db.NewRelation[*entities.User](),
-		messages: db.NewRelation[*entities.Message](),
-		topics:   db.NewRelation[*entities.Topic](),
-		likes:    db.NewRelation[*entities.Like](),
-	}
-	return &AppDatabase{
+	return newAppDatabase(memoryRelations())
+}
+
+func newAppDatabase(relations *relations) *AppDatabase {
+	d := &AppDatabase{
 		relations: relations,
 		chain:     replication.NewHandler(relations),
 	}
+	d.chain.OnHead(d.runRetention)
+	return d
+}
+
+// OpenAppDatabase creates a database backed by snapshots and a write-ahead log in dir.
+// The newest valid snapshot is loaded and the log suffix after it is replayed
+// before the database is returned. The engine keeps the relations, the bolt
+// engine in a file in dir.
+func OpenAppDatabase(dir string, policy CheckpointPolicy, engine Engine) (*AppDatabase, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	store, err := wal.OpenSnapshotStore(filepath.Join(dir, "snapshots"), 2)
+	if err != nil {
+		return nil, err
+	}
+	var d *AppDatabase
+	switch engine {
+	case MemoryEngine:
+		d = NewAppDatabase()
+	case BoltEngine:
+		relations, file, err := boltRelations(filepath.Join(dir, "relations.db"))
+		if err != nil {
+			return nil, err
+		}
+		d = newAppDatabase(relations)
+		d.engine = file
+	default:
+		return nil, ErrUnknownEngine
+	}
+	snapshot, err := store.Latest()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		if err := d.importSnapshot(snapshot); err != nil {
+			return nil, fmt.Errorf("loading snapshot: %w", err)
+		}
+		d.restoredIndex = snapshot.GetOpCount()
+	}
+
+	l, err := wal.Open(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		return nil, err
+	}
+	d.restoredIndex, err = d.chain.Restore(l, d.restoredIndex)
+	if err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	d.checkpoints = newCheckpointer(policy, store, l)
+	d.chain.OnPersisted(d.checkpoints.onPersisted)
+	d.checkpoints.run(d)
+	return d, nil
 }
 
 func (d *AppDatabase) ReplicationHandler() *replication.Handler {
 	return d.chain
 }
+
+// LastRestoredIndex returns the index of the last confirmed operation recovered
+// from disk on startup, or 0 if nothing was recovered.
+func (d *AppDatabase) LastRestoredIndex() int32 {
+	return d.restoredIndex
+}
+
+func (d *AppDatabase) Close() error {
+	if d.checkpoints != nil {
+		d.checkpoints.stop()
+	}
+	err := d.chain.Close()
+	if d.engine != nil {
+		err = errors.Join(err, d.engine.Close())
+	}
+	return err
+}
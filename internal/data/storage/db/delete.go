@@ -1,40 +1,41 @@
 package db
 
 import (
-	"errors"
 	"seminarska/internal/data/storage/entities"
 )
 
 func (r *Relation[E]) Delete(id int64) (*DeleteReceipt[E], error) {
 	r.mx.Lock()
 	defer r.mx.Unlock()
-	e, err := r.getRecord(id)
+	record, err := r.getRecord(id)
 	if err != nil {
 		return nil, err
 	}
-	e.Delete()
-	receipt := newDeleteReceipt(r, e)
+	if _, err := record.current(); err != nil {
+		return nil, err
+	}
+	r.track(id, record)
+	receipt := newDeleteReceipt(r, record, record.remove())
 	return receipt, nil
 }
 
 type DeleteReceipt[E entities.Entity] struct {
-	r      *Relation[E]
-	record *MutableRecord[E]
+	r       *Relation[E]
+	record  *MutableRecord[E]
+	change  *value[E]
+	removed E // the confirmed value the delete removed, once confirmed
 }
 
 func (d *DeleteReceipt[E]) DeletedValue() E {
-	deleted, err := d.record.DirtyValue()
-	if !errors.Is(err, ErrDeleted) {
-		panic(err)
-	}
-	return deleted
+	return d.change.e
 }
 
 func newDeleteReceipt[E entities.Entity](
 	r *Relation[E],
 	record *MutableRecord[E],
+	change *value[E],
 ) *DeleteReceipt[E] {
-	return &DeleteReceipt[E]{r, record}
+	return &DeleteReceipt[E]{r: r, record: record, change: change}
 }
 
 func (d *DeleteReceipt[E]) Confirm() (err error) {
@@ -46,18 +47,38 @@ func (d *DeleteReceipt[E]) Confirm() (err error) {
 		panic(err)
 	}
 
-	if err := d.record.Commit(); err != nil {
+	// the record stays indexed until the store has dropped it
+	if err := d.r.store.Delete(indexedValue.Id()); err != nil {
+		d.record.discard(d.change)
+		d.r.settle(d.change.e.Id(), d.record)
+		return err
+	}
+	if err := d.record.commit(d.change); err != nil {
 		panic(err)
 	}
+	d.removed = indexedValue
 	d.r.uniqueIndex.Remove(indexedValue)
+	d.r.indexes.Remove(indexedValue)
+	d.r.texts.Remove(indexedValue)
 	delete(d.r.records, indexedValue.Id())
+	d.r.removeOrdered(indexedValue.Id())
 	return nil
 }
 
-func (d *DeleteReceipt[E]) Cancel(err error) {
+// undo puts back the value a confirmed delete removed.
+func (d *DeleteReceipt[E]) undo() {
 	d.r.mx.Lock()
 	defer d.r.mx.Unlock()
-	if err := d.record.Rollback(); err != nil {
-		panic(err)
-	}
+	_ = d.r.uniqueIndex.Add(d.removed)
+	d.r.indexes.Add(d.removed)
+	d.r.texts.Add(d.removed)
+	d.r.addOrdered(d.removed.Id())
+	_ = d.r.store.Put(d.removed)
+}
+
+func (d *DeleteReceipt[E]) Cancel(error) {
+	d.r.mx.Lock()
+	defer d.r.mx.Unlock()
+	d.record.discard(d.change)
+	d.r.settle(d.change.e.Id(), d.record)
 }
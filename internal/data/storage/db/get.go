@@ -9,15 +9,23 @@ var ErrNotFound = errors.New("record not found")
 
 type PredicateFunc[E entities.Entity] func(E) bool
 
-func (r *Relation[E]) getRecord(id int64) (e *MutableRecord[E], err error) {
-	rec, ok := r.records[id]
-	if !ok {
-		err = ErrNotFound
-		return
+// getRecord returns the tracked record of id, or one holding its stored value.
+func (r *Relation[E]) getRecord(id int64) (*MutableRecord[E], error) {
+	if record, ok := r.records[id]; ok {
+		return record, nil
+	}
+	e, found, err := r.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
 	}
-	return rec, nil
+	return storedRecord(e), nil
 }
 
+// Get returns the confirmed value of a record. Pending changes are not visible,
+// and a record that was never confirmed is not found.
 func (r *Relation[E]) Get(id int64) (e E, err error) {
 	r.mx.RLock()
 	defer r.mx.RUnlock()
@@ -25,37 +33,21 @@ func (r *Relation[E]) Get(id int64) (e E, err error) {
 	if err != nil {
 		return
 	}
-	if record.IsDirty() {
+	e, err = record.Value()
+	if errors.Is(err, ErrUninitialized) {
 		err = ErrNotFound
-		return
 	}
-	return record.Value()
+	return
 }
 
 const NoLimit = 0
 
+// GetPredicate returns up to limit confirmed records that satisfy the predicate, ordered by id.
 func (r *Relation[E]) GetPredicate(
 	predicate PredicateFunc[E],
 	limit int,
 ) (values []E, err error) {
-	if limit < 0 {
-		return nil, errors.New("limit must be non-negative")
-	}
-	r.mx.RLock()
-	defer r.mx.RUnlock()
-	for _, record := range r.records {
-		e, err := record.Value()
-		if err != nil {
-			continue
-		}
-		if predicate(e) {
-			values = append(values, e)
-		}
-		if len(values) == limit && limit != NoLimit {
-			break
-		}
-	}
-	return
+	return r.Scan(Range{Limit: limit}, predicate)
 }
 
 func (r *Relation[E]) GetAll() (values []E, err error) {
@@ -65,7 +57,7 @@ func (r *Relation[E]) GetAll() (values []E, err error) {
 func (r *Relation[E]) Count() int {
 	r.mx.RLock()
 	defer r.mx.RUnlock()
-	return len(r.records)
+	return len(r.order)
 }
 
 func (r *Relation[E]) GetTransform(id int64, transform TransformFunc[E]) (e E, err error) {
@@ -73,3 +65,47 @@ func (r *Relation[E]) GetTransform(id int64, transform TransformFunc[E]) (e E, e
 	defer r.mx.RUnlock()
 	return r.getTransform(id, transform)
 }
+
+// GetIndexed returns the confirmed records whose indexed field equals value, ordered by id.
+func (r *Relation[E]) GetIndexed(field string, value any) (values []E, err error) {
+	return r.ScanIndexed(field, value, Range{})
+}
+
+// CountIndexed returns the number of confirmed records whose indexed field equals value.
+func (r *Relation[E]) CountIndexed(field string, value any) (int, error) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return r.indexes.Count(field, value)
+}
+
+// Latest returns the latest value of a record, including changes that are not confirmed yet.
+func (r *Relation[E]) Latest(id int64) (e E, err error) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	record, err := r.getRecord(id)
+	if err != nil {
+		return
+	}
+	return record.current()
+}
+
+// IsDirty reports whether a record has changes that are not confirmed yet.
+func (r *Relation[E]) IsDirty(id int64) bool {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	record, ok := r.records[id]
+	return ok && record.IsDirty()
+}
+
+// HasDirty reports whether any record has changes that are not confirmed yet,
+// which scans may have to include.
+func (r *Relation[E]) HasDirty() bool {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	for _, record := range r.records {
+		if record.IsDirty() {
+			return true
+		}
+	}
+	return false
+}
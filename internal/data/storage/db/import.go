@@ -6,12 +6,25 @@ func (r *Relation[E]) Import(snapshot []E) error {
 	r.mx.Lock()
 	defer r.mx.Unlock()
 
-	if len(r.records) != 0 {
+	if len(r.order) != 0 {
 		return errors.New("cannot import into non-empty relation")
 	}
 
 	r.uniqueIndex.Reset()
-	for _, e := range snapshot {
+	r.indexes.Reset()
+	r.texts.Reset()
+	return r.appendUnsafe(snapshot)
+}
+
+// Append imports confirmed records next to the existing ones, for snapshots that arrive in parts.
+func (r *Relation[E]) Append(values []E) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.appendUnsafe(values)
+}
+
+func (r *Relation[E]) appendUnsafe(values []E) error {
+	for _, e := range values {
 		receipt, err := r.insertUnsafe(e)
 		if err != nil {
 			return err
@@ -23,3 +36,21 @@ func (r *Relation[E]) Import(snapshot []E) error {
 	}
 	return nil
 }
+
+// Clear removes every record together with its pending changes.
+func (r *Relation[E]) Clear() error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for _, id := range r.order {
+		if err := r.store.Delete(id); err != nil {
+			return err
+		}
+	}
+	r.records = make(map[int64]*MutableRecord[E])
+	r.order = nil
+	r.uniqueIndex.Reset()
+	r.indexes.Reset()
+	r.texts.Reset()
+	return nil
+}
@@ -1,8 +1,6 @@
 package db
 
 import (
-	"errors"
-	"log"
 	"seminarska/internal/data/storage/entities"
 )
 
@@ -19,42 +17,56 @@ func (r *Relation[E]) insertUnsafe(e E) (*UnsafeInsertReceipt[E], error) {
 		return nil, err
 	}
 	record := NewMutableRecord[E]()
-	_ = record.Write(e)
-	r.records[e.Id()] = record
-	receipt := newUnsafeInsertReceipt(r, record)
+	change, _ := record.write(e)
+	r.track(e.Id(), record)
+	r.addOrdered(e.Id())
+	receipt := newUnsafeInsertReceipt(r, record, change)
 	return receipt, nil
 }
 
 type UnsafeInsertReceipt[E entities.Entity] struct {
 	r      *Relation[E]
 	record *MutableRecord[E]
+	change *value[E]
 }
 
-func newUnsafeInsertReceipt[E entities.Entity](r *Relation[E], record *MutableRecord[E]) *UnsafeInsertReceipt[E] {
-	return &UnsafeInsertReceipt[E]{r: r, record: record}
+func newUnsafeInsertReceipt[E entities.Entity](r *Relation[E], record *MutableRecord[E], change *value[E]) *UnsafeInsertReceipt[E] {
+	return &UnsafeInsertReceipt[E]{r: r, record: record, change: change}
 }
 
+// Confirm stores the inserted value before indexing it; if the store fails,
+// the insert is cancelled.
 func (i *UnsafeInsertReceipt[E]) Confirm() error {
-	if err := i.record.Commit(); err != nil {
+	if err := i.r.store.Put(i.change.e); err != nil {
+		i.Cancel(err)
+		return err
+	}
+	if err := i.record.commit(i.change); err != nil {
 		panic(err)
 	}
+	i.r.indexes.Add(i.change.e)
+	i.r.texts.Add(i.change.e)
+	i.r.settle(i.change.e.Id(), i.record)
 	return nil
 }
 
-func (i *UnsafeInsertReceipt[E]) Cancel(err error) {
-	if err := i.record.Rollback(); err != nil {
-		panic(err)
-	}
-	e, err := i.record.Value()
-	if err != nil {
-		if errors.Is(err, ErrUninitialized) {
-			log.Println("failed to rollback insert: entity uninitialized")
-			return
-		}
-		panic(err)
-	}
-	delete(i.r.records, e.Id())
-	i.r.uniqueIndex.Remove(e)
+func (i *UnsafeInsertReceipt[E]) Cancel(error) {
+	i.record.discard(i.change)
+	id := i.change.e.Id()
+	delete(i.r.records, id)
+	i.r.removeOrdered(id)
+	i.r.uniqueIndex.Remove(i.change.e)
+}
+
+// undo removes a confirmed insert again.
+func (i *UnsafeInsertReceipt[E]) undo() {
+	id := i.change.e.Id()
+	i.r.uniqueIndex.Remove(i.change.e)
+	i.r.indexes.Remove(i.change.e)
+	i.r.texts.Remove(i.change.e)
+	delete(i.r.records, id)
+	i.r.removeOrdered(id)
+	_ = i.r.store.Delete(id)
 }
 
 type InsertReceipt[E entities.Entity] struct {
@@ -76,3 +88,9 @@ func (i *InsertReceipt[E]) Cancel(err error) {
 	defer i.r.mx.Unlock()
 	i.UnsafeInsertReceipt.Cancel(err)
 }
+
+func (i *InsertReceipt[E]) undo() {
+	i.r.mx.Lock()
+	defer i.r.mx.Unlock()
+	i.UnsafeInsertReceipt.undo()
+}
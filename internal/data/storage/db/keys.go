@@ -3,13 +3,97 @@ package db
 import (
 	"reflect"
 	"seminarska/internal/data/storage/keys"
+	"strings"
+)
+
+// Field options are set with the db struct tag as a comma separated list:
+//
+//	unique       - the field is part of the relation's default unique constraint
+//	unique=name  - the field is part of the named unique constraint; fields sharing
+//	               a name form a composite constraint, different names are independent
+//	index        - the relation keeps a secondary index on the field
+//	text         - the relation keeps a full-text index on the words of the string field
+//	ref=relation - the field holds the id of a record in the named relation; it
+//	               must be indexed too, as deletes look up the records referring to one
+//	cascade      - deleting the referenced record also deletes this one; without it
+//	               a referenced record cannot be deleted
+const (
+	tagUnique  = "unique"
+	tagIndex   = "index"
+	tagText    = "text"
+	tagRef     = "ref"
+	tagCascade = "cascade"
 )
 
 func newUniqueIndex[E any]() *keys.Index {
-	return keys.NewIndex(getUniqueFields[E]()...)
+	return keys.NewConstraintIndex(getConstraints[E]()...)
+}
+
+func newSecondaryIndexes[E any]() *keys.SecondaryIndexes {
+	var fields []string
+	forEachTag[E](func(field, option, _ string) {
+		if option == tagIndex {
+			fields = append(fields, field)
+		}
+	})
+	return keys.NewSecondaryIndexes(fields...)
+}
+
+func newTextIndexes[E any]() *keys.TextIndexes {
+	var fields []string
+	forEachTag[E](func(field, option, _ string) {
+		if option == tagText {
+			fields = append(fields, field)
+		}
+	})
+	return keys.NewTextIndexes(fields...)
+}
+
+func getForeignKeys[E any]() (fks []ForeignKey) {
+	cascade := make(map[string]bool)
+	forEachTag[E](func(field, option, value string) {
+		switch option {
+		case tagRef:
+			fks = append(fks, ForeignKey{Field: field, Relation: value})
+		case tagCascade:
+			cascade[field] = true
+		}
+	})
+	indexed := make(map[string]bool)
+	forEachTag[E](func(field, option, _ string) {
+		indexed[field] = indexed[field] || option == tagIndex
+	})
+	for i := range fks {
+		if !indexed[fks[i].Field] {
+			panic("foreign key " + fks[i].Field + " is not indexed")
+		}
+		fks[i].Cascade = cascade[fks[i].Field]
+	}
+	return
 }
 
-func getUniqueFields[E any]() (fields []string) {
+func getConstraints[E any]() (constraints []keys.Constraint) {
+	positions := make(map[string]int)
+	forEachTag[E](func(field, option, value string) {
+		if option != tagUnique {
+			return
+		}
+		name := value
+		if name == "" {
+			name = keys.DefaultConstraint
+		}
+		i, ok := positions[name]
+		if !ok {
+			i = len(constraints)
+			positions[name] = i
+			constraints = append(constraints, keys.Constraint{Name: name})
+		}
+		constraints[i].Fields = append(constraints[i].Fields, field)
+	})
+	return
+}
+
+func forEachTag[E any](fn func(field, option, value string)) {
 	e := reflect.TypeOf((*E)(nil)).Elem()
 	// Unwrap pointers until we reach a non-pointer type
 	for e.Kind() == reflect.Pointer {
@@ -20,9 +104,13 @@ func getUniqueFields[E any]() (fields []string) {
 	}
 	for i := 0; i < e.NumField(); i++ {
 		f := e.Field(i)
-		if f.Tag.Get("db") == "unique" {
-			fields = append(fields, f.Name)
+		tag := f.Tag.Get("db")
+		if tag == "" {
+			continue
+		}
+		for _, opt := range strings.Split(tag, ",") {
+			option, value, _ := strings.Cut(opt, "=")
+			fn(f.Name, option, value)
 		}
 	}
-	return
 }
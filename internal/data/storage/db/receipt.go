@@ -4,3 +4,42 @@ type Receipt interface {
 	Confirm() error
 	Cancel(err error)
 }
+
+// undoer is a receipt whose confirmation can be reverted. A receipt that fails
+// to confirm leaves nothing to undo, its change is dropped.
+type undoer interface {
+	undo()
+}
+
+// Receipts groups the receipts of one operation, so they are confirmed or cancelled together.
+type Receipts []Receipt
+
+// Confirm confirms the receipts in order. If one of them fails, the ones confirmed
+// before it are undone and the rest are cancelled, so the operation applies as a
+// whole or not at all.
+func (r Receipts) Confirm() error {
+	for i, receipt := range r {
+		if err := receipt.Confirm(); err != nil {
+			for j := len(r) - 1; j > i; j-- {
+				r[j].Cancel(err)
+			}
+			Receipts(r[:i]).undo()
+			return err
+		}
+	}
+	return nil
+}
+
+func (r Receipts) undo() {
+	for i := len(r) - 1; i >= 0; i-- {
+		if u, ok := r[i].(undoer); ok {
+			u.undo()
+		}
+	}
+}
+
+func (r Receipts) Cancel(err error) {
+	for i := len(r) - 1; i >= 0; i-- {
+		r[i].Cancel(err)
+	}
+}
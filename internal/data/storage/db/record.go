@@ -3,7 +3,7 @@ package db
 import (
 	"errors"
 	"seminarska/internal/data/storage/entities"
-	"sync"
+	"slices"
 )
 
 var (
@@ -63,43 +63,52 @@ func (s *SnapshotRecord[E]) Copy() *SnapshotRecord[E] {
 	}
 }
 
+// MutableRecord queues changes that are not confirmed yet. Changes are applied on
+// top of each other, so the dirty value is always the latest state. They are
+// confirmed in the order they were made, but any of them can be cancelled.
 type MutableRecord[E entities.Entity] struct {
-	dirtyMx *sync.Mutex
 	SnapshotRecord[E]
+	pending []*value[E]
 }
 
 func NewMutableRecord[E entities.Entity]() *MutableRecord[E] {
-	return &MutableRecord[E]{
-		dirtyMx: &sync.Mutex{},
-	}
+	return &MutableRecord[E]{}
+}
+
+// storedRecord returns a record holding a confirmed value without pending changes.
+func storedRecord[E entities.Entity](e E) *MutableRecord[E] {
+	r := NewMutableRecord[E]()
+	r.initialized = true
+	r.confirmedValue = value[E]{e: e}
+	r.dirtyValue = r.confirmedValue
+	return r
 }
 
 func (r *MutableRecord[E]) Delete() {
-	r.dirtyMx.Lock()
-	r.dirtyValue.e = r.confirmedValue.e
-	r.dirtyValue.deleted = true
-	r.dirty = true
+	r.remove()
 }
 
 func (r *MutableRecord[E]) Write(value E) error {
-	r.dirtyMx.Lock()
-	if r.confirmedValue.deleted {
-		r.dirtyMx.Unlock()
-		return ErrDeleted
-	}
-	r.dirty = true
-	r.dirtyValue.e = value
-	return nil
+	_, err := r.write(value)
+	return err
 }
 
+// Commit confirms the oldest pending change.
 func (r *MutableRecord[E]) Commit() error {
 	if !r.dirty {
 		return ErrNotDirty
 	}
-	r.confirmedValue = r.dirtyValue
+	return r.commit(r.pending[0])
+}
+
+// commit confirms the given pending change, which receipts use to confirm their own.
+func (r *MutableRecord[E]) commit(change *value[E]) error {
+	if !r.dirty || !slices.Contains(r.pending, change) {
+		return ErrNotDirty
+	}
+	r.confirmedValue = *change
 	r.initialized = true
-	r.dirty = false
-	r.dirtyMx.Unlock()
+	r.discard(change)
 	return nil
 }
 
@@ -107,12 +116,61 @@ func (r *MutableRecord[E]) Rollback() error {
 	if !r.dirty {
 		return ErrNotDirty
 	}
-	r.dirty = false
-	r.dirtyValue = r.confirmedValue
-	r.dirtyMx.Unlock()
+	r.discard(r.pending[0])
 	return nil
 }
 
+// write queues a new value and returns the pending change, so it can be cancelled on its own.
+func (r *MutableRecord[E]) write(e E) (*value[E], error) {
+	if r.latest().deleted {
+		return nil, ErrDeleted
+	}
+	return r.push(value[E]{e: e}), nil
+}
+
+func (r *MutableRecord[E]) remove() *value[E] {
+	return r.push(value[E]{e: r.latest().e, deleted: true})
+}
+
+// current returns the latest value of the record, including unconfirmed changes.
+func (r *MutableRecord[E]) current() (e E, err error) {
+	if !r.dirty {
+		return r.Value()
+	}
+	return r.DirtyValue()
+}
+
+func (r *MutableRecord[E]) latest() value[E] {
+	if r.dirty {
+		return r.dirtyValue
+	}
+	return r.confirmedValue
+}
+
+func (r *MutableRecord[E]) push(v value[E]) *value[E] {
+	r.pending = append(r.pending, &v)
+	r.dirty = true
+	r.dirtyValue = v
+	return &v
+}
+
+// discard drops a pending change. Later changes stay queued.
+func (r *MutableRecord[E]) discard(change *value[E]) {
+	for i, p := range r.pending {
+		if p == change {
+			r.pending = append(r.pending[:i:i], r.pending[i+1:]...)
+			break
+		}
+	}
+	r.dirty = len(r.pending) > 0
+	if r.dirty {
+		r.dirtyValue = *r.pending[len(r.pending)-1]
+	} else {
+		r.pending = nil
+		r.dirtyValue = r.confirmedValue
+	}
+}
+
 func (r *MutableRecord[E]) CurrentSnapshot() *SnapshotRecord[E] {
 	return r.SnapshotRecord.Copy()
 }
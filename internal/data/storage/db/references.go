@@ -0,0 +1,91 @@
+package db
+
+import (
+	"reflect"
+	"slices"
+)
+
+// ForeignKey is a field declared with the ref option.
+type ForeignKey struct {
+	Field    string
+	Relation string
+	Cascade  bool
+}
+
+// Reference is the id of a record in another relation, held by a foreign key field.
+type Reference struct {
+	Field    string
+	Relation string
+	Id       int64
+}
+
+// References returns the records e refers to through its ref tagged fields.
+func (r *Relation[E]) References(e E) []Reference {
+	if len(r.foreignKeys) == 0 {
+		return nil
+	}
+	v := reflect.ValueOf(e)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	refs := make([]Reference, len(r.foreignKeys))
+	for i, fk := range r.foreignKeys {
+		refs[i] = Reference{
+			Field:    fk.Field,
+			Relation: fk.Relation,
+			Id:       v.FieldByName(fk.Field).Int(),
+		}
+	}
+	return refs
+}
+
+func (r *Relation[E]) ForeignKeys() []ForeignKey {
+	return r.foreignKeys
+}
+
+// Contains reports whether the record exists in its latest state, including
+// changes that are not confirmed yet. Replicas apply operations in the same
+// order, so checks made against this state give the same result on every node.
+func (r *Relation[E]) Contains(id int64) bool {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	record, err := r.getRecord(id)
+	if err != nil {
+		return false
+	}
+	_, err = record.current()
+	return err == nil
+}
+
+// Referencing returns the ids of records whose field refers to id, ordered by id.
+// Like Contains, it looks at the latest state of each record: the index holds
+// the confirmed values, and records with pending changes are checked on their own.
+func (r *Relation[E]) Referencing(field string, id int64) []int64 {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	indexed, err := r.indexes.Lookup(field, id)
+	if err != nil {
+		panic(err)
+	}
+	var ids []int64
+	for _, rid := range indexed {
+		if _, pending := r.records[rid]; !pending {
+			ids = append(ids, rid)
+		}
+	}
+	for rid, record := range r.records {
+		e, err := record.current()
+		if err != nil {
+			continue
+		}
+		v := reflect.ValueOf(e)
+		for v.Kind() == reflect.Pointer {
+			v = v.Elem()
+		}
+		if v.FieldByName(field).Int() == id {
+			ids = append(ids, rid)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
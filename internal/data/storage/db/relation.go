@@ -6,16 +6,47 @@ import (
 	"sync"
 )
 
+// Relation stores records by id. Confirmed values live in the store, records
+// with pending changes are kept aside until they settle. Next to them it keeps
+// the ids in ascending order, so scans are deterministic and support cursors.
 type Relation[E entities.Entity] struct {
 	mx          *sync.RWMutex
 	uniqueIndex *keys.Index
-	records     map[int64]*MutableRecord[E]
+	indexes     *keys.SecondaryIndexes
+	texts       *keys.TextIndexes
+	foreignKeys []ForeignKey
+	store       Store[E]
+	records     map[int64]*MutableRecord[E] // records with pending changes
+	order       []int64
 }
 
+// NewRelation returns a relation kept in memory.
 func NewRelation[E entities.Entity]() *Relation[E] {
+	return NewStoredRelation(NewMemoryStore[E]())
+}
+
+// NewStoredRelation returns a relation that keeps its confirmed values in an empty store.
+func NewStoredRelation[E entities.Entity](store Store[E]) *Relation[E] {
 	return &Relation[E]{
 		mx:          &sync.RWMutex{},
 		uniqueIndex: newUniqueIndex[E](),
+		indexes:     newSecondaryIndexes[E](),
+		texts:       newTextIndexes[E](),
+		foreignKeys: getForeignKeys[E](),
+		store:       store,
 		records:     make(map[int64]*MutableRecord[E]),
 	}
 }
+
+// track keeps a record aside while it has pending changes.
+func (r *Relation[E]) track(id int64, record *MutableRecord[E]) {
+	r.records[id] = record
+}
+
+// settle stops tracking a record once its changes are confirmed or cancelled;
+// its confirmed value is in the store by then.
+func (r *Relation[E]) settle(id int64, record *MutableRecord[E]) {
+	if !record.IsDirty() && r.records[id] == record {
+		delete(r.records, id)
+	}
+}
@@ -1,7 +1,12 @@
 package db
 
 import (
+	"errors"
+	"slices"
 	"testing"
+
+	"seminarska/internal/data/storage/entities"
+	"seminarska/internal/data/storage/keys"
 )
 
 // simple entity for tests
@@ -119,6 +124,39 @@ func TestRelation_Import(t *testing.T) {
 	}
 }
 
+func TestRelation_AppendClear(t *testing.T) {
+	r := NewRelation[*e]()
+	for _, part := range [][]int64{{1, 2}, {3}} {
+		var items []*e
+		for _, id := range part {
+			it := &e{Name: "i"}
+			it.SetId(id)
+			items = append(items, it)
+		}
+		if err := r.Append(items); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if c := r.Count(); c != 3 {
+		t.Fatalf("expected 3 got %d", c)
+	}
+	if err := r.Append([]*e{{id: 3}}); err == nil {
+		t.Fatalf("expected appending a duplicate id to fail")
+	}
+	if _, err := r.Delete(1); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := r.Clear(); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if c := r.Count(); c != 0 || r.Contains(1) || r.Contains(3) {
+		t.Fatalf("expected an empty relation, got %d records", c)
+	}
+	if err := r.Import([]*e{{id: 3}}); err != nil {
+		t.Fatalf("import after clear: %v", err)
+	}
+}
+
 func TestRecord_CommitRollback(t *testing.T) {
 	r := NewMutableRecord[*e]()
 	// write when uninitialized should succeed
@@ -163,3 +201,506 @@ func TestTransform_GetTransformErrors(t *testing.T) {
 		t.Fatalf("expected id changed error")
 	}
 }
+
+type indexed struct {
+	id    int64
+	Group int64 `db:"index"`
+}
+
+func (x *indexed) Id() int64      { return x.id }
+func (x *indexed) SetId(id int64) { x.id = id }
+
+func TestRelation_SecondaryIndex(t *testing.T) {
+	r := NewRelation[*indexed]()
+	for i := int64(1); i <= 3; i++ {
+		ins, err := r.Insert(&indexed{id: i, Group: i % 2})
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		// unconfirmed records are not visible through the index
+		if n, _ := r.CountIndexed("Group", i%2); i == 1 && n != 0 {
+			t.Fatalf("expected dirty insert to be unindexed, got %d", n)
+		}
+		if err := ins.Confirm(); err != nil {
+			t.Fatalf("confirm: %v", err)
+		}
+	}
+	odd, err := r.GetIndexed("Group", 1)
+	if err != nil || len(odd) != 2 || odd[0].Id() != 1 || odd[1].Id() != 3 {
+		t.Fatalf("unexpected lookup: %v %v", odd, err)
+	}
+
+	upd, err := r.Update(1, func(orig *indexed) (*indexed, error) {
+		return &indexed{id: orig.id, Group: 0}, nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	_ = upd.Confirm()
+	if n, _ := r.CountIndexed("Group", 0); n != 2 {
+		t.Fatalf("expected update to move record, got %d", n)
+	}
+
+	del, err := r.Delete(3)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	_ = del.Confirm()
+	if n, _ := r.CountIndexed("Group", 1); n != 0 {
+		t.Fatalf("expected delete to unindex record, got %d", n)
+	}
+	if _, err := r.GetIndexed("id", 1); err == nil {
+		t.Fatalf("expected error for unindexed field")
+	}
+}
+
+func TestRelation_ScanRanges(t *testing.T) {
+	r := NewRelation[*indexed]()
+	// insert out of order, scans must still be ordered by id
+	for _, id := range []int64{5, 1, 4, 2, 3, 6} {
+		ins, _ := r.Insert(&indexed{id: id, Group: id % 2})
+		_ = ins.Confirm()
+	}
+	ids := func(values []*indexed) (out []int64) {
+		for _, v := range values {
+			out = append(out, v.Id())
+		}
+		return
+	}
+	all := func(*indexed) bool { return true }
+
+	asc, _ := r.Scan(Range{From: 3, Limit: 2}, all)
+	if got := ids(asc); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Fatalf("unexpected ascending page: %v", got)
+	}
+	desc, _ := r.Scan(Range{From: 5, Limit: 3, Direction: Descending}, all)
+	if got := ids(desc); len(got) != 3 || got[0] != 4 || got[2] != 2 {
+		t.Fatalf("unexpected descending page: %v", got)
+	}
+	newest, _ := r.Scan(Range{Limit: 1, Direction: Descending}, all)
+	if got := ids(newest); len(got) != 1 || got[0] != 6 {
+		t.Fatalf("expected newest record, got %v", got)
+	}
+	even, _ := r.ScanIndexed("Group", 0, Range{From: 6, Direction: Descending})
+	if got := ids(even); len(got) != 2 || got[0] != 4 || got[1] != 2 {
+		t.Fatalf("unexpected indexed page: %v", got)
+	}
+	if _, err := r.Scan(Range{Limit: -1}, all); err == nil {
+		t.Fatalf("expected error for negative limit")
+	}
+}
+
+func TestRelation_CancelInsert(t *testing.T) {
+	r := NewRelation[*e]()
+	ins, err := r.Insert(&e{id: 1, Name: "a"})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	ins.Cancel(nil)
+	if c := r.Count(); c != 0 {
+		t.Fatalf("expected cancelled insert to be removed, count %d", c)
+	}
+	values, _ := r.GetAll()
+	if len(values) != 0 {
+		t.Fatalf("expected no values, got %v", values)
+	}
+}
+
+type constrained struct {
+	id     int64
+	Name   string `db:"unique=name"`
+	Owner  int64  `db:"unique=pair"`
+	Target int64  `db:"unique=pair"`
+}
+
+func (x *constrained) Id() int64      { return x.id }
+func (x *constrained) SetId(id int64) { x.id = id }
+
+func TestRelation_NamedConstraints(t *testing.T) {
+	r := NewRelation[*constrained]()
+	ins, err := r.Insert(&constrained{id: 1, Name: "a", Owner: 1, Target: 1})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	_ = ins.Confirm()
+	if ins, err = r.Insert(&constrained{id: 2, Name: "b", Owner: 1, Target: 2}); err != nil {
+		t.Fatalf("independent constraints should allow insert: %v", err)
+	}
+	_ = ins.Confirm()
+
+	var ce *keys.ConstraintError
+	_, err = r.Insert(&constrained{id: 3, Name: "c", Owner: 1, Target: 2})
+	if !errors.As(err, &ce) || ce.Constraint != "pair" {
+		t.Fatalf("expected pair constraint violation, got %v", err)
+	}
+	upd, err := r.Update(1, func(orig *constrained) (*constrained, error) {
+		return &constrained{id: orig.id, Name: "b", Owner: orig.Owner, Target: orig.Target}, nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	// updates are checked against the constraints when confirmed
+	if err = upd.Confirm(); !errors.As(err, &ce) || ce.Constraint != "name" {
+		t.Fatalf("expected name constraint violation, got %v", err)
+	}
+}
+
+type referencing struct {
+	id    int64
+	Owner int64 `db:"index,ref=owners"`
+}
+
+func (x *referencing) Id() int64      { return x.id }
+func (x *referencing) SetId(id int64) { x.id = id }
+
+func TestRelation_ReferencesAndContains(t *testing.T) {
+	r := NewRelation[*referencing]()
+	refs := r.References(&referencing{id: 1, Owner: 7})
+	if len(refs) != 1 || refs[0].Relation != "owners" || refs[0].Id != 7 || refs[0].Field != "Owner" {
+		t.Fatalf("unexpected references: %+v", refs)
+	}
+
+	ins, _ := r.Insert(&referencing{id: 1})
+	// pending inserts are visible, so later operations can refer to them
+	if !r.Contains(1) {
+		t.Fatalf("expected pending insert to be contained")
+	}
+	_ = ins.Confirm()
+	del, _ := r.Delete(1)
+	if r.Contains(1) {
+		t.Fatalf("expected pending delete to hide the record")
+	}
+	del.Cancel(nil)
+	if !r.Contains(1) {
+		t.Fatalf("expected cancelled delete to restore the record")
+	}
+	if r.Contains(2) {
+		t.Fatalf("unexpected record 2")
+	}
+}
+
+func TestRelation_Referencing(t *testing.T) {
+	r := NewRelation[*referencing]()
+	for id, owner := range map[int64]int64{1: 7, 2: 7, 3: 8, 4: 8} {
+		ins, _ := r.Insert(&referencing{id: id, Owner: owner})
+		_ = ins.Confirm()
+	}
+	// pending changes count: 5 is inserted, 2 moves away, 3 moves over and 1 is deleted
+	_, _ = r.Insert(&referencing{id: 5, Owner: 7})
+	_, _ = r.Update(2, func(*referencing) (*referencing, error) { return &referencing{id: 2, Owner: 8}, nil })
+	_, _ = r.Update(3, func(*referencing) (*referencing, error) { return &referencing{id: 3, Owner: 7}, nil })
+	_, _ = r.Delete(1)
+	if ids := r.Referencing("Owner", 7); !slices.Equal(ids, []int64{3, 5}) {
+		t.Fatalf("expected [3 5] to refer to 7, got %v", ids)
+	}
+	if ids := r.Referencing("Owner", 8); !slices.Equal(ids, []int64{2, 4}) {
+		t.Fatalf("expected [2 4] to refer to 8, got %v", ids)
+	}
+}
+
+func TestRecord_PendingChanges(t *testing.T) {
+	r := NewMutableRecord[*e]()
+	_ = r.Write(&e{id: 1, Name: "a"})
+	second, _ := r.write(&e{id: 1, Name: "b"})
+	third, _ := r.write(&e{id: 1, Name: "c"})
+	if v, _ := r.DirtyValue(); v.Name != "c" {
+		t.Fatalf("expected the latest change to be dirty, got %v", v.Name)
+	}
+	r.discard(third)
+	if v, _ := r.DirtyValue(); v.Name != "b" {
+		t.Fatalf("expected discard to uncover the previous change, got %v", v.Name)
+	}
+	_ = r.Commit()
+	if v, _ := r.Value(); v.Name != "a" || !r.IsDirty() {
+		t.Fatalf("expected the oldest change to be committed first, got %v", v.Name)
+	}
+	r.discard(second)
+	if r.IsDirty() {
+		t.Fatalf("expected no pending changes")
+	}
+	if v, _ := r.current(); v.Name != "a" {
+		t.Fatalf("unexpected current value %v", v.Name)
+	}
+}
+
+type document struct {
+	id   int64
+	Body string `db:"text"`
+}
+
+func (x *document) Id() int64      { return x.id }
+func (x *document) SetId(id int64) { x.id = id }
+
+func TestRelation_Search(t *testing.T) {
+	r := NewRelation[*document]()
+	query := keys.ParseTextQuery("chain")
+	for i, body := range []string{"chain replication", "a chain of nodes", "raft"} {
+		ins, err := r.Insert(&document{id: int64(i + 1), Body: body})
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		// unconfirmed records are not searchable
+		if found, _ := r.Search("Body", query, func(*document) bool { return true }); i == 0 && len(found) != 0 {
+			t.Fatalf("expected dirty insert to be unindexed, got %v", found)
+		}
+		_ = ins.Confirm()
+	}
+	found, err := r.Search("Body", query, func(d *document) bool { return d.id != 1 })
+	if err != nil || len(found) != 1 || found[0].Value.id != 2 {
+		t.Fatalf("expected the predicate to leave document 2: %v %v", found, err)
+	}
+
+	upd, _ := r.Update(3, func(*document) (*document, error) {
+		return &document{id: 3, Body: "raft and chain"}, nil
+	})
+	_ = upd.Confirm()
+	del, _ := r.Delete(1)
+	_ = del.Confirm()
+	found, _ = r.Search("Body", query, func(*document) bool { return true })
+	if len(found) != 2 || found[0].Value.id+found[1].Value.id != 5 {
+		t.Fatalf("expected updated and remaining documents, got %v", found)
+	}
+	if _, err := r.Search("Missing", query, func(*document) bool { return true }); err == nil {
+		t.Fatalf("expected error for unindexed field")
+	}
+}
+
+// copyingStore keeps copies of the values, like a store on disk would.
+type copyingStore struct {
+	values map[int64]e
+}
+
+func (s *copyingStore) Get(id int64) (*e, bool, error) {
+	v, ok := s.values[id]
+	return &v, ok, nil
+}
+
+func (s *copyingStore) Put(x *e) error {
+	s.values[x.Id()] = *x
+	return nil
+}
+
+func (s *copyingStore) Delete(id int64) error {
+	delete(s.values, id)
+	return nil
+}
+
+func TestRelation_Store(t *testing.T) {
+	store := &copyingStore{values: make(map[int64]e)}
+	r := NewStoredRelation[*e](store)
+	for i, name := range []string{"a", "b"} {
+		val := &e{Name: name}
+		val.SetId(int64(i + 1))
+		ins, err := r.Insert(val)
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		if _, ok := store.values[val.Id()]; ok {
+			t.Fatalf("pending insert reached the store")
+		}
+		if err := ins.Confirm(); err != nil {
+			t.Fatalf("confirm insert: %v", err)
+		}
+	}
+	if len(r.records) != 0 || len(store.values) != 2 {
+		t.Fatalf("confirmed records should only be in the store: %d tracked, %d stored", len(r.records), len(store.values))
+	}
+
+	upd, err := r.Update(1, func(x *e) (*e, error) {
+		return &e{id: x.id, Name: "a2"}, nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got, _ := r.Get(1); got.Name != "a" {
+		t.Fatalf("expected confirmed value a, got %q", got.Name)
+	}
+	if got, _ := r.Latest(1); got.Name != "a2" {
+		t.Fatalf("expected latest value a2, got %q", got.Name)
+	}
+	if err := upd.Confirm(); err != nil {
+		t.Fatalf("confirm update: %v", err)
+	}
+	if store.values[1].Name != "a2" {
+		t.Fatalf("confirmed update not stored: %+v", store.values[1])
+	}
+
+	del, err := r.Delete(2)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	del.Cancel(nil)
+	if _, ok := store.values[2]; !ok || !r.Contains(2) {
+		t.Fatalf("cancelled delete removed the record")
+	}
+	del, err = r.Delete(2)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := del.Confirm(); err != nil {
+		t.Fatalf("confirm delete: %v", err)
+	}
+	if _, ok := store.values[2]; ok || r.Contains(2) || r.Count() != 1 {
+		t.Fatalf("confirmed delete kept the record")
+	}
+	if len(r.records) != 0 {
+		t.Fatalf("settled records are still tracked: %d", len(r.records))
+	}
+}
+
+func TestRelation_Dirty(t *testing.T) {
+	r := NewRelation[*e]()
+	val := &e{Name: "a"}
+	val.SetId(1)
+	ins, err := r.Insert(val)
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if !r.IsDirty(1) || !r.HasDirty() {
+		t.Fatalf("expected pending insert to be dirty")
+	}
+	if err := ins.Confirm(); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if r.IsDirty(1) || r.HasDirty() {
+		t.Fatalf("expected confirmed record to be clean")
+	}
+	if r.IsDirty(2) {
+		t.Fatalf("expected missing record to be clean")
+	}
+	del, err := r.Delete(1)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if !r.IsDirty(1) {
+		t.Fatalf("expected pending delete to be dirty")
+	}
+	del.Cancel(errors.New("rejected"))
+	if r.IsDirty(1) || r.HasDirty() {
+		t.Fatalf("expected cancelled delete to leave the record clean")
+	}
+}
+
+func TestReceipts_ConfirmAtomic(t *testing.T) {
+	r := NewRelation[*constrained]()
+	for _, c := range []*constrained{{id: 1, Name: "a", Owner: 1}, {id: 2, Name: "b", Owner: 2}, {id: 3, Name: "c", Owner: 3}} {
+		ins, _ := r.Insert(c)
+		_ = ins.Confirm()
+	}
+	renamed, _ := r.Update(1, func(orig *constrained) (*constrained, error) {
+		return &constrained{id: orig.id, Name: "a2", Owner: orig.Owner}, nil
+	})
+	deleted, _ := r.Delete(3)
+	inserted, err := r.Insert(&constrained{id: 4, Name: "d", Owner: 4})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	// the name is taken, which is only found out on confirmation
+	conflicting, _ := r.Update(2, func(orig *constrained) (*constrained, error) {
+		return &constrained{id: orig.id, Name: "a2", Owner: orig.Owner}, nil
+	})
+	later, _ := r.Update(2, func(orig *constrained) (*constrained, error) {
+		return &constrained{id: orig.id, Name: "b2", Owner: orig.Owner}, nil
+	})
+
+	batch := Receipts{renamed, deleted, inserted, conflicting, later}
+	var ce *keys.ConstraintError
+	if err := batch.Confirm(); !errors.As(err, &ce) {
+		t.Fatalf("expected a constraint violation, got %v", err)
+	}
+	for id, name := range map[int64]string{1: "a", 2: "b", 3: "c"} {
+		if got, err := r.Get(id); err != nil || got.Name != name {
+			t.Fatalf("expected %d to keep %q, got %v %v", id, name, got, err)
+		}
+	}
+	if r.Contains(4) || r.Count() != 3 || r.IsDirty(2) {
+		t.Fatalf("expected the failed batch to leave no trace")
+	}
+	// the undone values are indexed again, the ones of the batch are not
+	if _, err := r.Insert(&constrained{id: 5, Name: "a2", Owner: 5}); err != nil {
+		t.Fatalf("expected the undone name to be free: %v", err)
+	}
+	if _, err := r.Insert(&constrained{id: 6, Name: "c", Owner: 6}); err == nil {
+		t.Fatalf("expected the restored record to keep its name")
+	}
+}
+
+func TestReceipt_ConfirmOwnChange(t *testing.T) {
+	r := NewRelation[*e]()
+	ins, _ := r.Insert(&e{id: 1, Name: "a"})
+	_ = ins.Confirm()
+	first, _ := r.Update(1, func(x *e) (*e, error) { return &e{id: x.id, Name: "b"}, nil })
+	second, _ := r.Update(1, func(x *e) (*e, error) { return &e{id: x.id, Name: "c"}, nil })
+	if err := second.Confirm(); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	first.Cancel(nil)
+	if got, _ := r.Get(1); got.Name != "c" {
+		t.Fatalf("expected the confirmed change c, got %q", got.Name)
+	}
+}
+
+var errStore = errors.New("store failed")
+
+// failingStore fails its writes while fail is set.
+type failingStore[E entities.Entity] struct {
+	Store[E]
+	fail bool
+}
+
+func (s *failingStore[E]) Put(e E) error {
+	if s.fail {
+		return errStore
+	}
+	return s.Store.Put(e)
+}
+
+func (s *failingStore[E]) Delete(id int64) error {
+	if s.fail {
+		return errStore
+	}
+	return s.Store.Delete(id)
+}
+
+func TestRelation_StoreFailure(t *testing.T) {
+	store := &failingStore[*constrained]{Store: NewMemoryStore[*constrained](), fail: true}
+	r := NewStoredRelation[*constrained](store)
+	ins, _ := r.Insert(&constrained{id: 1, Name: "a", Owner: 1})
+	if err := ins.Confirm(); !errors.Is(err, errStore) {
+		t.Fatalf("expected the store error, got %v", err)
+	}
+	if r.Contains(1) || r.Count() != 0 {
+		t.Fatalf("expected the failed insert to leave no trace")
+	}
+	store.fail = false
+	ins, err := r.Insert(&constrained{id: 1, Name: "a", Owner: 1})
+	if err != nil {
+		t.Fatalf("expected the name to be free again: %v", err)
+	}
+	_ = ins.Confirm()
+
+	store.fail = true
+	upd, _ := r.Update(1, func(orig *constrained) (*constrained, error) {
+		return &constrained{id: orig.id, Name: "b", Owner: orig.Owner}, nil
+	})
+	if err := upd.Confirm(); !errors.Is(err, errStore) {
+		t.Fatalf("expected the store error, got %v", err)
+	}
+	if got, _ := r.Get(1); got.Name != "a" || r.IsDirty(1) {
+		t.Fatalf("expected the failed update to leave %q, got %v", "a", got)
+	}
+	if _, err := r.Insert(&constrained{id: 2, Name: "b", Owner: 2}); err != nil {
+		t.Fatalf("expected the name of the failed update to be free: %v", err)
+	}
+
+	del, _ := r.Delete(1)
+	if err := del.Confirm(); !errors.Is(err, errStore) {
+		t.Fatalf("expected the store error, got %v", err)
+	}
+	if !r.Contains(1) {
+		t.Fatalf("expected the failed delete to keep the record")
+	}
+	if _, err := r.Insert(&constrained{id: 3, Name: "a", Owner: 3}); err == nil {
+		t.Fatalf("expected the record to keep its name")
+	}
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"errors"
+	"slices"
+)
+
+type Direction int
+
+const (
+	Ascending Direction = iota
+	Descending
+)
+
+// Range selects records by id. An ascending range starts at From (inclusive),
+// a descending range at the record before From; a zero From starts at the newest record.
+type Range struct {
+	From      int64
+	Limit     int
+	Direction Direction
+}
+
+// Scan returns up to rng.Limit confirmed records that satisfy the predicate, in range order.
+func (r *Relation[E]) Scan(rng Range, predicate PredicateFunc[E]) ([]E, error) {
+	if rng.Limit < 0 {
+		return nil, errors.New("limit must be non-negative")
+	}
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return r.collect(window(r.order, rng), rng, predicate), nil
+}
+
+// ScanIndexed is like Scan, but only visits records whose indexed field equals value.
+func (r *Relation[E]) ScanIndexed(field string, value any, rng Range) ([]E, error) {
+	if rng.Limit < 0 {
+		return nil, errors.New("limit must be non-negative")
+	}
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	ids, err := r.indexes.Lookup(field, value)
+	if err != nil {
+		return nil, err
+	}
+	return r.collect(window(ids, rng), rng, func(E) bool { return true }), nil
+}
+
+func (r *Relation[E]) collect(ids []int64, rng Range, predicate PredicateFunc[E]) (values []E) {
+	for i := range ids {
+		id := ids[i]
+		if rng.Direction == Descending {
+			id = ids[len(ids)-1-i]
+		}
+		record, err := r.getRecord(id)
+		if err != nil {
+			continue
+		}
+		e, err := record.Value()
+		if err != nil {
+			continue
+		}
+		if predicate(e) {
+			values = append(values, e)
+		}
+		if len(values) == rng.Limit && rng.Limit != NoLimit {
+			break
+		}
+	}
+	return
+}
+
+// window returns the part of the sorted ids that falls into the range, in ascending order.
+func window(ids []int64, rng Range) []int64 {
+	i, _ := slices.BinarySearch(ids, rng.From)
+	if rng.Direction == Descending {
+		if rng.From == 0 {
+			return ids
+		}
+		return ids[:i]
+	}
+	return ids[i:]
+}
+
+func (r *Relation[E]) addOrdered(id int64) {
+	if n := len(r.order); n == 0 || r.order[n-1] < id {
+		r.order = append(r.order, id)
+		return
+	}
+	i, found := slices.BinarySearch(r.order, id)
+	if !found {
+		r.order = slices.Insert(r.order, i, id)
+	}
+}
+
+func (r *Relation[E]) removeOrdered(id int64) {
+	if i, found := slices.BinarySearch(r.order, id); found {
+		r.order = slices.Delete(r.order, i, i+1)
+	}
+}
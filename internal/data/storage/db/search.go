@@ -0,0 +1,38 @@
+package db
+
+import (
+	"seminarska/internal/data/storage/entities"
+	"seminarska/internal/data/storage/keys"
+)
+
+// Match is a record found by a full-text search, with its relevance.
+type Match[E entities.Entity] struct {
+	Value E
+	Score float64
+}
+
+// Search returns the confirmed records whose text field matches the query and
+// that satisfy the predicate, best match first.
+func (r *Relation[E]) Search(field string, query keys.TextQuery, predicate PredicateFunc[E]) ([]Match[E], error) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	found, err := r.texts.Search(field, query)
+	if err != nil {
+		return nil, err
+	}
+	var matches []Match[E]
+	for _, m := range found {
+		record, err := r.getRecord(m.Id)
+		if err != nil {
+			continue
+		}
+		e, err := record.Value()
+		if err != nil {
+			continue
+		}
+		if predicate(e) {
+			matches = append(matches, Match[E]{Value: e, Score: m.Score})
+		}
+	}
+	return matches, nil
+}
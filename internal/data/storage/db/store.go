@@ -0,0 +1,37 @@
+package db
+
+import (
+	"seminarska/internal/data/storage/entities"
+)
+
+// Store keeps the confirmed values of a relation. Changes that are not confirmed
+// yet stay in the relation's records, so a store only ever sees committed values.
+type Store[E entities.Entity] interface {
+	Get(id int64) (e E, found bool, err error)
+	Put(e E) error
+	Delete(id int64) error
+}
+
+// memoryStore keeps the values in a map, as they are.
+type memoryStore[E entities.Entity] struct {
+	values map[int64]E
+}
+
+func NewMemoryStore[E entities.Entity]() Store[E] {
+	return &memoryStore[E]{values: make(map[int64]E)}
+}
+
+func (m *memoryStore[E]) Get(id int64) (e E, found bool, err error) {
+	e, found = m.values[id]
+	return
+}
+
+func (m *memoryStore[E]) Put(e E) error {
+	m.values[e.Id()] = e
+	return nil
+}
+
+func (m *memoryStore[E]) Delete(id int64) error {
+	delete(m.values, id)
+	return nil
+}
@@ -15,7 +15,7 @@ func (r *Relation[E]) getTransform(id int64, transform TransformFunc[E]) (e E, e
 	if err != nil {
 		return
 	}
-	current, err := record.Value()
+	current, err := record.current()
 	if err != nil {
 		return
 	}
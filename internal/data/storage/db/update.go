@@ -15,56 +15,75 @@ func (r *Relation[E]) Update(id int64, transform TransformFunc[E]) (*UpdateRecei
 	if err != nil {
 		return nil, err
 	}
-	err = record.Write(updated)
+	change, err := record.write(updated)
 	if err != nil {
 		return nil, err
 	}
-	receipt := newUpdateReceipt(r, record)
+	r.track(id, record)
+	receipt := newUpdateReceipt(r, record, change)
 	return receipt, nil
 }
 
 type UpdateReceipt[E entities.Entity] struct {
-	r      *Relation[E]
-	record *MutableRecord[E]
+	r        *Relation[E]
+	record   *MutableRecord[E]
+	change   *value[E]
+	replaced E // the confirmed value before the update, once confirmed
 }
 
 func newUpdateReceipt[E entities.Entity](
-	r *Relation[E], record *MutableRecord[E],
+	r *Relation[E], record *MutableRecord[E], change *value[E],
 ) *UpdateReceipt[E] {
-	return &UpdateReceipt[E]{r, record}
+	return &UpdateReceipt[E]{r: r, record: record, change: change}
 }
 
 func (u *UpdateReceipt[E]) NewValue() E {
-	newValue, err := u.record.DirtyValue()
-	if err != nil {
-		panic(err)
-	}
-	return newValue
+	return u.change.e
 }
 
 func (u *UpdateReceipt[E]) Confirm() error {
 	u.r.mx.Lock()
 	defer u.r.mx.Unlock()
-	err := u.r.uniqueIndex.Replace(
-		u.record.confirmedValue.e,
-		u.record.dirtyValue.e,
-	)
+	old, updated := u.record.confirmedValue.e, u.change.e
+	defer u.r.settle(updated.Id(), u.record)
+	err := u.r.uniqueIndex.Replace(old, updated)
 	if err != nil {
-		if err := u.record.Rollback(); err != nil {
-			panic(err)
-		}
+		u.record.discard(u.change)
+		return err
+	}
+	// the other indexes only follow once the store has the new value
+	if err := u.r.store.Put(updated); err != nil {
+		_ = u.r.uniqueIndex.Replace(updated, old)
+		u.record.discard(u.change)
 		return err
 	}
-	if err := u.record.Commit(); err != nil {
+	if err := u.record.commit(u.change); err != nil {
 		panic(err)
 	}
+	u.replaced = old
+	u.r.indexes.Replace(old, updated)
+	u.r.texts.Replace(old, updated)
 	return nil
 }
 
-func (u *UpdateReceipt[E]) Cancel(err error) {
+// undo restores the value a confirmed update replaced.
+func (u *UpdateReceipt[E]) undo() {
 	u.r.mx.Lock()
 	defer u.r.mx.Unlock()
-	if err = u.record.Rollback(); err != nil {
-		panic(err)
+	old, updated := u.replaced, u.change.e
+	_ = u.r.uniqueIndex.Replace(updated, old)
+	u.r.indexes.Replace(updated, old)
+	u.r.texts.Replace(updated, old)
+	u.record.confirmedValue = value[E]{e: old}
+	if !u.record.IsDirty() {
+		u.record.dirtyValue = u.record.confirmedValue
 	}
+	_ = u.r.store.Put(old)
+}
+
+func (u *UpdateReceipt[E]) Cancel(error) {
+	u.r.mx.Lock()
+	defer u.r.mx.Unlock()
+	u.record.discard(u.change)
+	u.r.settle(u.change.e.Id(), u.record)
 }
@@ -0,0 +1,46 @@
+package entities
+
+import (
+	"errors"
+	"seminarska/proto/datalink"
+	"seminarska/proto/razpravljalnica"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrWrongType = errors.New("encoded entity has a different type")
+
+// Marshal encodes an entity for a storage engine.
+func Marshal(e Entity) ([]byte, error) {
+	if r, ok := e.(*Revision); ok {
+		return proto.Marshal(RevisionToProto(r))
+	}
+	return proto.Marshal(EntityToDatalink(e))
+}
+
+// Unmarshal decodes an entity encoded by Marshal.
+func Unmarshal[E Entity](data []byte) (e E, err error) {
+	var entity Entity
+	if _, ok := any(e).(*Revision); ok {
+		p := &razpravljalnica.Revision{}
+		if err = proto.Unmarshal(data, p); err != nil {
+			return
+		}
+		revision := RevisionFromProto(p)
+		revision.SetVersion(1) // revisions are never updated
+		entity = revision
+	} else {
+		dl := &datalink.Message{}
+		if err = proto.Unmarshal(data, dl); err != nil {
+			return
+		}
+		if entity, err = DatalinkToEntity(dl); err != nil {
+			return
+		}
+	}
+	e, ok := entity.(E)
+	if !ok {
+		err = ErrWrongType
+	}
+	return
+}
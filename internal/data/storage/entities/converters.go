@@ -4,6 +4,7 @@ import (
 	"errors"
 	"seminarska/proto/datalink"
 	"seminarska/proto/razpravljalnica"
+	"time"
 
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -11,20 +12,34 @@ import (
 func DatalinkToEntity(dl *datalink.Message) (entity Entity, err error) {
 	switch p := dl.Payload.(type) {
 	case *datalink.Message_User:
-		entity = NewUser(p.User.Name)
-		entity.SetId(p.User.Id)
+		user := NewUser(p.User.Name)
+		user.SetId(p.User.Id)
+		user.SetVersion(p.User.Version)
+		entity = user
 	case *datalink.Message_Message:
-		entity = NewMessage(
+		message := NewMessage(
 			p.Message.TopicId, p.Message.UserId,
 			p.Message.Text, p.Message.CreatedAt.AsTime(),
 		)
-		entity.SetId(p.Message.Id)
+		message.SetId(p.Message.Id)
+		message.SetVersion(p.Message.Version)
+		message.Likes = p.Message.Likes
+		if p.Message.EditedAt != nil {
+			message.EditedAt = p.Message.EditedAt.AsTime()
+		}
+		entity = message
 	case *datalink.Message_Like:
-		entity = NewLike(p.Like.UserId, p.Like.MessageId)
-		entity.SetId(p.Like.Id)
+		like := NewLike(p.Like.UserId, p.Like.MessageId)
+		like.SetId(p.Like.Id)
+		like.SetVersion(p.Like.Version)
+		entity = like
 	case *datalink.Message_Topic:
-		entity = NewTopic(p.Topic.Name)
-		entity.SetId(p.Topic.Id)
+		topic := NewTopic(p.Topic.Name)
+		topic.SetId(p.Topic.Id)
+		topic.SetVersion(p.Topic.Version)
+		topic.Retention = RetentionFromProto(p.Topic.Retention)
+		topic.UserId = p.Topic.UserId
+		entity = topic
 	default:
 		return nil, errors.New("invalid payload")
 	}
@@ -36,35 +51,85 @@ func EntityToDatalink(entity Entity) (dl *datalink.Message) {
 	case *User:
 		return &datalink.Message{
 			Payload: &datalink.Message_User{User: &razpravljalnica.User{
-				Id:   e.id,
-				Name: e.Name,
+				Id:      e.id,
+				Name:    e.Name,
+				Version: e.version,
 			}},
 		}
 	case *Message:
+		msg := &razpravljalnica.Message{
+			Id:        e.id,
+			TopicId:   e.TopicId,
+			UserId:    e.UserId,
+			Text:      e.Text,
+			CreatedAt: timestamppb.New(e.CreatedAt),
+			Likes:     e.Likes,
+			Version:   e.version,
+		}
+		if !e.EditedAt.IsZero() {
+			msg.EditedAt = timestamppb.New(e.EditedAt)
+		}
 		return &datalink.Message{
-			Payload: &datalink.Message_Message{Message: &razpravljalnica.Message{
-				Id:        e.id,
-				TopicId:   e.TopicId,
-				UserId:    e.UserId,
-				Text:      e.Text,
-				CreatedAt: timestamppb.New(e.CreatedAt),
-			}},
+			Payload: &datalink.Message_Message{Message: msg},
 		}
 	case *Topic:
 		return &datalink.Message{
 			Payload: &datalink.Message_Topic{Topic: &razpravljalnica.Topic{
-				Id:   e.id,
-				Name: e.Name,
+				Id:        e.id,
+				Name:      e.Name,
+				Version:   e.version,
+				Retention: RetentionToProto(e.Retention),
+				UserId:    e.UserId,
 			}},
 		}
 	case *Like:
 		return &datalink.Message{
 			Payload: &datalink.Message_Like{Like: &razpravljalnica.Like{
+				Id:        e.id,
 				MessageId: e.MessageId,
 				UserId:    e.UserId,
+				Version:   e.version,
 			}},
 		}
 	default:
 		panic("illegal state")
 	}
 }
+
+// RetentionToProto returns nil for a retention without limits.
+func RetentionToProto(r Retention) *razpravljalnica.Retention {
+	if !r.Enabled() {
+		return nil
+	}
+	return &razpravljalnica.Retention{
+		MaxAgeSeconds: int64(r.MaxAge / time.Second),
+		MaxMessages:   r.MaxMessages,
+	}
+}
+
+func RetentionFromProto(p *razpravljalnica.Retention) Retention {
+	return Retention{
+		MaxAge:      time.Duration(p.GetMaxAgeSeconds()) * time.Second,
+		MaxMessages: p.GetMaxMessages(),
+	}
+}
+
+// Revisions are derived by the replication handler and never travel as operation
+// payloads, so they are converted on their own.
+
+func RevisionToProto(r *Revision) *razpravljalnica.Revision {
+	return &razpravljalnica.Revision{
+		Id:             r.id,
+		MessageId:      r.MessageId,
+		MessageVersion: r.MessageVersion,
+		Text:           r.Text,
+		EditorId:       r.EditorId,
+		EditedAt:       timestamppb.New(r.EditedAt),
+	}
+}
+
+func RevisionFromProto(p *razpravljalnica.Revision) *Revision {
+	r := NewRevision(p.MessageId, p.MessageVersion, p.Text, p.EditorId, p.EditedAt.AsTime())
+	r.SetId(p.Id)
+	return r
+}
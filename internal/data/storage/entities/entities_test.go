@@ -32,7 +32,7 @@ func TestDatalinkToEntity_UserRoundtrip(t *testing.T) {
 
 func TestDatalinkToEntity_MessageRoundtrip(t *testing.T) {
 	tm := time.Now().Truncate(time.Second)
-	protoMsg := &razpravljalnica.Message{Id: 11, TopicId: 2, UserId: 3, Text: "hi", CreatedAt: timestamppb.New(tm)}
+	protoMsg := &razpravljalnica.Message{Id: 11, TopicId: 2, UserId: 3, Text: "hi", CreatedAt: timestamppb.New(tm), Version: 4}
 	dl := &datalink.Message{Payload: &datalink.Message_Message{Message: protoMsg}}
 	e, err := DatalinkToEntity(dl)
 	if err != nil {
@@ -42,13 +42,13 @@ func TestDatalinkToEntity_MessageRoundtrip(t *testing.T) {
 	if !ok {
 		t.Fatalf("expected *Message, got %T", e)
 	}
-	if m.Id() != 11 || m.TopicId != 2 || m.UserId != 3 || m.Text != "hi" || !m.CreatedAt.Equal(tm) {
+	if m.Id() != 11 || m.TopicId != 2 || m.UserId != 3 || m.Text != "hi" || !m.CreatedAt.Equal(tm) || m.Version() != 4 {
 		t.Fatalf("unexpected message fields: %+v", m)
 	}
 
 	back := EntityToDatalink(m)
 	bm := back.GetMessage()
-	if bm == nil || bm.Id != 11 || bm.TopicId != 2 || bm.UserId != 3 || bm.Text != "hi" {
+	if bm == nil || bm.Id != 11 || bm.TopicId != 2 || bm.UserId != 3 || bm.Text != "hi" || bm.Version != 4 {
 		t.Fatalf("roundtrip failed: %v", back)
 	}
 }
@@ -80,3 +80,41 @@ func TestDatalinkToEntity_TopicAndLike(t *testing.T) {
 		t.Fatalf("like roundtrip failed: %v", got)
 	}
 }
+
+func TestMessageEditedAt(t *testing.T) {
+	msg := NewMessage(1, 2, "hi", time.Unix(1, 0))
+	if got := EntityToDatalink(msg).GetMessage(); got.EditedAt != nil {
+		t.Fatalf("expected no edited_at for an unedited message, got %v", got.EditedAt)
+	}
+	msg.EditedAt = time.Unix(5, 0)
+	e, err := DatalinkToEntity(EntityToDatalink(msg))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if back := e.(*Message); !back.EditedAt.Equal(msg.EditedAt) {
+		t.Fatalf("edited_at roundtrip failed: %v", back.EditedAt)
+	}
+
+	rev := NewRevision(3, 1, "old", 2, time.Unix(5, 0))
+	rev.SetId(7)
+	back := RevisionFromProto(RevisionToProto(rev))
+	if back.Id() != 7 || back.MessageId != 3 || back.MessageVersion != 1 || back.Text != "old" ||
+		back.EditorId != 2 || !back.EditedAt.Equal(rev.EditedAt) {
+		t.Fatalf("revision roundtrip failed: %+v", back)
+	}
+}
+
+func TestTopicRetention(t *testing.T) {
+	topic := NewTopic("go")
+	if got := EntityToDatalink(topic).GetTopic(); got.Retention != nil {
+		t.Fatalf("expected no retention for an unlimited topic, got %v", got.Retention)
+	}
+	topic.Retention = Retention{MaxAge: time.Hour, MaxMessages: 10}
+	e, err := DatalinkToEntity(EntityToDatalink(topic))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if back := e.(*Topic); back.Retention != topic.Retention {
+		t.Fatalf("retention roundtrip failed: %+v", back.Retention)
+	}
+}
@@ -5,8 +5,16 @@ type Entity interface {
 	Id() int64
 }
 
+// Versioned entities count their updates. The version is assigned when an
+// operation is applied, so every replica agrees on it.
+type Versioned interface {
+	SetVersion(version int64)
+	Version() int64
+}
+
 type BaseEntity struct {
-	id int64
+	id      int64
+	version int64
 }
 
 func (b *BaseEntity) SetId(id int64) {
@@ -16,3 +24,11 @@ func (b *BaseEntity) SetId(id int64) {
 func (b *BaseEntity) Id() int64 {
 	return b.id
 }
+
+func (b *BaseEntity) SetVersion(version int64) {
+	b.version = version
+}
+
+func (b *BaseEntity) Version() int64 {
+	return b.version
+}
@@ -2,8 +2,8 @@ package entities
 
 type Like struct {
 	BaseEntity
-	UserId    int64 `db:"unique"`
-	MessageId int64 `db:"unique"`
+	UserId    int64 `db:"unique=like_user_message,index,ref=users"`
+	MessageId int64 `db:"unique=like_user_message,index,ref=messages,cascade"`
 }
 
 func NewLike(userId int64, messageId int64) *Like {
@@ -6,10 +6,12 @@ import (
 
 type Message struct {
 	BaseEntity
-	TopicId   int64 `db:"unique"`
-	UserId    int64 `db:"unique"`
-	Text      string
-	CreatedAt time.Time `db:"unique"`
+	TopicId   int64     `db:"unique=message_post,index,ref=topics,cascade"`
+	UserId    int64     `db:"unique=message_post,index,ref=users"`
+	Text      string    `db:"text"`
+	CreatedAt time.Time `db:"unique=message_post"`
+	Likes     int32     // maintained by the replication handler as likes come and go
+	EditedAt  time.Time // time of the last update, zero if the message was never edited
 }
 
 func NewMessage(topicId int64, userId int64, text string, createdAt time.Time) *Message {
@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"time"
+)
+
+// Revision keeps the text a message update replaced, and who made that update when.
+type Revision struct {
+	BaseEntity
+	MessageId      int64 `db:"index,ref=messages,cascade"`
+	MessageVersion int64 // version of the message that held Text
+	Text           string
+	EditorId       int64     `db:"index,ref=users"`
+	EditedAt       time.Time // when the text was replaced
+}
+
+func NewRevision(messageId, messageVersion int64, text string, editorId int64, editedAt time.Time) *Revision {
+	return &Revision{
+		MessageId:      messageId,
+		MessageVersion: messageVersion,
+		Text:           text,
+		EditorId:       editorId,
+		EditedAt:       editedAt,
+	}
+}
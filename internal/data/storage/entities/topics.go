@@ -1,8 +1,24 @@
 package entities
 
+import (
+	"time"
+)
+
 type Topic struct {
 	BaseEntity
-	Name string `db:"unique"`
+	Name      string `db:"unique=topic_name"`
+	Retention Retention
+	UserId    int64 // owner, who may delete the topic; 0 for topics from before topics had owners
+}
+
+// Retention limits how long messages stay in a topic; zero limits are unset.
+type Retention struct {
+	MaxAge      time.Duration // messages older than this expire
+	MaxMessages int64         // the oldest messages beyond this count expire
+}
+
+func (r Retention) Enabled() bool {
+	return r.MaxAge > 0 || r.MaxMessages > 0
 }
 
 func NewTopic(name string) *Topic {
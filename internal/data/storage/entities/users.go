@@ -2,7 +2,7 @@ package entities
 
 type User struct {
 	BaseEntity
-	Name string `db:"unique"`
+	Name string `db:"unique=user_name,index"`
 }
 
 func NewUser(name string) *User {
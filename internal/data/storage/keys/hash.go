@@ -7,7 +7,49 @@ import (
 	"reflect"
 )
 
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// key holds the values of an entity's constrained fields in declaration order.
+// Each value is normalized to a string, int64 or uint64, so keys compare exactly.
+type key []any
+
+func (k key) equal(other key) bool {
+	if len(k) != len(other) {
+		return false
+	}
+	for i := range k {
+		if k[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (k key) hash() uint64 {
+	h := fnv.New64a()
+	writeUint := func(x uint64) {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], x)
+		_, _ = h.Write(buf[:])
+	}
+	for _, v := range k {
+		switch v := v.(type) {
+		case string:
+			_, _ = h.Write([]byte(v))
+		case uint64:
+			writeUint(v)
+		case int64:
+			writeUint(uint64(v))
+		}
+	}
+	return h.Sum64()
+}
+
 func structHash(r any, fields []string) uint64 {
+	return structKey(r, fields).hash()
+}
+
+func structKey(r any, fields []string) key {
 	indexable := make(map[string]bool)
 	for _, f := range fields {
 		indexable[f] = true
@@ -24,51 +66,33 @@ func structHash(r any, fields []string) uint64 {
 		panic("not a struct")
 	}
 
-	h := fnv.New64a()
-	stringerType := reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
-
-	writeBytes := func(b []byte) {
-		_, _ = h.Write(b)
-	}
-
-	writeUint := func(x uint64) {
-		var buf [8]byte
-		binary.LittleEndian.PutUint64(buf[:], x)
-		writeBytes(buf[:])
-	}
-
+	k := make(key, 0, len(fields))
 	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		if !indexable[field.Name] {
-			continue
-		}
-
-		fv := v.Field(i)
-		ft := fv.Type()
-
-		if ft.Implements(stringerType) {
-			writeBytes([]byte(fv.Interface().(fmt.Stringer).String()))
-			continue
-		}
-		if fv.CanAddr() && reflect.PointerTo(ft).Implements(stringerType) {
-			writeBytes([]byte(fv.Addr().Interface().(fmt.Stringer).String()))
+		if !indexable[t.Field(i).Name] {
 			continue
 		}
-
-		switch fv.Kind() {
-		case reflect.String:
-			writeBytes([]byte(fv.String()))
-
-		case reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
-			writeUint(fv.Uint())
-
-		case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
-			writeUint(uint64(fv.Int()))
-
-		default:
-			panic("unsupported pk field type: " + ft.String())
-		}
+		k = append(k, normalize(v.Field(i)))
 	}
+	return k
+}
 
-	return h.Sum64()
+// normalize converts a field value to a comparable string, int64 or uint64.
+func normalize(fv reflect.Value) any {
+	ft := fv.Type()
+	if ft.Implements(stringerType) {
+		return fv.Interface().(fmt.Stringer).String()
+	}
+	if fv.CanAddr() && reflect.PointerTo(ft).Implements(stringerType) {
+		return fv.Addr().Interface().(fmt.Stringer).String()
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String()
+	case reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
+		return fv.Uint()
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
+		return fv.Int()
+	default:
+		panic("unsupported key field type: " + ft.String())
+	}
 }
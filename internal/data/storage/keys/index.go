@@ -10,57 +10,127 @@ var (
 	ErrConstraint  = errors.New("unique constraint violation")
 )
 
+// DefaultConstraint names the constraint formed by fields tagged with a plain "unique".
+const DefaultConstraint = "unique"
+
+// Constraint requires the combination of its fields to be unique within a relation.
+type Constraint struct {
+	Name   string
+	Fields []string
+}
+
+// ConstraintError reports which constraint an entity violated.
+type ConstraintError struct {
+	Constraint string
+}
+
+func (e *ConstraintError) Error() string {
+	return ErrConstraint.Error() + ": " + e.Constraint
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return ErrConstraint
+}
+
+// constraintSet buckets keys by hash and compares the stored keys on a hash
+// match, so colliding hashes never produce a false violation.
+type constraintSet struct {
+	Constraint
+	set map[uint64][]key
+}
+
+func (c *constraintSet) contains(k key, h uint64) bool {
+	for _, other := range c.set[h] {
+		if other.equal(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *constraintSet) remove(k key) {
+	h := k.hash()
+	bucket := c.set[h]
+	for i, other := range bucket {
+		if other.equal(k) {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(c.set, h)
+	} else {
+		c.set[h] = bucket
+	}
+}
+
 type Index struct {
-	set           map[uint64]struct{}
-	ids           map[int64]struct{}
-	indexedFields []string
+	constraints []*constraintSet
+	ids         map[int64]struct{}
 }
 
+// NewIndex creates an index with a single constraint over all given fields.
 func NewIndex(fields ...string) *Index {
-	return &Index{
-		set:           make(map[uint64]struct{}),
-		ids:           make(map[int64]struct{}),
-		indexedFields: fields,
+	if len(fields) == 0 {
+		return NewConstraintIndex()
 	}
+	return NewConstraintIndex(Constraint{Name: DefaultConstraint, Fields: fields})
 }
 
-func (i Index) Add(e entities.Entity) error {
+// NewConstraintIndex creates an index enforcing each constraint independently.
+func NewConstraintIndex(constraints ...Constraint) *Index {
+	i := &Index{ids: make(map[int64]struct{})}
+	for _, c := range constraints {
+		i.constraints = append(i.constraints, &constraintSet{
+			Constraint: c,
+			set:        make(map[uint64][]key),
+		})
+	}
+	return i
+}
+
+func (i *Index) Add(e entities.Entity) error {
 	if _, used := i.ids[e.Id()]; used {
 		return ErrDuplicateId
 	}
-	if len(i.indexedFields) == 0 {
-		return nil
+	keys := make([]key, len(i.constraints))
+	hashes := make([]uint64, len(i.constraints))
+	for j, c := range i.constraints {
+		keys[j] = structKey(e, c.Fields)
+		hashes[j] = keys[j].hash()
+		if c.contains(keys[j], hashes[j]) {
+			return &ConstraintError{Constraint: c.Name}
+		}
 	}
-	key := structHash(e, i.indexedFields)
-	if _, used := i.set[key]; used {
-		return ErrConstraint
+	for j, c := range i.constraints {
+		c.set[hashes[j]] = append(c.set[hashes[j]], keys[j])
 	}
-	i.set[key] = struct{}{}
 	i.ids[e.Id()] = struct{}{}
 	return nil
 }
 
-func (i Index) Remove(e entities.Entity) {
+func (i *Index) Remove(e entities.Entity) {
 	delete(i.ids, e.Id())
-	if len(i.indexedFields) > 0 {
-		delete(i.set, structHash(e, i.indexedFields))
+	for _, c := range i.constraints {
+		c.remove(structKey(e, c.Fields))
 	}
 }
 
-func (i Index) Replace(old, new entities.Entity) error {
+func (i *Index) Replace(old, new entities.Entity) error {
 	if old.Id() != new.Id() {
 		return errors.New("cannot replace entities with different ids")
 	}
-	current := structHash(old, i.indexedFields)
 	i.Remove(old)
 	err := i.Add(new)
 	if err != nil {
-		i.set[current] = struct{}{}
+		_ = i.Add(old)
 	}
 	return err
 }
 
-func (i Index) Reset() {
-	i.set = make(map[uint64]struct{})
+func (i *Index) Reset() {
+	for _, c := range i.constraints {
+		c.set = make(map[uint64][]key)
+	}
 	i.ids = make(map[int64]struct{})
 }
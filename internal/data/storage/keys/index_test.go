@@ -1,6 +1,7 @@
 package keys
 
 import (
+	"errors"
 	"testing"
 
 	"seminarska/internal/data/storage/entities"
@@ -57,3 +58,110 @@ func TestIndex_RemoveAndReplace(t *testing.T) {
 		t.Fatalf("replace should succeed: %v", err)
 	}
 }
+
+type constrainedEntity struct {
+	entities.BaseEntity
+	Name   string
+	Owner  int64
+	Target int64
+}
+
+func TestIndex_NamedConstraints(t *testing.T) {
+	i := NewConstraintIndex(
+		Constraint{Name: "name", Fields: []string{"Name"}},
+		Constraint{Name: "pair", Fields: []string{"Owner", "Target"}},
+	)
+	newEntity := func(id int64, name string, owner, target int64) *constrainedEntity {
+		e := &constrainedEntity{Name: name, Owner: owner, Target: target}
+		e.SetId(id)
+		return e
+	}
+	if err := i.Add(newEntity(1, "a", 1, 1)); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	// constraints are independent: a different name with a different pair is fine
+	if err := i.Add(newEntity(2, "b", 1, 2)); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	var ce *ConstraintError
+	err := i.Add(newEntity(3, "a", 2, 2))
+	if !errors.As(err, &ce) || ce.Constraint != "name" || !errors.Is(err, ErrConstraint) {
+		t.Fatalf("expected name constraint error, got %v", err)
+	}
+	err = i.Add(newEntity(3, "c", 1, 2))
+	if !errors.As(err, &ce) || ce.Constraint != "pair" {
+		t.Fatalf("expected pair constraint error, got %v", err)
+	}
+
+	// a rejected entity must not reserve any of its keys
+	if err = i.Add(newEntity(3, "c", 3, 3)); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestIndex_ReplaceRollback(t *testing.T) {
+	i := NewIndex("Name")
+	e1 := &testEntity{Name: "a"}
+	e1.SetId(1)
+	e2 := &testEntity{Name: "b"}
+	e2.SetId(2)
+	_ = i.Add(e1)
+	_ = i.Add(e2)
+
+	updated := &testEntity{Name: "a"}
+	updated.SetId(2)
+	if err := i.Replace(e2, updated); !errors.Is(err, ErrConstraint) {
+		t.Fatalf("expected constraint error, got %v", err)
+	}
+	// the original entity keeps both its id and its key
+	dup := &testEntity{Name: "b"}
+	dup.SetId(3)
+	if err := i.Add(dup); !errors.Is(err, ErrConstraint) {
+		t.Fatalf("expected constraint error, got %v", err)
+	}
+	if err := i.Add(e2); !errors.Is(err, ErrDuplicateId) {
+		t.Fatalf("expected duplicate id error, got %v", err)
+	}
+}
+
+func TestIndex_HashCollision(t *testing.T) {
+	i := NewIndex("Name")
+	e1 := &testEntity{Name: "a"}
+	e1.SetId(1)
+	e2 := &testEntity{Name: "b"}
+	e2.SetId(2)
+	if err := i.Add(e1); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	// file e1's key under e2's hash to simulate a collision
+	c := i.constraints[0]
+	h := structKey(e2, c.Fields).hash()
+	c.set[h] = append(c.set[h], structKey(e1, c.Fields))
+
+	if err := i.Add(e2); err != nil {
+		t.Fatalf("colliding hash with a different key should be accepted: %v", err)
+	}
+	i.Remove(e2)
+	if len(c.set[h]) != 1 || !c.set[h][0].equal(structKey(e1, c.Fields)) {
+		t.Fatalf("remove dropped the wrong key: %v", c.set[h])
+	}
+}
+
+func TestIndex_CompositeKeyBoundaries(t *testing.T) {
+	type pair struct {
+		entities.BaseEntity
+		A, B string
+	}
+	i := NewIndex("A", "B")
+	p1 := &pair{A: "ab", B: "c"}
+	p1.SetId(1)
+	p2 := &pair{A: "a", B: "bc"}
+	p2.SetId(2)
+	if err := i.Add(p1); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if err := i.Add(p2); err != nil {
+		t.Fatalf("keys with equal concatenation should not conflict: %v", err)
+	}
+}
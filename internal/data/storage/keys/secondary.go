@@ -0,0 +1,87 @@
+package keys
+
+import (
+	"errors"
+	"reflect"
+	"seminarska/internal/data/storage/entities"
+	"slices"
+)
+
+var ErrNoIndex = errors.New("field not indexed")
+
+// SecondaryIndexes maps the values of indexed fields to the ids of entities holding them.
+type SecondaryIndexes struct {
+	byField map[string]map[any]map[int64]struct{}
+}
+
+func NewSecondaryIndexes(fields ...string) *SecondaryIndexes {
+	s := &SecondaryIndexes{byField: make(map[string]map[any]map[int64]struct{})}
+	for _, f := range fields {
+		s.byField[f] = make(map[any]map[int64]struct{})
+	}
+	return s
+}
+
+func (s *SecondaryIndexes) Add(e entities.Entity) {
+	for field, index := range s.byField {
+		key := fieldKey(e, field)
+		ids, ok := index[key]
+		if !ok {
+			ids = make(map[int64]struct{})
+			index[key] = ids
+		}
+		ids[e.Id()] = struct{}{}
+	}
+}
+
+func (s *SecondaryIndexes) Remove(e entities.Entity) {
+	for field, index := range s.byField {
+		key := fieldKey(e, field)
+		delete(index[key], e.Id())
+		if len(index[key]) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+func (s *SecondaryIndexes) Replace(old, new entities.Entity) {
+	s.Remove(old)
+	s.Add(new)
+}
+
+// Lookup returns the ids of entities whose field equals value, in ascending order.
+func (s *SecondaryIndexes) Lookup(field string, value any) ([]int64, error) {
+	index, ok := s.byField[field]
+	if !ok {
+		return nil, ErrNoIndex
+	}
+	matches := index[normalize(reflect.ValueOf(value))]
+	ids := make([]int64, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (s *SecondaryIndexes) Count(field string, value any) (int, error) {
+	index, ok := s.byField[field]
+	if !ok {
+		return 0, ErrNoIndex
+	}
+	return len(index[normalize(reflect.ValueOf(value))]), nil
+}
+
+func (s *SecondaryIndexes) Reset() {
+	for field := range s.byField {
+		s.byField[field] = make(map[any]map[int64]struct{})
+	}
+}
+
+func fieldKey(e entities.Entity, field string) any {
+	v := reflect.ValueOf(e)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	return normalize(v.FieldByName(field))
+}
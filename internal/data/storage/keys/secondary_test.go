@@ -0,0 +1,54 @@
+package keys
+
+import (
+	"testing"
+
+	"seminarska/internal/data/storage/entities"
+)
+
+type indexedEntity struct {
+	entities.BaseEntity
+	Group int64
+}
+
+func TestSecondaryIndexes_Lookup(t *testing.T) {
+	s := NewSecondaryIndexes("Group")
+	for i, g := range []int64{1, 2, 1, 1} {
+		e := &indexedEntity{Group: g}
+		e.SetId(int64(10 - i))
+		s.Add(e)
+	}
+	ids, err := s.Lookup("Group", 1)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if len(ids) != 3 || ids[0] != 7 || ids[2] != 10 {
+		t.Fatalf("expected sorted ids [7 8 10], got %v", ids)
+	}
+	if n, _ := s.Count("Group", int64(2)); n != 1 {
+		t.Fatalf("expected 1 got %d", n)
+	}
+	if _, err := s.Lookup("Missing", 1); err != ErrNoIndex {
+		t.Fatalf("expected ErrNoIndex got %v", err)
+	}
+}
+
+func TestSecondaryIndexes_RemoveAndReplace(t *testing.T) {
+	s := NewSecondaryIndexes("Group")
+	e := &indexedEntity{Group: 1}
+	e.SetId(1)
+	s.Add(e)
+	moved := &indexedEntity{Group: 2}
+	moved.SetId(1)
+	s.Replace(e, moved)
+	if n, _ := s.Count("Group", 1); n != 0 {
+		t.Fatalf("expected old value removed, got %d", n)
+	}
+	if n, _ := s.Count("Group", 2); n != 1 {
+		t.Fatalf("expected new value indexed, got %d", n)
+	}
+	s.Remove(moved)
+	if n, _ := s.Count("Group", 2); n != 0 {
+		t.Fatalf("expected removal, got %d", n)
+	}
+}
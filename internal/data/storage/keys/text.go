@@ -0,0 +1,241 @@
+package keys
+
+import (
+	"cmp"
+	"math"
+	"reflect"
+	"seminarska/internal/data/storage/entities"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// TextIndexes keeps an inverted index of the words in text fields. Every word
+// maps to the entities containing it and its positions in them, so searches
+// can match single words as well as phrases.
+type TextIndexes struct {
+	byField map[string]*textIndex
+}
+
+type textIndex struct {
+	postings map[string]map[int64][]int // word -> entity id -> positions
+	lengths  map[int64]int              // entity id -> number of words
+	words    int                        // total number of words, for the average length
+}
+
+func NewTextIndexes(fields ...string) *TextIndexes {
+	t := &TextIndexes{byField: make(map[string]*textIndex)}
+	for _, f := range fields {
+		t.byField[f] = newTextIndex()
+	}
+	return t
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{
+		postings: make(map[string]map[int64][]int),
+		lengths:  make(map[int64]int),
+	}
+}
+
+func (t *TextIndexes) Add(e entities.Entity) {
+	for field, index := range t.byField {
+		index.add(e.Id(), Tokenize(fieldText(e, field)))
+	}
+}
+
+func (t *TextIndexes) Remove(e entities.Entity) {
+	for field, index := range t.byField {
+		index.remove(e.Id(), Tokenize(fieldText(e, field)))
+	}
+}
+
+func (t *TextIndexes) Replace(old, new entities.Entity) {
+	t.Remove(old)
+	t.Add(new)
+}
+
+func (t *TextIndexes) Reset() {
+	for field := range t.byField {
+		t.byField[field] = newTextIndex()
+	}
+}
+
+func (x *textIndex) add(id int64, words []string) {
+	for pos, word := range words {
+		ids, ok := x.postings[word]
+		if !ok {
+			ids = make(map[int64][]int)
+			x.postings[word] = ids
+		}
+		ids[id] = append(ids[id], pos)
+	}
+	x.lengths[id] = len(words)
+	x.words += len(words)
+}
+
+func (x *textIndex) remove(id int64, words []string) {
+	for _, word := range words {
+		delete(x.postings[word], id)
+		if len(x.postings[word]) == 0 {
+			delete(x.postings, word)
+		}
+	}
+	x.words -= x.lengths[id]
+	delete(x.lengths, id)
+}
+
+// Tokenize splits text into lower case words of letters and digits.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// TextQuery selects the entities that contain every term and every phrase.
+type TextQuery struct {
+	Terms   []string
+	Phrases [][]string // words that must follow each other
+}
+
+// ParseTextQuery reads a query in which quoted parts are phrases and the rest are terms.
+func ParseTextQuery(s string) TextQuery {
+	var q TextQuery
+	for i, part := range strings.Split(s, `"`) {
+		words := Tokenize(part)
+		switch {
+		case i%2 == 0:
+			q.Terms = append(q.Terms, words...)
+		case len(words) == 1:
+			q.Terms = append(q.Terms, words[0])
+		case len(words) > 1:
+			q.Phrases = append(q.Phrases, words)
+		}
+	}
+	return q
+}
+
+func (q TextQuery) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// TextMatch is an entity found by a search with its relevance; higher is better.
+type TextMatch struct {
+	Id    int64
+	Score float64
+}
+
+// BM25 parameters: how fast repeated words stop adding to the score, and how much
+// long texts are penalized for containing more words.
+const (
+	saturation        = 1.2
+	lengthNormalizing = 0.75
+)
+
+// Search returns the entities matching the query, best first; equal scores put newer ids first.
+func (t *TextIndexes) Search(field string, q TextQuery) ([]TextMatch, error) {
+	index, ok := t.byField[field]
+	if !ok {
+		return nil, ErrNoIndex
+	}
+	if q.Empty() {
+		return nil, nil
+	}
+	candidates := index.containingPhrases(index.containingAll(q), q.Phrases)
+	scores := make(map[int64]float64, len(candidates))
+	for _, term := range q.Terms {
+		index.score(scores, candidates, 1, len(index.postings[term]), func(id int64) int {
+			return len(index.postings[term][id])
+		})
+	}
+	for _, phrase := range q.Phrases {
+		// a phrase counts as much as its words would on their own; every
+		// entity containing it is a candidate, so they give its frequency
+		index.score(scores, candidates, float64(len(phrase)), len(candidates), func(id int64) int {
+			return index.phraseCount(id, phrase)
+		})
+	}
+	matches := make([]TextMatch, len(candidates))
+	for i, id := range candidates {
+		matches[i] = TextMatch{Id: id, Score: scores[id]}
+	}
+	slices.SortFunc(matches, func(a, b TextMatch) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Id, a.Id)
+	})
+	return matches, nil
+}
+
+// containingAll returns the ids of entities containing every word of the query.
+func (x *textIndex) containingAll(q TextQuery) []int64 {
+	words := slices.Clone(q.Terms)
+	for _, phrase := range q.Phrases {
+		words = append(words, phrase...)
+	}
+	// walk the rarest word, every other word can only narrow it down
+	slices.SortFunc(words, func(a, b string) int {
+		return cmp.Compare(len(x.postings[a]), len(x.postings[b]))
+	})
+	var ids []int64
+	for id := range x.postings[words[0]] {
+		if !slices.ContainsFunc(words[1:], func(word string) bool {
+			_, ok := x.postings[word][id]
+			return !ok
+		}) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// containingPhrases keeps the candidates that contain every phrase.
+func (x *textIndex) containingPhrases(candidates []int64, phrases [][]string) []int64 {
+	return slices.DeleteFunc(candidates, func(id int64) bool {
+		return slices.ContainsFunc(phrases, func(phrase []string) bool {
+			return x.phraseCount(id, phrase) == 0
+		})
+	})
+}
+
+// score adds the BM25 score of one query part, found in frequency entities, to the
+// candidates, which all contain it.
+func (x *textIndex) score(
+	scores map[int64]float64, candidates []int64, weight float64, frequency int, count func(int64) int,
+) {
+	n, df := float64(len(x.lengths)), float64(frequency)
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	avg := float64(x.words) / n
+	for _, id := range candidates {
+		tf := float64(count(id))
+		norm := 1 - lengthNormalizing + lengthNormalizing*float64(x.lengths[id])/avg
+		scores[id] += weight * idf * tf * (saturation + 1) / (tf + saturation*norm)
+	}
+}
+
+// phraseCount returns how many times the words follow each other in the entity.
+func (x *textIndex) phraseCount(id int64, phrase []string) (n int) {
+	for _, start := range x.postings[phrase[0]][id] {
+		matched := true
+		for i, word := range phrase[1:] {
+			if _, found := slices.BinarySearch(x.postings[word][id], start+i+1); !found {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			n++
+		}
+	}
+	return
+}
+
+func fieldText(e entities.Entity, field string) string {
+	v := reflect.ValueOf(e)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	return v.FieldByName(field).String()
+}
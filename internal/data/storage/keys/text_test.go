@@ -0,0 +1,84 @@
+package keys
+
+import (
+	"slices"
+	"testing"
+
+	"seminarska/internal/data/storage/entities"
+)
+
+type textEntity struct {
+	entities.BaseEntity
+	Body string
+}
+
+func newTextEntity(id int64, body string) *textEntity {
+	e := &textEntity{Body: body}
+	e.SetId(id)
+	return e
+}
+
+func searchIds(t *testing.T, x *TextIndexes, query string) []int64 {
+	t.Helper()
+	matches, err := x.Search("Body", ParseTextQuery(query))
+	if err != nil {
+		t.Fatalf("search %q: %v", query, err)
+	}
+	ids := make([]int64, len(matches))
+	for i, m := range matches {
+		ids[i] = m.Id
+	}
+	return ids
+}
+
+func TestParseTextQuery(t *testing.T) {
+	q := ParseTextQuery(`Chain "tail node" "Head" replication,`)
+	if !slices.Equal(q.Terms, []string{"chain", "head", "replication"}) {
+		t.Fatalf("unexpected terms %v", q.Terms)
+	}
+	if len(q.Phrases) != 1 || !slices.Equal(q.Phrases[0], []string{"tail", "node"}) {
+		t.Fatalf("unexpected phrases %v", q.Phrases)
+	}
+	if !ParseTextQuery(` "" ... `).Empty() {
+		t.Fatalf("expected a query without words to be empty")
+	}
+}
+
+func TestTextIndexes_Search(t *testing.T) {
+	x := NewTextIndexes("Body")
+	for i, body := range []string{
+		"the tail node answers reads",
+		"a node at the tail, another at the head",
+		"Tail node, tail node!",
+		"nothing to see here",
+	} {
+		x.Add(newTextEntity(int64(i+1), body))
+	}
+
+	// every term must match, in any order
+	if ids := searchIds(t, x, "node tail"); !slices.Equal(ids, []int64{3, 1, 2}) {
+		t.Fatalf("expected the repeated words to rank first, got %v", ids)
+	}
+	// a phrase only matches words next to each other
+	if ids := searchIds(t, x, `"tail node"`); !slices.Equal(ids, []int64{3, 1}) {
+		t.Fatalf("expected phrase matches [3 1], got %v", ids)
+	}
+	if ids := searchIds(t, x, `"node tail"`); !slices.Equal(ids, []int64{3}) {
+		t.Fatalf("expected the reversed phrase only in 3, got %v", ids)
+	}
+	if ids := searchIds(t, x, "missing tail"); len(ids) != 0 {
+		t.Fatalf("expected no match with an unknown word, got %v", ids)
+	}
+
+	x.Replace(newTextEntity(3, "Tail node, tail node!"), newTextEntity(3, "gone"))
+	x.Remove(newTextEntity(1, "the tail node answers reads"))
+	if ids := searchIds(t, x, "tail"); !slices.Equal(ids, []int64{2}) {
+		t.Fatalf("expected only 2 after replace and remove, got %v", ids)
+	}
+	if ids := searchIds(t, x, "gone"); !slices.Equal(ids, []int64{3}) {
+		t.Fatalf("expected the replaced text to be indexed, got %v", ids)
+	}
+	if _, err := x.Search("Missing", ParseTextQuery("tail")); err != ErrNoIndex {
+		t.Fatalf("expected ErrNoIndex, got %v", err)
+	}
+}
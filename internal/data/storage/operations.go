@@ -1,14 +1,21 @@
 package storage
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"seminarska/internal/data/storage/db"
 	"seminarska/internal/data/storage/entities"
+	"seminarska/internal/data/storage/keys"
+	"seminarska/internal/data/storage/replication"
 	"seminarska/proto/datalink"
+	"slices"
 	"time"
 )
 
+// ErrNotOwner is returned when a user changes a record that belongs to another user.
+var ErrNotOwner = errors.New("record belongs to another user")
+
 func (d *AppDatabase) GetUser(id int64) (*entities.User, error) {
 	return d.Users().Get(id)
 }
@@ -17,21 +24,90 @@ func (d *AppDatabase) GetMessage(id int64) (*entities.Message, error) {
 	return d.Messages().Get(id)
 }
 
-func (d *AppDatabase) GetMessages(fromId int64, topicId int64, limit int32) ([]*entities.Message, error) {
+func (d *AppDatabase) GetUserByName(name string) (*entities.User, error) {
+	users, err := d.Users().GetIndexed("Name", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, db.ErrNotFound
+	}
+	return users[0], nil
+}
 
-	return d.Messages().GetPredicate(func(message *entities.Message) bool {
-		return message.Id() >= fromId && message.TopicId == topicId
-	}, int(limit))
+// GetMessages returns a page of messages in a topic, ordered by id, and the cursor of the next page.
+// Pages start at fromId, or, when latest is set, end right before it (fromId 0 for the newest messages).
+func (d *AppDatabase) GetMessages(
+	topicId int64, fromId int64, limit int32, latest bool,
+) (messages []*entities.Message, next int64, err error) {
+	if limit < 0 {
+		return nil, 0, errors.New("limit must be non-negative")
+	}
+	rng := db.Range{From: fromId, Direction: db.Ascending}
+	if latest {
+		rng.Direction = db.Descending
+	}
+	if limit != db.NoLimit {
+		// fetch one extra message to find out whether there is a next page
+		rng.Limit = int(limit) + 1
+	}
+	messages, err = d.Messages().ScanIndexed("TopicId", topicId, rng)
+	if err != nil {
+		return nil, 0, err
+	}
+	if limit != db.NoLimit && len(messages) > int(limit) {
+		if latest {
+			next = messages[limit-1].Id()
+		} else {
+			next = messages[limit].Id()
+		}
+		messages = messages[:limit]
+	}
+	if latest {
+		slices.Reverse(messages)
+	}
+	return messages, next, nil
 }
 
-func (d *AppDatabase) GetLikes(messageId int64) (int, error) {
-	likes, err := d.Likes().GetPredicate(func(like *entities.Like) bool {
-		return like.MessageId == messageId
-	}, db.NoLimit)
+// MessageSearch selects the messages SearchMessages returns; unset filters match every message.
+type MessageSearch struct {
+	Query         string
+	TopicId       *int64
+	UserId        *int64
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Offset        int32
+	Limit         int32
+}
+
+// SearchMessages returns a page of the messages matching a search, best first, the
+// offset of the next page and the number of matches.
+func (d *AppDatabase) SearchMessages(
+	search MessageSearch,
+) (page []db.Match[*entities.Message], next int32, total int, err error) {
+	if search.Offset < 0 || search.Limit < 0 {
+		return nil, 0, 0, errors.New("offset and limit must be non-negative")
+	}
+	query := keys.ParseTextQuery(search.Query)
+	if query.Empty() {
+		return nil, 0, 0, errors.New("query has no words")
+	}
+	matches, err := d.Messages().Search("Text", query, func(msg *entities.Message) bool {
+		return (search.TopicId == nil || msg.TopicId == *search.TopicId) &&
+			(search.UserId == nil || msg.UserId == *search.UserId) &&
+			(search.CreatedAfter.IsZero() || !msg.CreatedAt.Before(search.CreatedAfter)) &&
+			(search.CreatedBefore.IsZero() || msg.CreatedAt.Before(search.CreatedBefore))
+	})
 	if err != nil {
-		return 0, err
+		return nil, 0, 0, err
+	}
+	from := min(int(search.Offset), len(matches))
+	to := len(matches)
+	if search.Limit != db.NoLimit && from+int(search.Limit) < to {
+		to = from + int(search.Limit)
+		next = int32(to)
 	}
-	return len(likes), nil
+	return matches[from:to], next, len(matches), nil
 }
 
 func (d *AppDatabase) GetTopics() ([]*entities.Topic, error) {
@@ -42,7 +118,10 @@ func (d *AppDatabase) GetTopics() ([]*entities.Topic, error) {
 
 func (d *AppDatabase) CreateUser(ctx context.Context, username string) (*entities.User, error) {
 	user := entities.NewUser(username)
-	requestId := d.chain.DispatchNewMessage(user, datalink.Operation_Create)
+	requestId, err := d.chain.DispatchNewMessage(ctx, user, datalink.Operation_Create)
+	if err != nil {
+		return nil, err
+	}
 	id, err := d.chain.AwaitConfirmation(ctx, requestId)
 	if err != nil {
 		return nil, err
@@ -50,9 +129,14 @@ func (d *AppDatabase) CreateUser(ctx context.Context, username string) (*entitie
 	return d.Users().Get(id)
 }
 
-func (d *AppDatabase) CreateTopic(ctx context.Context, name string) (*entities.Topic, error) {
-	user := entities.NewTopic(name)
-	requestId := d.chain.DispatchNewMessage(user, datalink.Operation_Create)
+// CreateTopic creates a topic owned by the user.
+func (d *AppDatabase) CreateTopic(ctx context.Context, userId int64, name string) (*entities.Topic, error) {
+	topic := entities.NewTopic(name)
+	topic.UserId = userId
+	requestId, err := d.chain.DispatchNewMessage(ctx, topic, datalink.Operation_Create)
+	if err != nil {
+		return nil, err
+	}
 	id, err := d.chain.AwaitConfirmation(ctx, requestId)
 	if err != nil {
 		return nil, err
@@ -60,16 +144,100 @@ func (d *AppDatabase) CreateTopic(ctx context.Context, name string) (*entities.T
 	return d.Topics().Get(id)
 }
 
+// SetTopicRetention replaces the retention of a topic owned by the user; with
+// expectedVersion set, only if the topic is still at that version.
+func (d *AppDatabase) SetTopicRetention(
+	ctx context.Context, userId, topicId int64, retention entities.Retention, expectedVersion *int64,
+) (*entities.Topic, error) {
+	topic, err := d.Topics().GetTransform(topicId, func(og *entities.Topic) (*entities.Topic, error) {
+		if og.UserId != userId {
+			return nil, ErrNotOwner
+		}
+		updated := entities.NewTopic(og.Name)
+		updated.SetId(og.Id())
+		updated.Retention = retention
+		updated.UserId = og.UserId
+		return updated, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	requestId, err := d.chain.Dispatch(ctx, replication.Operation{
+		Entity:          topic,
+		Op:              datalink.Operation_Update,
+		ExpectedVersion: expectedVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.chain.AwaitConfirmation(ctx, requestId); err != nil {
+		return nil, err
+	}
+	return d.Topics().Get(topicId)
+}
+
+// DeleteTopic deletes a topic owned by the user; its messages and their likes are
+// deleted by the same operation.
+func (d *AppDatabase) DeleteTopic(ctx context.Context, userId, topicId int64) error {
+	topic, err := d.Topics().GetTransform(topicId, func(og *entities.Topic) (*entities.Topic, error) {
+		if og.UserId != userId {
+			return nil, ErrNotOwner
+		}
+		topic := entities.NewTopic("")
+		topic.SetId(og.Id())
+		return topic, nil
+	})
+	if err != nil {
+		return err
+	}
+	requestId, err := d.chain.DispatchNewMessage(ctx, topic, datalink.Operation_Delete)
+	if err != nil {
+		return err
+	}
+	_, err = d.chain.AwaitConfirmation(ctx, requestId)
+	return err
+}
+
 func (d *AppDatabase) LikeMessage(ctx context.Context, userId, messageId int64) error {
-	like := entities.NewLike(messageId, userId)
-	requestId := d.chain.DispatchNewMessage(like, datalink.Operation_Create)
-	_, err := d.chain.AwaitConfirmation(ctx, requestId)
+	like := entities.NewLike(userId, messageId)
+	requestId, err := d.chain.DispatchNewMessage(ctx, like, datalink.Operation_Create)
+	if err != nil {
+		return err
+	}
+	_, err = d.chain.AwaitConfirmation(ctx, requestId)
+	return err
+}
+
+// UnlikeMessage removes the user's like from a message. Only the user's own like is
+// looked up, so nobody can remove the likes of others.
+func (d *AppDatabase) UnlikeMessage(ctx context.Context, userId, messageId int64) error {
+	likes, err := d.Likes().GetIndexed("MessageId", messageId)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(likes, func(like *entities.Like) bool {
+		return like.UserId == userId
+	})
+	if i < 0 {
+		return db.ErrNotFound
+	}
+	// the payload keeps the message id, so subscribers can tell which message lost a like
+	like := entities.NewLike(userId, messageId)
+	like.SetId(likes[i].Id())
+	requestId, err := d.chain.DispatchNewMessage(ctx, like, datalink.Operation_Delete)
+	if err != nil {
+		return err
+	}
+	_, err = d.chain.AwaitConfirmation(ctx, requestId)
 	return err
 }
 
 func (d *AppDatabase) PostMessage(ctx context.Context, userId, topicId int64, text string) (*entities.Message, error) {
 	msg := entities.NewMessage(topicId, userId, text, time.Now())
-	requestId := d.chain.DispatchNewMessage(msg, datalink.Operation_Create)
+	requestId, err := d.chain.DispatchNewMessage(ctx, msg, datalink.Operation_Create)
+	if err != nil {
+		return nil, err
+	}
 	id, err := d.chain.AwaitConfirmation(ctx, requestId)
 	if err != nil {
 		return nil, err
@@ -78,62 +246,140 @@ func (d *AppDatabase) PostMessage(ctx context.Context, userId, topicId int64, te
 	return msg, nil
 }
 
-func (d *AppDatabase) DeleteMessage(ctx context.Context, userId, messageId int64) error {
-	msg := entities.NewMessage(0, userId, "", time.Time{}) // dummy values
-	msg.SetId(messageId)
-	requestId := d.chain.DispatchNewMessage(msg, datalink.Operation_Delete)
-	_, err := d.chain.AwaitConfirmation(ctx, requestId)
+// DeleteMessage deletes a message written by the user; with expectedVersion set,
+// only if it is still at that version.
+func (d *AppDatabase) DeleteMessage(ctx context.Context, userId, messageId int64, expectedVersion *int64) error {
+	msg, err := d.Messages().GetTransform(messageId, func(og *entities.Message) (*entities.Message, error) {
+		if og.UserId != userId {
+			return nil, ErrNotOwner
+		}
+		msg := entities.NewMessage(0, userId, "", time.Time{}) // dummy values
+		msg.SetId(og.Id())
+		return msg, nil
+	})
+	if err != nil {
+		return err
+	}
+	requestId, err := d.chain.Dispatch(ctx, replication.Operation{
+		Entity:          msg,
+		Op:              datalink.Operation_Delete,
+		ExpectedVersion: expectedVersion,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.chain.AwaitConfirmation(ctx, requestId)
 	return err
 }
 
-func (d *AppDatabase) UpdateMessage(ctx context.Context, userId, messageId int64, newText string) (*entities.Message, error) {
+// GetMessageHistory returns a message in the topic and the texts it held before, oldest first.
+func (d *AppDatabase) GetMessageHistory(topicId, messageId int64) (*entities.Message, []*entities.Revision, error) {
+	msg, err := d.Messages().Get(messageId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if msg.TopicId != topicId {
+		return nil, nil, db.ErrNotFound
+	}
+	revisions, err := d.Revisions().GetIndexed("MessageId", messageId)
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.SortFunc(revisions, func(a, b *entities.Revision) int {
+		return cmp.Compare(a.MessageVersion, b.MessageVersion)
+	})
+	return msg, revisions, nil
+}
+
+// UpdateMessage replaces the text of a message, keeping the old text as a revision. With
+// expectedVersion set, the update is rejected if the message changed since the caller read that version.
+func (d *AppDatabase) UpdateMessage(
+	ctx context.Context, userId, messageId int64, newText string, expectedVersion *int64,
+) (*entities.Message, error) {
 	updated, err := d.Messages().GetTransform(messageId, func(og *entities.Message) (*entities.Message, error) {
 		if og.UserId != userId {
-			return nil, errors.New("user mismatch")
+			return nil, ErrNotOwner
 		}
 		msg := entities.NewMessage(og.TopicId, og.UserId, newText, og.CreatedAt)
 		msg.SetId(og.Id())
+		msg.EditedAt = time.Now()
 		return msg, nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	requestId := d.chain.DispatchNewMessage(updated, datalink.Operation_Update)
-	id, err := d.chain.AwaitConfirmation(ctx, requestId)
+	requestId, err := d.chain.Dispatch(ctx, replication.Operation{
+		Entity:          updated,
+		Op:              datalink.Operation_Update,
+		ExpectedVersion: expectedVersion,
+	})
 	if err != nil {
 		return nil, err
 	}
-	return d.Messages().Get(id)
+	if _, err = d.chain.AwaitConfirmation(ctx, requestId); err != nil {
+		return nil, err
+	}
+	return d.Messages().Get(messageId)
 }
 
 type MessageEvent struct {
 	Message   *entities.Message
 	Operation datalink.Operation
+	Like      bool // a like of Message was created or deleted
 }
 
 func (d *AppDatabase) SubscribeTopic(ctx context.Context, topics []int64) <-chan MessageEvent {
 	out := make(chan MessageEvent, 100)
 	go func() {
 		defer close(out)
-		for dl := range d.chain.Observe(ctx) {
-			e, err := entities.DatalinkToEntity(dl)
-			if err != nil {
-				continue
+		for observed := range d.chain.Observe(ctx) {
+			operations := []*datalink.Message{observed}
+			if batch := observed.GetBatch(); batch != nil {
+				operations = batch.GetOperations()
 			}
-			if msg, ok := e.(*entities.Message); ok &&
-				(dl.Op == datalink.Operation_Create ||
-					dl.Op == datalink.Operation_Update) {
-
-				for _, t := range topics {
-					if t == msg.TopicId {
-						out <- MessageEvent{
-							Message:   msg,
-							Operation: dl.Op,
-						}
-					}
+			for _, dl := range operations {
+				e, err := entities.DatalinkToEntity(dl)
+				if err != nil {
+					continue
+				}
+				event, ok := d.messageEvent(e, dl.Op, int64(observed.GetMessageIndex()))
+				if !ok {
+					continue
+				}
+				if slices.Contains(topics, event.Message.TopicId) {
+					out <- event
 				}
 			}
 		}
 	}()
 	return out
 }
+
+// messageEvent describes an observed operation as an event on a message. Likes are
+// reported on the message they belong to, which carries the updated counter.
+func (d *AppDatabase) messageEvent(e entities.Entity, op datalink.Operation, index int64) (MessageEvent, bool) {
+	switch e := e.(type) {
+	case *entities.Message:
+		if op != datalink.Operation_Create && op != datalink.Operation_Update {
+			return MessageEvent{}, false
+		}
+		if op == datalink.Operation_Create {
+			e.SetId(index)
+		}
+		// the stored message carries the counters and version the payload lacks
+		if stored, err := d.Messages().Get(e.Id()); err == nil {
+			e = stored
+		}
+		return MessageEvent{Message: e, Operation: op}, true
+	case *entities.Like:
+		if op != datalink.Operation_Create && op != datalink.Operation_Delete {
+			return MessageEvent{}, false
+		}
+		msg, err := d.Messages().Get(e.MessageId)
+		if err != nil {
+			return MessageEvent{}, false
+		}
+		return MessageEvent{Message: msg, Operation: op, Like: true}, true
+	}
+	return MessageEvent{}, false
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"seminarska/internal/data/storage/db"
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+
+	"google.golang.org/grpc/status"
+)
+
+// runChain plays a chain of a single node: it numbers the messages the
+// database dispatches, applies them and confirms them.
+func runChain(t *testing.T, d *AppDatabase) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	h := d.ReplicationHandler()
+	go func() {
+		var index int32
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-h.Messages():
+				index++
+				msg.MessageIndex = index
+				confirmation := &datalink.Confirmation{MessageIndex: index, RequestId: msg.GetRequestId(), Ok: true}
+				if err := h.OnMessage(msg); err != nil {
+					confirmation.Ok = false
+					confirmation.Error = status.Convert(err).Message()
+					confirmation.Code = uint32(status.Code(err))
+				}
+				h.OnConfirmation(confirmation)
+			}
+		}
+	}()
+}
+
+func TestAppDatabase_DeleteTopicOwner(t *testing.T) {
+	d := NewAppDatabase()
+	runChain(t, d)
+	ctx := context.Background()
+	ana, err := d.CreateUser(ctx, "ana")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	bor, err := d.CreateUser(ctx, "bor")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	topic, err := d.CreateTopic(ctx, ana.Id(), "go")
+	if err != nil {
+		t.Fatalf("create topic: %v", err)
+	}
+	if topic.UserId != ana.Id() {
+		t.Fatalf("expected the topic to be owned by %d, got %d", ana.Id(), topic.UserId)
+	}
+
+	if err := d.DeleteTopic(ctx, bor.Id(), topic.Id()); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("expected a non-owner delete to fail, got %v", err)
+	}
+	if _, err := d.Topics().Get(topic.Id()); err != nil {
+		t.Fatalf("expected the topic to remain: %v", err)
+	}
+	if err := d.DeleteTopic(ctx, ana.Id(), topic.Id()); err != nil {
+		t.Fatalf("owner delete: %v", err)
+	}
+	if _, err := d.Topics().Get(topic.Id()); err == nil {
+		t.Fatalf("expected the topic to be deleted")
+	}
+}
+
+func TestAppDatabase_SetTopicRetentionOwner(t *testing.T) {
+	d := NewAppDatabase()
+	runChain(t, d)
+	ctx := context.Background()
+	ana, err := d.CreateUser(ctx, "ana")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	bor, err := d.CreateUser(ctx, "bor")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	topic, err := d.CreateTopic(ctx, ana.Id(), "go")
+	if err != nil {
+		t.Fatalf("create topic: %v", err)
+	}
+
+	retention := entities.Retention{MaxMessages: 10}
+	if _, err := d.SetTopicRetention(ctx, bor.Id(), topic.Id(), retention, nil); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("expected a non-owner to be rejected, got %v", err)
+	}
+	updated, err := d.SetTopicRetention(ctx, ana.Id(), topic.Id(), retention, nil)
+	if err != nil {
+		t.Fatalf("owner set retention: %v", err)
+	}
+	if updated.Retention != retention || updated.UserId != ana.Id() {
+		t.Fatalf("expected retention %v owned by %d, got %v owned by %d",
+			retention, ana.Id(), updated.Retention, updated.UserId)
+	}
+}
+
+func TestAppDatabase_LikeMessage(t *testing.T) {
+	d := NewAppDatabase()
+	runChain(t, d)
+	ctx := context.Background()
+	var users []*entities.User
+	for _, name := range []string{"ana", "bor", "cene"} {
+		user, err := d.CreateUser(ctx, name)
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		users = append(users, user)
+	}
+	topic, err := d.CreateTopic(ctx, users[0].Id(), "go")
+	if err != nil {
+		t.Fatalf("create topic: %v", err)
+	}
+	msg, err := d.PostMessage(ctx, users[0].Id(), topic.Id(), "hello")
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	// the liking user must not share an id with the message
+	liker := users[2]
+	if liker.Id() == msg.Id() {
+		t.Fatalf("expected distinct ids, got %d", msg.Id())
+	}
+
+	likes := func() int32 {
+		messages, _, err := d.GetMessages(topic.Id(), 0, 0, false)
+		if err != nil || len(messages) != 1 {
+			t.Fatalf("get messages: %v %v", messages, err)
+		}
+		return messages[0].Likes
+	}
+	if err := d.LikeMessage(ctx, liker.Id(), msg.Id()); err != nil {
+		t.Fatalf("like: %v", err)
+	}
+	if n := likes(); n != 1 {
+		t.Fatalf("expected 1 like, got %d", n)
+	}
+	if err := d.UnlikeMessage(ctx, liker.Id(), msg.Id()); err != nil {
+		t.Fatalf("unlike: %v", err)
+	}
+	if n := likes(); n != 0 {
+		t.Fatalf("expected no likes, got %d", n)
+	}
+}
+
+func TestAppDatabase_UpdateMessageOwner(t *testing.T) {
+	d := NewAppDatabase()
+	runChain(t, d)
+	ctx := context.Background()
+	ana, _ := d.CreateUser(ctx, "ana")
+	bor, _ := d.CreateUser(ctx, "bor")
+	topic, _ := d.CreateTopic(ctx, ana.Id(), "go")
+	msg, err := d.PostMessage(ctx, ana.Id(), topic.Id(), "hello")
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if _, err := d.UpdateMessage(ctx, bor.Id(), msg.Id(), "hijacked", nil); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("expected a non-author edit to fail, got %v", err)
+	}
+	if stored, _ := d.Messages().Get(msg.Id()); stored.Text != "hello" {
+		t.Fatalf("expected the text to remain, got %q", stored.Text)
+	}
+}
+
+func TestAppDatabase_DeleteMessageOwner(t *testing.T) {
+	d := NewAppDatabase()
+	runChain(t, d)
+	ctx := context.Background()
+	ana, _ := d.CreateUser(ctx, "ana")
+	bor, _ := d.CreateUser(ctx, "bor")
+	topic, _ := d.CreateTopic(ctx, ana.Id(), "go")
+	msg, err := d.PostMessage(ctx, ana.Id(), topic.Id(), "hello")
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if err := d.DeleteMessage(ctx, bor.Id(), msg.Id(), nil); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("expected a non-author delete to fail, got %v", err)
+	}
+	if _, err := d.Messages().Get(msg.Id()); err != nil {
+		t.Fatalf("expected the message to remain: %v", err)
+	}
+	if err := d.DeleteMessage(ctx, ana.Id(), msg.Id(), nil); err != nil {
+		t.Fatalf("author delete: %v", err)
+	}
+	if _, err := d.Messages().Get(msg.Id()); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected the message to be deleted, got %v", err)
+	}
+}
+
+func TestAppDatabase_GetMessageHistoryTopic(t *testing.T) {
+	d := NewAppDatabase()
+	runChain(t, d)
+	ctx := context.Background()
+	ana, _ := d.CreateUser(ctx, "ana")
+	general, _ := d.CreateTopic(ctx, ana.Id(), "general")
+	other, _ := d.CreateTopic(ctx, ana.Id(), "other")
+	msg, err := d.PostMessage(ctx, ana.Id(), general.Id(), "hello")
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if _, err := d.UpdateMessage(ctx, ana.Id(), msg.Id(), "hello again", nil); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	_, revisions, err := d.GetMessageHistory(general.Id(), msg.Id())
+	if err != nil || len(revisions) != 1 || revisions[0].Text != "hello" {
+		t.Fatalf("unexpected history: %v %v", revisions, err)
+	}
+	if _, _, err := d.GetMessageHistory(other.Id(), msg.Id()); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected the message not to be found in another topic, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var (
+	ErrOverloaded   = errors.New("too many writes in flight")
+	errUnknownWrite = errors.New("write was not dispatched by this node")
+)
+
+// AdmissionLimits caps the writes the head accepts. A write is in flight from its
+// dispatch until its confirmation, successful or not, is settled; a client that
+// stops waiting earlier does not free its slot, as the write still moves down the chain.
+type AdmissionLimits struct {
+	MaxInFlight int           // 0 admits every write
+	RetryAfter  time.Duration // suggested to refused clients
+}
+
+// admission refuses writes over the limit right away instead of queueing them,
+// so a slow chain is reported to clients rather than piling up handlers. It also
+// keeps the outcome of each admitted write until its client collects it, so a
+// confirmation that arrives before the client waits for it is not lost.
+type admission struct {
+	mx       sync.Mutex
+	limits   AdmissionLimits
+	inFlight int
+	writes   map[string]*write
+}
+
+type write struct {
+	done      chan struct{} // closed once the write is settled
+	entityId  int64
+	err       error
+	abandoned bool // the client stopped waiting, so nobody collects the outcome
+}
+
+func newAdmission() *admission {
+	return &admission{writes: make(map[string]*write)}
+}
+
+func (a *admission) setLimits(limits AdmissionLimits) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.limits = limits
+}
+
+func (a *admission) admit(requestId string) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.limits.MaxInFlight > 0 && a.inFlight >= a.limits.MaxInFlight {
+		return a.overloaded()
+	}
+	a.inFlight++
+	a.writes[requestId] = &write{done: make(chan struct{})}
+	return nil
+}
+
+// withdraw frees the slot of a write that was admitted but never sent.
+func (a *admission) withdraw(requestId string) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if _, ok := a.writes[requestId]; ok {
+		delete(a.writes, requestId)
+		a.inFlight--
+	}
+}
+
+// settle records the outcome of a write and frees its slot. Writes that were not
+// admitted here, like those of other nodes, are ignored, as are repeated settles.
+func (a *admission) settle(requestId string, entityId int64, err error) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	w, ok := a.writes[requestId]
+	if !ok {
+		return
+	}
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	w.entityId, w.err = entityId, err
+	close(w.done)
+	a.inFlight--
+	if w.abandoned {
+		delete(a.writes, requestId)
+	}
+}
+
+// await returns the outcome of an admitted write once it is settled. A client
+// that gives up leaves the write in flight until it is settled.
+func (a *admission) await(ctx context.Context, requestId string) (int64, error) {
+	a.mx.Lock()
+	w, ok := a.writes[requestId]
+	a.mx.Unlock()
+	if !ok {
+		return 0, errUnknownWrite
+	}
+	select {
+	case <-w.done:
+		a.mx.Lock()
+		delete(a.writes, requestId)
+		a.mx.Unlock()
+		return w.entityId, w.err
+	case <-ctx.Done():
+		a.mx.Lock()
+		defer a.mx.Unlock()
+		select {
+		case <-w.done:
+			delete(a.writes, requestId)
+		default:
+			w.abandoned = true
+		}
+		return 0, ctx.Err()
+	}
+}
+
+func (a *admission) overloaded() error {
+	st := status.New(codes.ResourceExhausted, ErrOverloaded.Error())
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(a.limits.RetryAfter),
+	}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
@@ -0,0 +1,105 @@
+package replication
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHandler_Admission(t *testing.T) {
+	h, _ := newTestHandler()
+	h.LimitWrites(AdmissionLimits{MaxInFlight: 1, RetryAfter: 2 * time.Second})
+	ctx := context.Background()
+	go func() {
+		for range h.Messages() {
+		}
+	}()
+
+	first, err := h.DispatchNewMessage(ctx, entities.NewUser("ana"), datalink.Operation_Create)
+	if err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	_, err = h.DispatchNewMessage(ctx, entities.NewUser("bor"), datalink.Operation_Create)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted got %v", err)
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("expected a retry hint, got %v", st.Details())
+	}
+	if info, ok := st.Details()[0].(*errdetails.RetryInfo); !ok || info.GetRetryDelay().AsDuration() != 2*time.Second {
+		t.Fatalf("expected retry after 2s, got %v", st.Details()[0])
+	}
+
+	h.OnConfirmation(&datalink.Confirmation{MessageIndex: 1, RequestId: first, Ok: true})
+	if _, err := h.DispatchNewMessage(ctx, entities.NewUser("bor"), datalink.Operation_Create); err != nil {
+		t.Fatalf("expected confirmation to free the slot, got %v", err)
+	}
+}
+
+func TestHandler_AdmissionCanceled(t *testing.T) {
+	h, _ := newTestHandler()
+	h.LimitWrites(AdmissionLimits{MaxInFlight: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// nothing takes the message, so the dispatch gives up and frees its slot
+	if _, err := h.DispatchNewMessage(ctx, entities.NewUser("ana"), datalink.Operation_Create); err == nil {
+		t.Fatalf("expected canceled dispatch to fail")
+	}
+	go func() { <-h.Messages() }()
+	if _, err := h.DispatchNewMessage(context.Background(), entities.NewUser("ana"), datalink.Operation_Create); err != nil {
+		t.Fatalf("expected the slot to be free, got %v", err)
+	}
+}
+
+func TestHandler_AdmissionTimeout(t *testing.T) {
+	h, _ := newTestHandler()
+	h.LimitWrites(AdmissionLimits{MaxInFlight: 1})
+	go func() {
+		for range h.Messages() {
+		}
+	}()
+	requestId, err := h.DispatchNewMessage(context.Background(), entities.NewUser("ana"), datalink.Operation_Create)
+	if err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.AwaitConfirmation(ctx, requestId); err == nil {
+		t.Fatalf("expected the wait to time out")
+	}
+	// the write is still moving down the chain, so it keeps its slot
+	_, err = h.DispatchNewMessage(context.Background(), entities.NewUser("bor"), datalink.Operation_Create)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the slot to stay held, got %v", err)
+	}
+	h.OnConfirmation(&datalink.Confirmation{MessageIndex: 1, RequestId: requestId, Ok: true})
+	if _, err := h.DispatchNewMessage(context.Background(), entities.NewUser("bor"), datalink.Operation_Create); err != nil {
+		t.Fatalf("expected the confirmation to free the slot, got %v", err)
+	}
+}
+
+func TestHandler_ConfirmedBeforeAwait(t *testing.T) {
+	h, _ := newTestHandler()
+	go func() {
+		for range h.Messages() {
+		}
+	}()
+	requestId, err := h.DispatchNewMessage(context.Background(), entities.NewUser("ana"), datalink.Operation_Create)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	h.OnConfirmation(&datalink.Confirmation{MessageIndex: 7, RequestId: requestId, Ok: true})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if id, err := h.AwaitConfirmation(ctx, requestId); err != nil || id != 7 {
+		t.Fatalf("expected the earlier confirmation, got %d %v", id, err)
+	}
+}
@@ -6,18 +6,22 @@ import (
 )
 
 type Broadcaster[T any] struct {
-	mu   sync.RWMutex
-	subs map[chan T]struct{}
+	mu     sync.RWMutex
+	subs   map[chan T]struct{}
+	buffer int
 }
 
-func New[T any]() *Broadcaster[T] {
+// New returns a broadcaster that holds up to size values for each subscriber
+// before dropping them.
+func New[T any](size int) *Broadcaster[T] {
 	return &Broadcaster[T]{
-		subs: make(map[chan T]struct{}),
+		subs:   make(map[chan T]struct{}),
+		buffer: size,
 	}
 }
 
 func (b *Broadcaster[T]) Subscribe(ctx context.Context) <-chan T {
-	ch := make(chan T, 1)
+	ch := make(chan T, b.buffer)
 
 	b.mu.Lock()
 	b.subs[ch] = struct{}{}
@@ -1,7 +1,6 @@
 package replication
 
 import (
-	"errors"
 	"log"
 	"seminarska/proto/datalink"
 )
@@ -9,27 +8,36 @@ import (
 func (h *Handler) OnConfirmation(confirmation *datalink.Confirmation) {
 	var err error
 	if !confirmation.Ok {
-		err = errors.New(confirmation.GetError())
+		err = confirmationError(confirmation)
 	}
-	h.confirmationBroadcast.Broadcast(newResponse(
-		confirmation.GetRequestId(),
-		int64(confirmation.GetMessageIndex()),
-		err,
-	))
+	// settle the receipt before announcing the outcome,
+	// so a client woken by the response reads the new state
+	applied, err := h.settle(confirmation, err)
+	res := newResponse(confirmation.GetRequestId(), int64(confirmation.GetMessageIndex()), err)
+	res.message = applied
+	h.admission.settle(res.requestId, res.entityId, res.err)
+	h.confirmationBroadcast.Broadcast(res)
+}
+
+// settle applies or cancels the pending message of a confirmation. It returns the
+// message if it was applied, and otherwise the error it failed with.
+func (h *Handler) settle(confirmation *datalink.Confirmation, err error) (*datalink.Message, error) {
 	h.mx.Lock()
-	receipt, ok := h.pendingRequests[confirmation.GetMessageIndex()]
+	pending, ok := h.pendingRequests[confirmation.GetMessageIndex()]
 	if !ok {
 		h.mx.Unlock()
-		return
+		return nil, err
 	}
 	delete(h.pendingRequests, confirmation.GetMessageIndex())
 	h.mx.Unlock()
-	if confirmation.Ok {
-		err := receipt.Confirm()
-		if err != nil {
-			log.Println("Failed to confirm record", err)
-		}
-	} else {
-		receipt.Cancel(errors.New(confirmation.GetError()))
+	if !confirmation.Ok {
+		pending.receipt.Cancel(err)
+		return nil, err
+	}
+	if err := h.apply(pending.receipt, pending.message); err != nil {
+		log.Println("Failed to confirm record", err)
+		return nil, err
 	}
+	h.persist(pending.message)
+	return pending.message, nil
 }
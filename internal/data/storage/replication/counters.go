@@ -0,0 +1,82 @@
+package replication
+
+import (
+	"seminarska/internal/data/storage/db"
+	"seminarska/internal/data/storage/entities"
+	"slices"
+)
+
+// Message.Likes is a counter the handler maintains itself. Every like insert or
+// delete is prepared together with an update of its message's counter, computed
+// from the latest state, so the counter is confirmed or cancelled with the like
+// and every replica arrives at the same value. Counter updates keep the version.
+
+func resetCounters(created entities.Entity) {
+	if msg, ok := created.(*entities.Message); ok {
+		msg.Likes = 0
+	}
+}
+
+// keepCounters carries the counters over to a value replacing current.
+func keepCounters(current, updated entities.Entity) {
+	if msg, ok := updated.(*entities.Message); ok {
+		msg.Likes = current.(*entities.Message).Likes
+	}
+}
+
+func (h *Handler) countLikes(messageId int64, delta int32) (db.Receipt, error) {
+	return h.relations.Messages().Update(messageId, func(current *entities.Message) (*entities.Message, error) {
+		updated := *current
+		updated.Likes += delta
+		return &updated, nil
+	})
+}
+
+// withLikeCounted adds the counter update for a new like to its receipt.
+func (h *Handler) withLikeCounted(receipt db.Receipt, like *entities.Like) (db.Receipt, error) {
+	counter, err := h.countLikes(like.MessageId, 1)
+	if err != nil {
+		receipt.Cancel(err)
+		return nil, err
+	}
+	return db.Receipts{receipt, counter}, nil
+}
+
+// uncountLikes prepares the counter updates for likes removed by a delete.
+// Messages deleted by the same operation are left alone.
+func (h *Handler) uncountLikes(deleted []recordRef) (db.Receipts, error) {
+	deletedMessages := make(map[int64]bool)
+	for _, ref := range deleted {
+		if ref.relation == messages {
+			deletedMessages[ref.id] = true
+		}
+	}
+	removed := make(map[int64]int32)
+	for _, ref := range deleted {
+		if ref.relation != likes {
+			continue
+		}
+		like, err := h.relations.Likes().Latest(ref.id)
+		if err != nil {
+			return nil, err
+		}
+		if !deletedMessages[like.MessageId] {
+			removed[like.MessageId]++
+		}
+	}
+	ids := make([]int64, 0, len(removed))
+	for id := range removed {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	receipts := make(db.Receipts, 0, len(ids))
+	for _, id := range ids {
+		counter, err := h.countLikes(id, -removed[id])
+		if err != nil {
+			receipts.Cancel(err)
+			return nil, err
+		}
+		receipts = append(receipts, counter)
+	}
+	return receipts, nil
+}
@@ -0,0 +1,63 @@
+package replication
+
+import (
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+	"testing"
+	"time"
+)
+
+func TestHandler_LikeCounters(t *testing.T) {
+	h, r := newTestHandler()
+	for _, op := range []*datalink.Message{
+		message(1, datalink.Operation_Create, entities.NewUser("ana")),
+		message(2, datalink.Operation_Create, entities.NewUser("bor")),
+		message(3, datalink.Operation_Create, entities.NewTopic("go")),
+		message(4, datalink.Operation_Create, entities.NewMessage(3, 1, "a", time.Unix(1, 0))),
+		message(5, datalink.Operation_Create, entities.NewLike(1, 4)),
+		message(6, datalink.Operation_Create, entities.NewLike(2, 4)),
+	} {
+		if err := h.OnMessage(op); err != nil {
+			t.Fatalf("message %d: %v", op.MessageIndex, err)
+		}
+	}
+	confirm(h, 1, 2, 3, 4, 5)
+	if msg, _ := r.messages.Get(4); msg.Likes != 1 {
+		t.Fatalf("expected the confirmed like to be counted, got %d", msg.Likes)
+	}
+	confirm(h, 6)
+	msg, _ := r.messages.Get(4)
+	if msg.Likes != 2 || msg.Version() != 1 {
+		t.Fatalf("expected 2 likes at version 1, got %d at version %d", msg.Likes, msg.Version())
+	}
+
+	// an edit keeps the counter, whatever the payload says
+	edited := withId(entities.NewMessage(3, 1, "b", time.Unix(1, 0)), 4)
+	edited.Likes = 40
+	_ = h.OnMessage(message(7, datalink.Operation_Update, edited))
+	confirm(h, 7)
+	if msg, _ := r.messages.Get(4); msg.Likes != 2 || msg.Text != "b" {
+		t.Fatalf("expected edit to keep 2 likes, got %d", msg.Likes)
+	}
+
+	_ = h.OnMessage(message(8, datalink.Operation_Delete, withId(entities.NewLike(0, 0), 5)))
+	confirm(h, 8)
+	if msg, _ := r.messages.Get(4); msg.Likes != 1 {
+		t.Fatalf("expected delete to uncount the like, got %d", msg.Likes)
+	}
+
+	_ = h.OnMessage(message(9, datalink.Operation_Create, entities.NewLike(1, 4)))
+	h.OnConfirmation(&datalink.Confirmation{MessageIndex: 9, Ok: false, Error: "rejected"})
+	if msg, _ := r.messages.Get(4); msg.Likes != 1 {
+		t.Fatalf("expected cancelled like to leave the counter, got %d", msg.Likes)
+	}
+
+	// the message goes together with its likes, no counter update is needed
+	if err := h.OnMessage(message(10, datalink.Operation_Delete, withId(entities.NewTopic(""), 3))); err != nil {
+		t.Fatalf("delete topic: %v", err)
+	}
+	confirm(h, 10)
+	if r.messages.Count() != 0 || r.likes.Count() != 0 {
+		t.Fatalf("expected topic delete to remove everything")
+	}
+}
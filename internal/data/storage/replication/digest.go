@@ -0,0 +1,145 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"seminarska/internal/data/storage/db"
+	"seminarska/proto/datalink"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// digestHistory is how many of the latest digests are kept for comparison.
+const digestHistory = 1024
+
+var (
+	ErrDigestUnknown   = errors.New("digest unknown: state was imported without one")
+	ErrDigestForgotten = errors.New("digest not kept for index")
+)
+
+// digest folds every confirmed operation into a running hash, so replicas that
+// applied the same operations hold the same digest at the same message index.
+// Failed operations change nothing and are left out.
+type digest struct {
+	mx      sync.Mutex
+	known   bool
+	index   int32
+	value   []byte
+	history map[int32][]byte
+	order   []int32
+}
+
+func newDigest() *digest {
+	d := &digest{}
+	d.reset(0, make([]byte, sha256.Size))
+	return d
+}
+
+// reset starts over from the digest of an imported state; nil if it is unknown.
+func (d *digest) reset(index int32, value []byte) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.known = value != nil
+	d.index, d.value = index, value
+	d.history = map[int32][]byte{index: value}
+	d.order = []int32{index}
+}
+
+func (d *digest) fold(message *datalink.Message) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		panic(err)
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	h := sha256.New()
+	h.Write(d.value)
+	h.Write(data)
+	d.index, d.value = message.GetMessageIndex(), h.Sum(nil)
+	d.history[d.index] = d.value
+	d.order = append(d.order, d.index)
+	if len(d.order) > digestHistory {
+		delete(d.history, d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+// at returns the digest through index, or the latest one for index 0.
+func (d *digest) at(index int32) (int32, []byte, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if !d.known {
+		return 0, nil, ErrDigestUnknown
+	}
+	if index == 0 {
+		return d.index, d.value, nil
+	}
+	value, ok := d.history[index]
+	if !ok {
+		return 0, nil, ErrDigestForgotten
+	}
+	return index, value, nil
+}
+
+func (d *digest) latest() (int32, []byte) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if !d.known {
+		return d.index, nil
+	}
+	return d.index, d.value
+}
+
+// Digest returns the digest of the confirmed operations through index, or the
+// latest one together with its index for index 0.
+func (h *Handler) Digest(index int32) (int32, []byte, error) {
+	return h.digest.at(index)
+}
+
+// Applied returns the index of the latest confirmed operation applied to the
+// replica. Replicas apply operations in the same order, so a replica that
+// reached the index of another holds everything the other applied.
+func (h *Handler) Applied() int32 {
+	index, _ := h.digest.latest()
+	return index
+}
+
+// AwaitApplied waits until the replica applied the operations through index.
+func (h *Handler) AwaitApplied(ctx context.Context, index int32) error {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	confirmations := h.confirmationBroadcast.Subscribe(subCtx)
+	for h.Applied() < index {
+		if _, ok := <-confirmations; !ok {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// ResetDigest continues from the digest of an imported snapshot; nil if it has none.
+func (h *Handler) ResetDigest(index int32, value []byte) {
+	h.digest.reset(index, value)
+}
+
+// Consistent runs read while no confirmation is applied and returns the digest
+// of the state it saw; nil if it is unknown.
+func (h *Handler) Consistent(read func()) (int32, []byte) {
+	h.applyMx.RLock()
+	defer h.applyMx.RUnlock()
+	read()
+	return h.digest.latest()
+}
+
+// apply confirms a prepared operation and folds it into the digest.
+func (h *Handler) apply(receipt db.Receipt, message *datalink.Message) error {
+	h.applyMx.Lock()
+	defer h.applyMx.Unlock()
+	if err := receipt.Confirm(); err != nil {
+		return err
+	}
+	h.digest.fold(message)
+	return nil
+}
@@ -0,0 +1,98 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+	"testing"
+	"time"
+)
+
+func TestHandler_Digest(t *testing.T) {
+	a, _ := newTestHandler()
+	b, _ := newTestHandler()
+	for _, h := range []*Handler{a, b} {
+		_ = h.OnMessage(message(1, datalink.Operation_Create, entities.NewUser("ana")))
+		_ = h.OnMessage(message(2, datalink.Operation_Create, entities.NewTopic("go")))
+		// fails on both replicas, so it is left out of the digest
+		_ = h.OnMessage(message(3, datalink.Operation_Create, entities.NewUser("ana")))
+		confirm(h, 1, 2)
+		h.OnConfirmation(&datalink.Confirmation{MessageIndex: 3, Ok: false, Error: "taken"})
+	}
+	index, digestA, err := a.Digest(0)
+	if err != nil || index != 2 {
+		t.Fatalf("expected the latest digest at 2, got %d (%v)", index, err)
+	}
+	if _, digestB, _ := b.Digest(0); !bytes.Equal(digestA, digestB) {
+		t.Fatalf("replicas that applied the same operations should have equal digests")
+	}
+	if _, _, err := a.Digest(3); !errors.Is(err, ErrDigestForgotten) {
+		t.Fatalf("expected no digest at the failed operation, got %v", err)
+	}
+	if at, d1, _ := a.Digest(1); at != 1 || bytes.Equal(d1, digestA) {
+		t.Fatalf("expected a distinct digest at 1")
+	}
+
+	// b diverges
+	_ = a.OnMessage(message(4, datalink.Operation_Create, entities.NewUser("bor")))
+	_ = b.OnMessage(message(4, datalink.Operation_Create, entities.NewUser("bob")))
+	confirm(a, 4)
+	confirm(b, 4)
+	_, digestA, _ = a.Digest(4)
+	if _, digestB, _ := b.Digest(4); bytes.Equal(digestA, digestB) {
+		t.Fatalf("replicas that applied different operations should have different digests")
+	}
+
+	// a replica imported without a digest cannot be compared
+	b.ResetDigest(4, nil)
+	if _, _, err := b.Digest(0); !errors.Is(err, ErrDigestUnknown) {
+		t.Fatalf("expected unknown digest, got %v", err)
+	}
+	b.ResetDigest(4, digestA)
+	_ = a.OnMessage(message(5, datalink.Operation_Create, entities.NewTopic("rust")))
+	_ = b.OnMessage(message(5, datalink.Operation_Create, entities.NewTopic("rust")))
+	confirm(a, 5)
+	confirm(b, 5)
+	_, digestA, _ = a.Digest(0)
+	if _, digestB, _ := b.Digest(0); !bytes.Equal(digestA, digestB) {
+		t.Fatalf("a replica continuing from a snapshot digest should match again")
+	}
+}
+
+func TestHandler_AwaitApplied(t *testing.T) {
+	h, _ := newTestHandler()
+	_ = h.OnMessage(message(1, datalink.Operation_Create, entities.NewUser("ana")))
+	_ = h.OnMessage(message(2, datalink.Operation_Create, entities.NewUser("bor")))
+	confirm(h, 1)
+	if h.Applied() != 1 {
+		t.Fatalf("expected applied 1 got %d", h.Applied())
+	}
+	if err := h.AwaitApplied(context.Background(), 1); err != nil {
+		t.Fatalf("await applied operation: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- h.AwaitApplied(context.Background(), 2) }()
+	select {
+	case err := <-done:
+		t.Fatalf("returned before the operation was applied: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	confirm(h, 2)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("await: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected await to return once the operation was applied")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := h.AwaitApplied(ctx, 3); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context error got %v", err)
+	}
+}
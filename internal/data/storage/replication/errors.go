@@ -0,0 +1,43 @@
+package replication
+
+import (
+	"errors"
+	"seminarska/internal/data/storage/db"
+	"seminarska/internal/data/storage/keys"
+	"seminarska/proto/datalink"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrReference    = errors.New("referenced record does not exist")
+	ErrInvalidBatch = errors.New("invalid batch")
+)
+
+// statusError attaches a grpc status code to a failed operation. The code travels
+// back along the chain in the confirmation, so clients of the head receive it too.
+func statusError(err error) error {
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, ErrReference), errors.Is(err, ErrReferenced):
+		code = codes.FailedPrecondition
+	case errors.Is(err, db.ErrNotFound), errors.Is(err, db.ErrDeleted):
+		code = codes.NotFound
+	case errors.Is(err, keys.ErrConstraint), errors.Is(err, keys.ErrDuplicateId):
+		code = codes.AlreadyExists
+	case errors.Is(err, ErrInvalidBatch):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrVersion):
+		code = codes.Aborted
+	}
+	return status.Error(code, err.Error())
+}
+
+func confirmationError(confirmation *datalink.Confirmation) error {
+	code := codes.Code(confirmation.GetCode())
+	if code == codes.OK {
+		code = codes.Unknown
+	}
+	return status.Error(code, confirmation.GetError())
+}
@@ -5,6 +5,7 @@ import (
 	"seminarska/internal/data/storage/db"
 	"seminarska/internal/data/storage/entities"
 	"seminarska/internal/data/storage/replication/broadcast"
+	"seminarska/internal/data/storage/wal"
 	"seminarska/proto/datalink"
 	"sync"
 )
@@ -14,73 +15,76 @@ type Relations interface {
 	Messages() *db.Relation[*entities.Message]
 	Topics() *db.Relation[*entities.Topic]
 	Likes() *db.Relation[*entities.Like]
+	Revisions() *db.Relation[*entities.Revision]
 }
 
 type response struct {
 	requestId string
 	entityId  int64
 	err       error
+	message   *datalink.Message // the applied message, nil if it was not applied here
 }
 
 func newResponse(requestId string, entityId int64, err error) response {
 	return response{requestId: requestId, entityId: entityId, err: err}
 }
 
+type pendingRequest struct {
+	receipt db.Receipt
+	message *datalink.Message
+}
+
 type Handler struct {
 	relations             Relations
+	tables                []table
+	log                   *wal.Log
+	onPersisted           func(index int32)
+	headTasks             []func(ctx context.Context)
+	digest                *digest
+	admission             *admission
+	applyMx               sync.RWMutex // held while a confirmation is applied
 	mx                    sync.Mutex
-	pendingRequests       map[int32]db.Receipt
+	pendingRequests       map[int32]pendingRequest
 	newMessages           chan *datalink.Message
 	confirmationBroadcast *broadcast.Broadcaster[response]
-	messageBroadcast      *broadcast.Broadcaster[*datalink.Message]
 }
 
 func NewHandler(relations Relations) *Handler {
 	return &Handler{
 		relations:             relations,
+		tables:                newTables(relations),
+		digest:                newDigest(),
+		admission:             newAdmission(),
 		mx:                    sync.Mutex{},
-		confirmationBroadcast: broadcast.New[response](),
-		messageBroadcast:      broadcast.New[*datalink.Message](),
-		pendingRequests:       make(map[int32]db.Receipt),
+		confirmationBroadcast: broadcast.New[response](100),
+		pendingRequests:       make(map[int32]pendingRequest),
 		newMessages:           make(chan *datalink.Message),
 	}
 }
 
+// LimitWrites sets the limits of the writes dispatched at the head.
+func (h *Handler) LimitWrites(limits AdmissionLimits) {
+	h.admission.setLimits(limits)
+}
+
+// AwaitConfirmation returns the outcome of a write dispatched by this node, waiting
+// until it is confirmed or ctx is done.
 func (h *Handler) AwaitConfirmation(ctx context.Context, requestId string) (int64, error) {
-	subCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	for res := range h.confirmationBroadcast.Subscribe(subCtx) {
-		if res.requestId == requestId {
-			return res.entityId, res.err
-		}
-	}
-	return 0, subCtx.Err()
+	return h.admission.await(ctx, requestId)
 }
 
+// Observe returns the messages applied by this node, in the order of their
+// confirmations. Messages that were refused or failed to apply are left out.
 func (h *Handler) Observe(ctx context.Context) <-chan *datalink.Message {
-	mx := &sync.Mutex{}
-	messages := make(map[string]*datalink.Message)
+	confirmations := h.confirmationBroadcast.Subscribe(ctx)
 	out := make(chan *datalink.Message, 100)
-
-	go func() {
-		for msg := range h.messageBroadcast.Subscribe(ctx) {
-			mx.Lock()
-			messages[msg.GetRequestId()] = msg
-			mx.Unlock()
-		}
-	}()
-
 	go func() {
 		defer close(out)
-		for res := range h.confirmationBroadcast.Subscribe(ctx) {
-			mx.Lock()
-			if msg, ok := messages[res.requestId]; ok {
-				delete(messages, res.requestId)
-				out <- msg
+		for res := range confirmations {
+			if res.err == nil && res.message != nil {
+				out <- res.message
 			}
-			mx.Unlock()
 		}
 	}()
-
 	return out
 }
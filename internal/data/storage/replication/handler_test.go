@@ -0,0 +1,68 @@
+package replication
+
+import (
+	"context"
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHandler_ObserveSkipsRejected(t *testing.T) {
+	h, _ := newTestHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	observed := h.Observe(ctx)
+
+	refuse := func(index int32, err error) {
+		h.OnConfirmation(&datalink.Confirmation{
+			MessageIndex: index,
+			Code:         uint32(status.Code(err)),
+			Error:        status.Convert(err).Message(),
+		})
+	}
+	ops := []*datalink.Message{
+		message(1, datalink.Operation_Create, entities.NewUser("ana")),
+		message(2, datalink.Operation_Create, entities.NewTopic("go")),
+		message(3, datalink.Operation_Create, entities.NewMessage(2, 1, "hi", time.Unix(1, 0))),
+		message(4, datalink.Operation_Create, entities.NewLike(1, 3)),
+	}
+	for _, op := range ops {
+		if err := h.OnMessage(op); err != nil {
+			t.Fatalf("message %d: %v", op.MessageIndex, err)
+		}
+		confirm(h, op.MessageIndex)
+	}
+	// a like refused by this node
+	err := h.OnMessage(message(5, datalink.Operation_Create, entities.NewLike(1, 3)))
+	if err == nil {
+		t.Fatalf("expected a repeated like to be refused")
+	}
+	refuse(5, err)
+	// a like refused further down the chain
+	if err := h.OnMessage(message(6, datalink.Operation_Create, entities.NewLike(1, 3))); err == nil {
+		t.Fatalf("expected a repeated like to be refused")
+	}
+	if err := h.OnMessage(message(7, datalink.Operation_Delete, withId(entities.NewLike(1, 3), 4))); err != nil {
+		t.Fatalf("unlike: %v", err)
+	}
+	refuse(7, status.Error(codes.Aborted, "refused by the tail"))
+	if err := h.OnMessage(message(8, datalink.Operation_Create, entities.NewUser("bor"))); err != nil {
+		t.Fatalf("message 8: %v", err)
+	}
+	confirm(h, 8)
+
+	for _, want := range []int32{1, 2, 3, 4, 8} {
+		select {
+		case msg := <-observed:
+			if msg.GetMessageIndex() != want {
+				t.Fatalf("expected message %d, got %d", want, msg.GetMessageIndex())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected message %d to be observed", want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"seminarska/internal/data/storage/db"
+	"seminarska/internal/data/storage/entities"
+)
+
+var ErrReferenced = errors.New("record is still referenced")
+
+// Relation names used by the ref option of entity fields.
+const (
+	users     = "users"
+	topics    = "topics"
+	messages  = "messages"
+	likes     = "likes"
+	revisions = "revisions"
+)
+
+// table gives the integrity checks uniform access to relations of different types.
+type table struct {
+	name        string
+	foreignKeys []db.ForeignKey
+	contains    func(id int64) bool
+	referencing func(field string, id int64) []int64
+	remove      func(id int64) (db.Receipt, error)
+}
+
+func newTable[E entities.Entity](name string, relation *db.Relation[E]) table {
+	return table{
+		name:        name,
+		foreignKeys: relation.ForeignKeys(),
+		contains:    relation.Contains,
+		referencing: relation.Referencing,
+		remove: func(id int64) (db.Receipt, error) {
+			return relation.Delete(id)
+		},
+	}
+}
+
+func newTables(relations Relations) []table {
+	return []table{
+		newTable(users, relations.Users()),
+		newTable(topics, relations.Topics()),
+		newTable(messages, relations.Messages()),
+		newTable(likes, relations.Likes()),
+		newTable(revisions, relations.Revisions()),
+	}
+}
+
+func (h *Handler) table(name string) (table, error) {
+	for _, t := range h.tables {
+		if t.name == name {
+			return t, nil
+		}
+	}
+	return table{}, errors.New("unknown relation " + name)
+}
+
+// checkReferences fails unless every referenced record exists. Pending changes
+// count, since a message may refer to a record created by an earlier message.
+func (h *Handler) checkReferences(refs []db.Reference) error {
+	for _, ref := range refs {
+		t, err := h.table(ref.Relation)
+		if err != nil {
+			return err
+		}
+		if !t.contains(ref.Id) {
+			return fmt.Errorf("%w: %s %d (%s)", ErrReference, ref.Relation, ref.Id, ref.Field)
+		}
+	}
+	return nil
+}
+
+type recordRef struct {
+	relation string
+	id       int64
+}
+
+// deleteCascading deletes a record together with every record that cascades
+// from it, as a single receipt. Dependents are collected before anything is
+// deleted, so a reference without cascade fails the operation without side effects.
+func (h *Handler) deleteCascading(relation string, id int64) (db.Receipt, error) {
+	var targets []recordRef
+	if err := h.collectDeletes(relation, id, make(map[recordRef]bool), &targets); err != nil {
+		return nil, err
+	}
+	// counters are read before the likes are marked deleted
+	receipts, err := h.uncountLikes(targets)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		t, _ := h.table(target.relation)
+		receipt, err := t.remove(target.id)
+		if err != nil {
+			receipts.Cancel(err)
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	if len(receipts) == 1 {
+		return receipts[0], nil
+	}
+	return receipts, nil
+}
+
+// collectDeletes appends the records to delete, dependents before the records they refer to.
+func (h *Handler) collectDeletes(relation string, id int64, seen map[recordRef]bool, targets *[]recordRef) error {
+	ref := recordRef{relation, id}
+	if seen[ref] {
+		return nil
+	}
+	seen[ref] = true
+	parent, err := h.table(relation)
+	if err != nil {
+		return err
+	}
+	if !parent.contains(id) {
+		return db.ErrNotFound
+	}
+	for _, child := range h.tables {
+		for _, fk := range child.foreignKeys {
+			if fk.Relation != relation {
+				continue
+			}
+			ids := child.referencing(fk.Field, id)
+			if len(ids) > 0 && !fk.Cascade {
+				return fmt.Errorf("%w: %s %d by %s %d", ErrReferenced, relation, id, child.name, ids[0])
+			}
+			for _, childId := range ids {
+				if err := h.collectDeletes(child.name, childId, seen, targets); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	*targets = append(*targets, ref)
+	return nil
+}
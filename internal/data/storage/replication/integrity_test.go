@@ -0,0 +1,146 @@
+package replication
+
+import (
+	"seminarska/internal/data/storage/db"
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testRelations struct {
+	users     *db.Relation[*entities.User]
+	messages  *db.Relation[*entities.Message]
+	topics    *db.Relation[*entities.Topic]
+	likes     *db.Relation[*entities.Like]
+	revisions *db.Relation[*entities.Revision]
+}
+
+func (r *testRelations) Users() *db.Relation[*entities.User]       { return r.users }
+func (r *testRelations) Messages() *db.Relation[*entities.Message] { return r.messages }
+func (r *testRelations) Topics() *db.Relation[*entities.Topic]     { return r.topics }
+func (r *testRelations) Likes() *db.Relation[*entities.Like]       { return r.likes }
+func (r *testRelations) Revisions() *db.Relation[*entities.Revision] {
+	return r.revisions
+}
+
+func newTestHandler() (*Handler, *testRelations) {
+	r := &testRelations{
+		users:     db.NewRelation[*entities.User](),
+		messages:  db.NewRelation[*entities.Message](),
+		topics:    db.NewRelation[*entities.Topic](),
+		likes:     db.NewRelation[*entities.Like](),
+		revisions: db.NewRelation[*entities.Revision](),
+	}
+	return NewHandler(r), r
+}
+
+func message(index int32, op datalink.Operation, e entities.Entity) *datalink.Message {
+	msg := entities.EntityToDatalink(e)
+	msg.MessageIndex = index
+	msg.Op = op
+	return msg
+}
+
+func withId[E entities.Entity](e E, id int64) E {
+	e.SetId(id)
+	return e
+}
+
+func confirm(h *Handler, indices ...int32) {
+	for _, i := range indices {
+		h.OnConfirmation(&datalink.Confirmation{MessageIndex: i, Ok: true})
+	}
+}
+
+func TestHandler_References(t *testing.T) {
+	h, r := newTestHandler()
+	// the head prepares operations before earlier ones are confirmed
+	ops := []*datalink.Message{
+		message(1, datalink.Operation_Create, entities.NewUser("ana")),
+		message(2, datalink.Operation_Create, entities.NewTopic("go")),
+		message(3, datalink.Operation_Create, entities.NewMessage(2, 1, "hi", time.Unix(1, 0))),
+		message(4, datalink.Operation_Create, entities.NewLike(1, 3)),
+	}
+	for _, op := range ops {
+		if err := h.OnMessage(op); err != nil {
+			t.Fatalf("message %d: %v", op.MessageIndex, err)
+		}
+	}
+	confirm(h, 1, 2, 3, 4)
+	if n, _ := h.relations.Likes().CountIndexed("MessageId", int64(3)); n != 1 {
+		t.Fatalf("expected one like, got %d", n)
+	}
+
+	err := h.OnMessage(message(5, datalink.Operation_Create, entities.NewMessage(9, 1, "x", time.Unix(2, 0))))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected failed precondition for missing topic, got %v", err)
+	}
+	err = h.OnMessage(message(6, datalink.Operation_Delete, withId(entities.NewUser(""), 1)))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected failed precondition for referenced user, got %v", err)
+	}
+	err = h.OnMessage(message(7, datalink.Operation_Delete, withId(entities.NewTopic(""), 42)))
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if !r.users.Contains(1) {
+		t.Fatalf("failed delete must not remove the user")
+	}
+}
+
+func TestHandler_CascadingDelete(t *testing.T) {
+	h, r := newTestHandler()
+	ops := []*datalink.Message{
+		message(1, datalink.Operation_Create, entities.NewUser("ana")),
+		message(2, datalink.Operation_Create, entities.NewTopic("go")),
+		message(3, datalink.Operation_Create, entities.NewMessage(2, 1, "a", time.Unix(1, 0))),
+		message(4, datalink.Operation_Create, entities.NewMessage(2, 1, "b", time.Unix(2, 0))),
+		message(5, datalink.Operation_Create, entities.NewLike(1, 3)),
+		message(6, datalink.Operation_Delete, withId(entities.NewMessage(0, 0, "", time.Time{}), 3)),
+		message(7, datalink.Operation_Delete, withId(entities.NewTopic(""), 2)),
+	}
+	for _, op := range ops {
+		if err := h.OnMessage(op); err != nil {
+			t.Fatalf("message %d: %v", op.MessageIndex, err)
+		}
+	}
+	// the message delete already took its like, so the topic delete only sees message 4
+	if r.likes.Contains(5) || r.messages.Contains(3) || r.messages.Contains(4) {
+		t.Fatalf("expected pending deletes to cascade")
+	}
+	confirm(h, 1, 2, 3, 4, 5, 6)
+	if r.likes.Count() != 0 || r.messages.Count() != 1 {
+		t.Fatalf("unexpected state after message delete: %d likes, %d messages", r.likes.Count(), r.messages.Count())
+	}
+	confirm(h, 7)
+	if r.topics.Count() != 0 || r.messages.Count() != 0 || r.users.Count() != 1 {
+		t.Fatalf("expected topic delete to cascade to its messages")
+	}
+}
+
+func TestHandler_CancelCascade(t *testing.T) {
+	h, r := newTestHandler()
+	for _, op := range []*datalink.Message{
+		message(1, datalink.Operation_Create, entities.NewUser("ana")),
+		message(2, datalink.Operation_Create, entities.NewTopic("go")),
+		message(3, datalink.Operation_Create, entities.NewMessage(2, 1, "a", time.Unix(1, 0))),
+		message(4, datalink.Operation_Create, entities.NewLike(1, 3)),
+	} {
+		_ = h.OnMessage(op)
+	}
+	confirm(h, 1, 2, 3, 4)
+	if err := h.OnMessage(message(5, datalink.Operation_Delete, withId(entities.NewTopic(""), 2))); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	h.OnConfirmation(&datalink.Confirmation{MessageIndex: 5, Ok: false, Error: "rejected"})
+	if !r.topics.Contains(2) || !r.messages.Contains(3) || !r.likes.Contains(4) {
+		t.Fatalf("expected cancelled delete to restore all records")
+	}
+	if _, err := r.likes.Get(4); err != nil {
+		t.Fatalf("expected like to be readable again: %v", err)
+	}
+}
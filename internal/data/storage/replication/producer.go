@@ -1,8 +1,11 @@
 package replication
 
 import (
+	"context"
 	"seminarska/internal/data/storage/entities"
 	"seminarska/proto/datalink"
+	"slices"
+	"sync"
 
 	"github.com/google/uuid"
 )
@@ -11,11 +14,95 @@ func (h *Handler) Messages() <-chan *datalink.Message {
 	return h.newMessages
 }
 
-func (h *Handler) DispatchNewMessage(entity entities.Entity, operation datalink.Operation) string {
+// OnHead registers a task to run while this node is the head of the chain. Tasks
+// run in their own goroutine until their context is done, and may dispatch messages.
+func (h *Handler) OnHead(task func(ctx context.Context)) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.headTasks = append(h.headTasks, task)
+}
+
+// RunAtHead runs the head tasks until ctx is done and they return.
+func (h *Handler) RunAtHead(ctx context.Context) {
+	h.mx.Lock()
+	tasks := slices.Clone(h.headTasks)
+	h.mx.Unlock()
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Operation is a change of a single entity. With ExpectedVersion set, an update
+// or delete only applies if the record is still at that version.
+type Operation struct {
+	Entity          entities.Entity
+	Op              datalink.Operation
+	ExpectedVersion *int64
+}
+
+func (o Operation) message() *datalink.Message {
+	message := entities.EntityToDatalink(o.Entity)
+	message.Op = o.Op
+	message.ExpectedVersion = o.ExpectedVersion
+	return message
+}
+
+// DispatchBatch sends the operations down the chain as one message, so they are applied atomically.
+func (h *Handler) DispatchBatch(ctx context.Context, operations ...Operation) (string, error) {
+	return h.dispatch(ctx, newBatch(operations))
+}
+
+func newBatch(operations []Operation) *datalink.Message {
+	batch := &datalink.Batch{Operations: make([]*datalink.Message, len(operations))}
+	for i, operation := range operations {
+		batch.Operations[i] = operation.message()
+	}
+	return &datalink.Message{Payload: &datalink.Message_Batch{Batch: batch}}
+}
+
+// DispatchBatchContext is like DispatchBatch, but is not subject to admission
+// control, for head tasks that only wait until ctx is done, as messages are
+// only taken while this node is the head.
+func (h *Handler) DispatchBatchContext(ctx context.Context, operations ...Operation) (string, error) {
+	message := newBatch(operations)
+	message.RequestId = uuid.New().String()
+	select {
+	case h.newMessages <- message:
+		return message.RequestId, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (h *Handler) Dispatch(ctx context.Context, operation Operation) (string, error) {
+	return h.dispatch(ctx, operation.message())
+}
+
+func (h *Handler) DispatchNewMessage(
+	ctx context.Context, entity entities.Entity, operation datalink.Operation,
+) (string, error) {
+	return h.Dispatch(ctx, Operation{Entity: entity, Op: operation})
+}
+
+// dispatch admits a write and sends it down the chain. The write holds its slot
+// until its confirmation is settled.
+func (h *Handler) dispatch(ctx context.Context, message *datalink.Message) (string, error) {
 	requestId := uuid.New().String()
-	message := entities.EntityToDatalink(entity)
 	message.RequestId = requestId
-	message.Op = operation
-	h.newMessages <- message
-	return requestId
+	if err := h.admission.admit(requestId); err != nil {
+		return "", err
+	}
+	select {
+	case h.newMessages <- message:
+		return requestId, nil
+	case <-ctx.Done():
+		h.admission.withdraw(requestId)
+		return "", ctx.Err()
+	}
 }
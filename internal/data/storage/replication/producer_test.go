@@ -0,0 +1,46 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+	"testing"
+	"time"
+)
+
+func TestHandler_HeadTasks(t *testing.T) {
+	h, _ := newTestHandler()
+	h.OnHead(func(ctx context.Context) {
+		_, err := h.DispatchBatchContext(ctx, Operation{Entity: entities.NewUser("ana"), Op: datalink.Operation_Create})
+		if err != nil {
+			t.Errorf("dispatch at head: %v", err)
+		}
+		// nothing takes a second message, so the dispatch waits until the node stops being the head
+		_, err = h.DispatchBatchContext(ctx, Operation{Entity: entities.NewUser("bor"), Op: datalink.Operation_Create})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected dispatch to give up, got %v", err)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.RunAtHead(ctx)
+	}()
+	select {
+	case msg := <-h.Messages():
+		if len(msg.GetBatch().GetOperations()) != 1 || msg.GetRequestId() == "" {
+			t.Fatalf("unexpected message %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the head task to dispatch")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected head tasks to stop with the context")
+	}
+}
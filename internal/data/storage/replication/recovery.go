@@ -0,0 +1,95 @@
+package replication
+
+import (
+	"errors"
+	"log"
+	"seminarska/internal/data/storage/wal"
+	"seminarska/proto/datalink"
+)
+
+// ErrNoLog is returned for logged messages of a database that is kept in memory only.
+var ErrNoLog = errors.New("no write-ahead log")
+
+// Restore replays the confirmed messages after index from the log into the relations
+// and attaches the log, so that subsequently confirmed messages are appended to it.
+// It returns the index of the last applied message.
+func (h *Handler) Restore(l *wal.Log, after int32) (last int32, err error) {
+	last = after
+	err = l.Replay(func(message *datalink.Message) error {
+		if message.GetMessageIndex() <= after {
+			return nil
+		}
+		receipt, err := h.prepare(message)
+		if err == nil {
+			err = h.apply(receipt, message)
+		}
+		if err != nil {
+			return errors.Join(errors.New("failed to replay message"), err)
+		}
+		last = message.GetMessageIndex()
+		return nil
+	})
+	if err != nil {
+		return after, err
+	}
+	l.SetBase(after)
+	h.mx.Lock()
+	h.log = l
+	h.mx.Unlock()
+	return last, nil
+}
+
+// OnPersisted registers fn to be called after each confirmed message is written to the log.
+func (h *Handler) OnPersisted(fn func(index int32)) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.onPersisted = fn
+}
+
+func (h *Handler) persist(message *datalink.Message) {
+	h.mx.Lock()
+	l, onPersisted := h.log, h.onPersisted
+	h.mx.Unlock()
+	if l == nil {
+		return
+	}
+	if err := l.Append(message); err != nil {
+		log.Println("Failed to persist message", message.GetMessageIndex(), err)
+		return
+	}
+	if onPersisted != nil {
+		onPersisted(message.GetMessageIndex())
+	}
+}
+
+// LoggedMessagesAfter returns the confirmed messages after index from the log.
+// Messages that failed are never logged, so indexes may skip.
+func (h *Handler) LoggedMessagesAfter(index int32) ([]*datalink.Message, error) {
+	h.mx.Lock()
+	l := h.log
+	h.mx.Unlock()
+	if l == nil {
+		return nil, ErrNoLog
+	}
+	return l.ReadAfter(index)
+}
+
+// DropPending forgets the pending requests once a snapshot replaces the
+// relations, since their records are gone.
+func (h *Handler) DropPending() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.pendingRequests = make(map[int32]pendingRequest)
+}
+
+// Close detaches and closes the write-ahead log, if any.
+func (h *Handler) Close() error {
+	h.mx.Lock()
+	l := h.log
+	h.log = nil
+	h.mx.Unlock()
+	if l == nil {
+		return nil
+	}
+	return l.Close()
+}
@@ -0,0 +1,36 @@
+package replication
+
+import (
+	"seminarska/internal/data/storage/db"
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+)
+
+// Every message update keeps the text it replaces as a revision, prepared with
+// the update so both are confirmed or cancelled together. The revision takes the
+// index of the operation as its id, like a created record.
+
+// updateMessage prepares a message update together with its revision.
+func (h *Handler) updateMessage(
+	index int32, updated *entities.Message, message *datalink.Message,
+) (db.Receipt, error) {
+	replaced, err := h.relations.Messages().Latest(updated.Id())
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := do(h, messages, h.relations.Messages(), updated, message)
+	if err != nil {
+		return nil, err
+	}
+	revision := entities.NewRevision(
+		replaced.Id(), replaced.Version(), replaced.Text, updated.UserId, updated.EditedAt,
+	)
+	revision.SetId(int64(index))
+	setVersion(revision, 1)
+	stored, err := h.relations.Revisions().Insert(revision)
+	if err != nil {
+		receipt.Cancel(err)
+		return nil, err
+	}
+	return db.Receipts{receipt, stored}, nil
+}
@@ -0,0 +1,69 @@
+package replication
+
+import (
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func edit(id int64, text string, at time.Time) *entities.Message {
+	msg := withId(entities.NewMessage(2, 1, text, time.Unix(1, 0)), id)
+	msg.EditedAt = at
+	return msg
+}
+
+func TestHandler_Revisions(t *testing.T) {
+	h, r := newTestHandler()
+	for _, op := range []*datalink.Message{
+		message(1, datalink.Operation_Create, entities.NewUser("ana")),
+		message(2, datalink.Operation_Create, entities.NewTopic("go")),
+		message(3, datalink.Operation_Create, entities.NewMessage(2, 1, "a", time.Unix(1, 0))),
+		message(4, datalink.Operation_Update, edit(3, "b", time.Unix(2, 0))),
+		message(5, datalink.Operation_Update, edit(3, "c", time.Unix(3, 0))),
+	} {
+		if err := h.OnMessage(op); err != nil {
+			t.Fatalf("message %d: %v", op.MessageIndex, err)
+		}
+	}
+	confirm(h, 1, 2, 3, 4, 5)
+
+	msg, _ := r.messages.Get(3)
+	if msg.Text != "c" || !msg.EditedAt.Equal(time.Unix(3, 0)) {
+		t.Fatalf("expected message edited to c at 3, got %q at %v", msg.Text, msg.EditedAt)
+	}
+	first, err := r.revisions.Get(4)
+	if err != nil || first.Text != "a" || first.MessageVersion != 1 || !first.EditedAt.Equal(time.Unix(2, 0)) {
+		t.Fatalf("expected revision 4 to keep version 1: %+v %v", first, err)
+	}
+	if second, err := r.revisions.Get(5); err != nil || second.Text != "b" || second.MessageVersion != 2 {
+		t.Fatalf("expected revision 5 to keep version 2: %+v %v", second, err)
+	}
+
+	// a cancelled update leaves no revision behind
+	_ = h.OnMessage(message(6, datalink.Operation_Update, edit(3, "d", time.Unix(4, 0))))
+	h.OnConfirmation(&datalink.Confirmation{MessageIndex: 6, Ok: false, Error: "rejected"})
+	if r.revisions.Count() != 2 {
+		t.Fatalf("expected 2 revisions, got %d", r.revisions.Count())
+	}
+
+	// revision ids come from the operation index, so a batch holds a single message update
+	err = h.OnMessage(batch(7,
+		message(0, datalink.Operation_Update, edit(3, "e", time.Unix(5, 0))),
+		message(0, datalink.Operation_Update, edit(3, "f", time.Unix(5, 0))),
+	))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for two updates in a batch, got %v", err)
+	}
+
+	if err := h.OnMessage(message(8, datalink.Operation_Delete, withId(entities.NewMessage(0, 0, "", time.Time{}), 3))); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	confirm(h, 8)
+	if r.revisions.Count() != 0 {
+		t.Fatalf("expected revisions to be deleted with the message, got %d", r.revisions.Count())
+	}
+}
@@ -2,52 +2,165 @@ package replication
 
 import (
 	"errors"
+	"fmt"
 	"seminarska/internal/data/storage/db"
 	"seminarska/internal/data/storage/entities"
 	"seminarska/proto/datalink"
 )
 
 func (h *Handler) OnMessage(message *datalink.Message) error {
-	h.messageBroadcast.Broadcast(message)
-	entity, err := entities.DatalinkToEntity(message)
-	if err != nil {
-		return err
-	}
-	entity.SetId(int64(message.MessageIndex))
-	receipt, err := h.chainedOperation(entity, message.GetOp())
+	receipt, err := h.prepare(message)
 	if err != nil {
-		return err
+		return statusError(err)
 	}
 	h.mx.Lock()
 	defer h.mx.Unlock()
-	h.pendingRequests[message.GetMessageIndex()] = receipt
+	h.pendingRequests[message.GetMessageIndex()] = pendingRequest{receipt, message}
 	return nil
 }
 
-func (h *Handler) chainedOperation(entity entities.Entity, op datalink.Operation) (rec db.Receipt, err error) {
+func (h *Handler) prepare(message *datalink.Message) (db.Receipt, error) {
+	if batch := message.GetBatch(); batch != nil {
+		return h.prepareBatch(message.GetMessageIndex(), batch)
+	}
+	return h.prepareOperation(message.GetMessageIndex(), message)
+}
+
+func (h *Handler) prepareOperation(index int32, message *datalink.Message) (db.Receipt, error) {
+	entity, err := entities.DatalinkToEntity(message)
+	if err != nil {
+		return nil, err
+	}
+	if message.GetOp() == datalink.Operation_Create {
+		entity.SetId(int64(index))
+		setVersion(entity, 1)
+		resetCounters(entity)
+	}
+	return h.chainedOperation(index, entity, message)
+}
+
+// prepareBatch prepares the operations of a batch in order, so each one sees the
+// changes of the ones before it, and returns their receipts as one. If any operation
+// fails, the ones already prepared are cancelled and the whole batch fails.
+func (h *Handler) prepareBatch(index int32, batch *datalink.Batch) (db.Receipt, error) {
+	operations := batch.GetOperations()
+	if len(operations) == 0 {
+		return nil, fmt.Errorf("%w: no operations", ErrInvalidBatch)
+	}
+	created := make(map[string]bool)
+	receipts := make(db.Receipts, 0, len(operations))
+	for i, operation := range operations {
+		var receipt db.Receipt
+		err := checkBatchOperation(operation, created)
+		if err == nil {
+			receipt, err = h.prepareOperation(index, operation)
+		}
+		if err != nil {
+			receipts.Cancel(err)
+			return nil, fmt.Errorf("batch operation %d: %w", i, err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
+func checkBatchOperation(operation *datalink.Message, created map[string]bool) error {
+	if operation.GetBatch() != nil {
+		return fmt.Errorf("%w: nested batch", ErrInvalidBatch)
+	}
+	relation, ok := createdRelation(operation)
+	if !ok {
+		return nil
+	}
+	if created[relation] {
+		return fmt.Errorf("%w: more than one record created in %s", ErrInvalidBatch, relation)
+	}
+	created[relation] = true
+	return nil
+}
+
+// createdRelation returns the relation in which an operation creates a record,
+// which takes the index of the operation as its id.
+func createdRelation(operation *datalink.Message) (string, bool) {
+	switch operation.GetOp() {
+	case datalink.Operation_Create:
+		switch operation.GetPayload().(type) {
+		case *datalink.Message_User:
+			return users, true
+		case *datalink.Message_Topic:
+			return topics, true
+		case *datalink.Message_Message:
+			return messages, true
+		case *datalink.Message_Like:
+			return likes, true
+		}
+	case datalink.Operation_Update:
+		if operation.GetMessage() != nil {
+			return revisions, true
+		}
+	}
+	return "", false
+}
+
+func (h *Handler) chainedOperation(
+	index int32, entity entities.Entity, message *datalink.Message,
+) (rec db.Receipt, err error) {
 	switch v := entity.(type) {
 	case *entities.Message:
-		rec, err = do(h.relations.Messages(), v, op)
+		if message.GetOp() == datalink.Operation_Update {
+			rec, err = h.updateMessage(index, v, message)
+		} else {
+			rec, err = do(h, messages, h.relations.Messages(), v, message)
+		}
 	case *entities.User:
-		rec, err = do(h.relations.Users(), v, op)
+		rec, err = do(h, users, h.relations.Users(), v, message)
 	case *entities.Like:
-		rec, err = do(h.relations.Likes(), v, op)
+		rec, err = do(h, likes, h.relations.Likes(), v, message)
+		if err == nil && message.GetOp() == datalink.Operation_Create {
+			rec, err = h.withLikeCounted(rec, v)
+		}
 	case *entities.Topic:
-		rec, err = do(h.relations.Topics(), v, op)
+		rec, err = do(h, topics, h.relations.Topics(), v, message)
 	default:
 		err = errors.New("invalid entity")
 	}
 	return
 }
 
-func do[E entities.Entity](relation *db.Relation[E], entity E, op datalink.Operation) (db.Receipt, error) {
-	switch op {
+func do[E entities.Entity](
+	h *Handler, name string, relation *db.Relation[E], entity E, message *datalink.Message,
+) (db.Receipt, error) {
+	switch message.GetOp() {
 	case datalink.Operation_Create:
+		if err := h.checkReferences(relation.References(entity)); err != nil {
+			return nil, err
+		}
 		return relation.Insert(entity)
 	case datalink.Operation_Delete:
-		return relation.Delete(entity.Id())
+		if message.ExpectedVersion != nil {
+			current, err := relation.Latest(entity.Id())
+			if err != nil {
+				return nil, err
+			}
+			if err := checkVersion(current, message.GetExpectedVersion()); err != nil {
+				return nil, err
+			}
+		}
+		return h.deleteCascading(name, entity.Id())
 	case datalink.Operation_Update:
-		return relation.Update(entity.Id(), func(e E) (E, error) { return entity, nil })
+		if err := h.checkReferences(relation.References(entity)); err != nil {
+			return nil, err
+		}
+		return relation.Update(entity.Id(), func(current E) (E, error) {
+			if message.ExpectedVersion != nil {
+				if err := checkVersion(current, message.GetExpectedVersion()); err != nil {
+					return entity, err
+				}
+			}
+			setVersion(entity, version(current)+1)
+			keepCounters(current, entity)
+			return entity, nil
+		})
 	default:
 		return nil, errors.New("invalid operation")
 	}
@@ -0,0 +1,79 @@
+package replication
+
+import (
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func batch(index int32, operations ...*datalink.Message) *datalink.Message {
+	return &datalink.Message{
+		MessageIndex: index,
+		Payload:      &datalink.Message_Batch{Batch: &datalink.Batch{Operations: operations}},
+	}
+}
+
+func TestHandler_Batch(t *testing.T) {
+	h, r := newTestHandler()
+	_ = h.OnMessage(message(1, datalink.Operation_Create, entities.NewUser("ana")))
+	// the message refers to the topic created by the same batch
+	err := h.OnMessage(batch(2,
+		message(0, datalink.Operation_Create, entities.NewTopic("go")),
+		message(0, datalink.Operation_Create, entities.NewMessage(2, 1, "first", time.Unix(1, 0))),
+	))
+	if err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	confirm(h, 1, 2)
+	if _, err := r.topics.Get(2); err != nil {
+		t.Fatalf("expected topic 2: %v", err)
+	}
+	if msg, err := r.messages.Get(2); err != nil || msg.TopicId != 2 {
+		t.Fatalf("expected message 2 in topic 2: %v %v", msg, err)
+	}
+
+	err = h.OnMessage(batch(3,
+		message(0, datalink.Operation_Create, entities.NewTopic("a")),
+		message(0, datalink.Operation_Create, entities.NewTopic("b")),
+	))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument for two creates in one relation, got %v", err)
+	}
+}
+
+func TestHandler_BatchFailure(t *testing.T) {
+	h, r := newTestHandler()
+	for _, op := range []*datalink.Message{
+		message(1, datalink.Operation_Create, entities.NewUser("ana")),
+		message(2, datalink.Operation_Create, entities.NewTopic("go")),
+		message(3, datalink.Operation_Create, entities.NewMessage(2, 1, "a", time.Unix(1, 0))),
+	} {
+		_ = h.OnMessage(op)
+	}
+	confirm(h, 1, 2, 3)
+
+	edited := withId(entities.NewMessage(2, 1, "edited", time.Unix(1, 0)), 3)
+	if err := h.OnMessage(message(4, datalink.Operation_Update, edited)); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	// the batch fails on its last operation while message 4 is still pending
+	err := h.OnMessage(batch(5,
+		message(0, datalink.Operation_Update, withId(entities.NewMessage(2, 1, "again", time.Unix(1, 0)), 3)),
+		message(0, datalink.Operation_Create, entities.NewTopic("other")),
+		message(0, datalink.Operation_Create, entities.NewLike(7, 3)),
+	))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected failed precondition, got %v", err)
+	}
+	if r.topics.Contains(5) {
+		t.Fatalf("expected the batch's topic to be cancelled")
+	}
+	confirm(h, 4)
+	if msg, err := r.messages.Get(3); err != nil || msg.Text != "edited" {
+		t.Fatalf("expected the earlier update to survive the failed batch: %v %v", msg, err)
+	}
+}
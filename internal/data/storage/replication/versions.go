@@ -0,0 +1,31 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"seminarska/internal/data/storage/entities"
+)
+
+var ErrVersion = errors.New("version mismatch")
+
+func version(e entities.Entity) int64 {
+	if v, ok := e.(entities.Versioned); ok {
+		return v.Version()
+	}
+	return 0
+}
+
+func setVersion(e entities.Entity, version int64) {
+	if v, ok := e.(entities.Versioned); ok {
+		v.SetVersion(version)
+	}
+}
+
+// checkVersion fails unless the record is at the version the operation expects.
+// It runs against the latest state, so a replica rejects the same operations as the head.
+func checkVersion(current entities.Entity, expected int64) error {
+	if actual := version(current); actual != expected {
+		return fmt.Errorf("%w: expected %d, record is at %d", ErrVersion, expected, actual)
+	}
+	return nil
+}
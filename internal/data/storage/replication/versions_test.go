@@ -0,0 +1,66 @@
+package replication
+
+import (
+	"seminarska/internal/data/storage/entities"
+	"seminarska/proto/datalink"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func expecting(msg *datalink.Message, version int64) *datalink.Message {
+	msg.ExpectedVersion = &version
+	return msg
+}
+
+func TestHandler_Versions(t *testing.T) {
+	h, r := newTestHandler()
+	for _, op := range []*datalink.Message{
+		message(1, datalink.Operation_Create, entities.NewUser("ana")),
+		message(2, datalink.Operation_Create, entities.NewTopic("go")),
+		message(3, datalink.Operation_Create, entities.NewMessage(2, 1, "a", time.Unix(1, 0))),
+	} {
+		_ = h.OnMessage(op)
+	}
+	confirm(h, 1, 2, 3)
+	if msg, _ := r.messages.Get(3); msg.Version() != 1 {
+		t.Fatalf("expected new message at version 1, got %d", msg.Version())
+	}
+
+	edit := func(index int32, text string) *datalink.Message {
+		return message(index, datalink.Operation_Update, withId(entities.NewMessage(2, 1, text, time.Unix(1, 0)), 3))
+	}
+	// two concurrent edits of version 1: only the first one applies
+	if err := h.OnMessage(expecting(edit(4, "b"), 1)); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	err := h.OnMessage(expecting(edit(5, "c"), 1))
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("expected aborted for stale version, got %v", err)
+	}
+	confirm(h, 4)
+	if msg, _ := r.messages.Get(3); msg.Text != "b" || msg.Version() != 2 {
+		t.Fatalf("unexpected message after update: %q at version %d", msg.Text, msg.Version())
+	}
+
+	// unconditional updates still bump the version
+	_ = h.OnMessage(edit(6, "d"))
+	confirm(h, 6)
+	if msg, _ := r.messages.Get(3); msg.Version() != 3 {
+		t.Fatalf("expected version 3, got %d", msg.Version())
+	}
+
+	del := message(7, datalink.Operation_Delete, withId(entities.NewMessage(0, 0, "", time.Time{}), 3))
+	if err := h.OnMessage(expecting(del, 2)); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected aborted delete, got %v", err)
+	}
+	if err := h.OnMessage(expecting(del, 3)); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	confirm(h, 7)
+	if r.messages.Contains(3) {
+		t.Fatalf("expected message to be deleted")
+	}
+}
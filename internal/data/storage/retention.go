@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"seminarska/internal/data/storage/db"
+	"seminarska/internal/data/storage/entities"
+	"seminarska/internal/data/storage/replication"
+	"seminarska/proto/datalink"
+	"time"
+)
+
+// Messages expire at the head only: it decides which messages fall out of their
+// topic's retention and sends their deletes down the chain, so every replica
+// removes the same messages at the same position of the log.
+
+const (
+	retentionSweep    = time.Second
+	maxExpiredInBatch = 100
+)
+
+func (d *AppDatabase) runRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionSweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.expireMessages(ctx, time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expireMessages dispatches the deletes of expired messages, a batch per topic.
+// A failed batch, say when a user deleted one of the messages first, is retried
+// by the next sweep.
+func (d *AppDatabase) expireMessages(ctx context.Context, now time.Time) {
+	topics, err := d.GetTopics()
+	if err != nil {
+		return
+	}
+	for _, topic := range topics {
+		expired, err := d.expiredMessages(topic, now)
+		if err != nil {
+			log.Println("Failed to find expired messages in topic", topic.Id(), err)
+			continue
+		}
+		if len(expired) == 0 {
+			continue
+		}
+		operations := make([]replication.Operation, len(expired))
+		for i, id := range expired {
+			msg := entities.NewMessage(0, 0, "", time.Time{}) // dummy values
+			msg.SetId(id)
+			operations[i] = replication.Operation{Entity: msg, Op: datalink.Operation_Delete}
+		}
+		if _, err := d.chain.DispatchBatchContext(ctx, operations...); err != nil {
+			return
+		}
+	}
+}
+
+// expiredMessages returns the oldest messages of a topic that are past its retention.
+// Messages with a delete still in flight are left out, so no sweep repeats it.
+func (d *AppDatabase) expiredMessages(topic *entities.Topic, now time.Time) ([]int64, error) {
+	retention := topic.Retention
+	if !retention.Enabled() {
+		return nil, nil
+	}
+	messages, err := d.Messages().ScanIndexed("TopicId", topic.Id(), db.Range{Direction: db.Ascending})
+	if err != nil {
+		return nil, err
+	}
+	var live []*entities.Message
+	for _, msg := range messages {
+		if d.Messages().Contains(msg.Id()) {
+			live = append(live, msg)
+		}
+	}
+	var expired []int64
+	for i, msg := range live {
+		overCount := retention.MaxMessages > 0 && int64(len(live)-i) > retention.MaxMessages
+		tooOld := retention.MaxAge > 0 && now.Sub(msg.CreatedAt) > retention.MaxAge
+		if overCount || tooOld {
+			expired = append(expired, msg.Id())
+		}
+		if len(expired) == maxExpiredInBatch {
+			break
+		}
+	}
+	return expired, nil
+}
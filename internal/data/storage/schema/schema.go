@@ -0,0 +1,69 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"seminarska/proto/datalink"
+)
+
+// Version is the schema of the snapshots and operations this node writes.
+// Version 0 marks snapshots taken before the schema was versioned.
+const Version int32 = 1
+
+var ErrUnsupported = errors.New("unsupported schema version")
+
+// Migration upgrades a snapshot, or a part of one, by a single version.
+type Migration func(snapshot *datalink.DatabaseSnapshot) error
+
+// migrations holds the step from each version to the next. Snapshots streamed
+// in chunks are migrated one part at a time, so a step must not depend on
+// records outside the part.
+var migrations = map[int32]Migration{
+	0: versionEntities,
+}
+
+// Check fails for versions this node cannot read: ones newer than its own, and
+// older ones it has no migrations for.
+func Check(version int32) error {
+	if version > Version || version < 0 {
+		return fmt.Errorf("%w %d: this node handles versions up to %d", ErrUnsupported, version, Version)
+	}
+	for v := version; v < Version; v++ {
+		if migrations[v] == nil {
+			return fmt.Errorf("%w %d: no migration to version %d", ErrUnsupported, version, v+1)
+		}
+	}
+	return nil
+}
+
+// Migrate upgrades the snapshot in place to the current version.
+func Migrate(snapshot *datalink.DatabaseSnapshot) error {
+	if err := Check(snapshot.GetSchemaVersion()); err != nil {
+		return err
+	}
+	for v := snapshot.GetSchemaVersion(); v < Version; v++ {
+		if err := migrations[v](snapshot); err != nil {
+			return fmt.Errorf("migrating schema version %d: %w", v, err)
+		}
+		snapshot.SchemaVersion = v + 1
+	}
+	return nil
+}
+
+// versionEntities numbers records saved before entities were versioned, whose
+// versions start at 1 like those of new records.
+func versionEntities(snapshot *datalink.DatabaseSnapshot) error {
+	for _, u := range snapshot.Users {
+		u.Version = max(u.Version, 1)
+	}
+	for _, t := range snapshot.Topics {
+		t.Version = max(t.Version, 1)
+	}
+	for _, m := range snapshot.Messages {
+		m.Version = max(m.Version, 1)
+	}
+	for _, l := range snapshot.Likes {
+		l.Version = max(l.Version, 1)
+	}
+	return nil
+}
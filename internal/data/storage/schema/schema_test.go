@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"seminarska/proto/datalink"
+	"seminarska/proto/razpravljalnica"
+)
+
+func TestMigrate_Legacy(t *testing.T) {
+	snapshot := &datalink.DatabaseSnapshot{
+		Users:    []*razpravljalnica.User{{Id: 1}},
+		Messages: []*razpravljalnica.Message{{Id: 2}, {Id: 3, Version: 4}},
+	}
+	if err := Migrate(snapshot); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if snapshot.GetSchemaVersion() != Version {
+		t.Fatalf("expected version %d got %d", Version, snapshot.GetSchemaVersion())
+	}
+	if snapshot.Users[0].Version != 1 || snapshot.Messages[0].Version != 1 {
+		t.Fatalf("unversioned records not numbered: %v", snapshot)
+	}
+	if snapshot.Messages[1].Version != 4 {
+		t.Fatalf("versioned record changed: %v", snapshot.Messages[1])
+	}
+}
+
+func TestMigrate_Current(t *testing.T) {
+	snapshot := &datalink.DatabaseSnapshot{
+		SchemaVersion: Version,
+		Messages:      []*razpravljalnica.Message{{Id: 2}},
+	}
+	if err := Migrate(snapshot); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if snapshot.Messages[0].Version != 0 {
+		t.Fatalf("current snapshot migrated: %v", snapshot.Messages[0])
+	}
+}
+
+func TestMigrate_Unsupported(t *testing.T) {
+	snapshot := &datalink.DatabaseSnapshot{SchemaVersion: Version + 1}
+	if err := Migrate(snapshot); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported got %v", err)
+	}
+	if err := Check(-1); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported got %v", err)
+	}
+}
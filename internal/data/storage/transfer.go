@@ -1,22 +1,42 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"seminarska/internal/data/storage/entities"
+	"seminarska/internal/data/storage/replication"
+	"seminarska/internal/data/storage/schema"
 	"seminarska/proto/datalink"
 	"seminarska/proto/razpravljalnica"
 )
 
 func (d *AppDatabase) GetSnapshot() *datalink.DatabaseSnapshot {
-	messages, _ := d.messages.GetAll()
-	users, _ := d.users.GetAll()
-	topics, _ := d.topics.GetAll()
-	likes, _ := d.likes.GetAll()
+	var (
+		messages  []*entities.Message
+		users     []*entities.User
+		topics    []*entities.Topic
+		likes     []*entities.Like
+		revisions []*entities.Revision
+	)
+	digestIndex, digest := d.chain.Consistent(func() {
+		messages, _ = d.messages.GetAll()
+		users, _ = d.users.GetAll()
+		topics, _ = d.topics.GetAll()
+		likes, _ = d.likes.GetAll()
+		revisions, _ = d.revisions.GetAll()
+	})
 
 	snapshot := &datalink.DatabaseSnapshot{
-		Users:    make([]*razpravljalnica.User, len(users)),
-		Topics:   make([]*razpravljalnica.Topic, len(topics)),
-		Messages: make([]*razpravljalnica.Message, len(messages)),
-		Likes:    make([]*razpravljalnica.Like, len(likes)),
+		Users:     make([]*razpravljalnica.User, len(users)),
+		Topics:    make([]*razpravljalnica.Topic, len(topics)),
+		Messages:  make([]*razpravljalnica.Message, len(messages)),
+		Likes:     make([]*razpravljalnica.Like, len(likes)),
+		Revisions: make([]*razpravljalnica.Revision, len(revisions)),
+
+		DigestIndex: digestIndex,
+		Digest:      digest,
+
+		SchemaVersion: schema.Version,
 	}
 
 	for i, message := range messages {
@@ -25,6 +45,9 @@ func (d *AppDatabase) GetSnapshot() *datalink.DatabaseSnapshot {
 	for i, like := range likes {
 		snapshot.Likes[i] = entities.EntityToDatalink(like).GetLike()
 	}
+	for i, revision := range revisions {
+		snapshot.Revisions[i] = entities.RevisionToProto(revision)
+	}
 	for i, topic := range topics {
 		snapshot.Topics[i] = entities.EntityToDatalink(topic).GetTopic()
 	}
@@ -36,43 +59,145 @@ func (d *AppDatabase) GetSnapshot() *datalink.DatabaseSnapshot {
 
 }
 
-func (d *AppDatabase) SetFromSnapshot(snapshot *datalink.DatabaseSnapshot) {
+// BeginSnapshot empties the database for a snapshot received from the predecessor.
+func (d *AppDatabase) BeginSnapshot() error {
+	if d.checkpoints != nil {
+		d.checkpoints.beginTransfer()
+	}
+	d.chain.DropPending()
+	d.chain.ResetDigest(0, nil)
+	return errors.Join(
+		d.Revisions().Clear(),
+		d.Likes().Clear(),
+		d.Messages().Clear(),
+		d.Topics().Clear(),
+		d.Users().Clear(),
+	)
+}
+
+// ApplySnapshotChunk imports a part of the snapshot on top of the parts before it,
+// migrated to the current schema. Parts come from a live database, so their like
+// counters are trusted.
+func (d *AppDatabase) ApplySnapshotChunk(chunk *datalink.DatabaseSnapshot) error {
+	if err := schema.Migrate(chunk); err != nil {
+		return err
+	}
+	if chunk.Digest != nil {
+		d.chain.ResetDigest(chunk.GetDigestIndex(), chunk.GetDigest())
+	}
+	return d.appendSnapshot(chunk)
+}
+
+// FinishSnapshot completes a snapshot received from the predecessor. On a persistent
+// database the snapshot also becomes the base the log is replayed on.
+func (d *AppDatabase) FinishSnapshot(opCount int32) error {
+	// without a digest the parts did not say which operations they hold
+	if _, _, err := d.chain.Digest(0); errors.Is(err, replication.ErrDigestUnknown) {
+		d.chain.ResetDigest(opCount, nil)
+	}
+	if d.checkpoints == nil {
+		return nil
+	}
+	snapshot := d.GetSnapshot()
+	snapshot.OpCount = opCount
+	return d.checkpoints.saveTransferred(snapshot)
+}
+
+// LoggedMessagesAfter returns the confirmed messages after index, for a successor
+// that is missing more of them than the chain still buffers.
+func (d *AppDatabase) LoggedMessagesAfter(index int32) ([]*datalink.Message, error) {
+	return d.chain.LoggedMessagesAfter(index)
+}
+
+// importSnapshot loads a snapshot from disk into the empty database.
+func (d *AppDatabase) importSnapshot(snapshot *datalink.DatabaseSnapshot) error {
+	if err := schema.Migrate(snapshot); err != nil {
+		return err
+	}
+	// like counters are recounted rather than trusted, which also
+	// fills them in for snapshots taken before they were stored
+	likeCounts := make(map[int64]int32)
+	for _, l := range snapshot.Likes {
+		likeCounts[l.MessageId]++
+	}
+	for _, m := range snapshot.Messages {
+		m.Likes = likeCounts[m.Id]
+	}
+	if err := d.appendSnapshot(snapshot); err != nil {
+		panic(err)
+	}
+	index := snapshot.GetDigestIndex()
+	if snapshot.GetDigest() == nil {
+		index = snapshot.GetOpCount()
+	}
+	d.chain.ResetDigest(index, snapshot.GetDigest())
+	return nil
+}
+
+// Digest returns the digest of the confirmed operations through index, or the latest one for index 0.
+func (d *AppDatabase) Digest(index int32) (int32, []byte, error) {
+	return d.chain.Digest(index)
+}
+
+// Applied returns the index of the latest confirmed operation in the database.
+func (d *AppDatabase) Applied() int32 {
+	return d.chain.Applied()
+}
+
+// AwaitApplied waits until the operations through index are in the database.
+func (d *AppDatabase) AwaitApplied(ctx context.Context, index int32) error {
+	return d.chain.AwaitApplied(ctx, index)
+}
+
+func (d *AppDatabase) appendSnapshot(snapshot *datalink.DatabaseSnapshot) error {
 	messages := make([]*entities.Message, len(snapshot.Messages))
 	users := make([]*entities.User, len(snapshot.Users))
 	topics := make([]*entities.Topic, len(snapshot.Topics))
 	likes := make([]*entities.Like, len(snapshot.Likes))
+	revisions := make([]*entities.Revision, len(snapshot.Revisions))
 
 	for i, m := range snapshot.Messages {
 		messages[i] = entities.NewMessage(m.TopicId, m.UserId, m.Text, m.CreatedAt.AsTime())
 		messages[i].SetId(m.Id)
+		messages[i].SetVersion(m.Version)
+		messages[i].Likes = m.Likes
+		if m.EditedAt != nil {
+			messages[i].EditedAt = m.EditedAt.AsTime()
+		}
 	}
 	for i, u := range snapshot.Users {
 		users[i] = entities.NewUser(u.Name)
 		users[i].SetId(u.Id)
+		users[i].SetVersion(u.Version)
 	}
 	for i, t := range snapshot.Topics {
 		topics[i] = entities.NewTopic(t.Name)
 		topics[i].SetId(t.Id)
+		topics[i].SetVersion(t.Version)
+		topics[i].Retention = entities.RetentionFromProto(t.Retention)
+		topics[i].UserId = t.UserId
 	}
 	for i, l := range snapshot.Likes {
 		likes[i] = entities.NewLike(l.UserId, l.MessageId)
 		likes[i].SetId(l.Id)
+		likes[i].SetVersion(l.Version)
+	}
+	for i, r := range snapshot.Revisions {
+		revisions[i] = entities.RevisionFromProto(r)
+		revisions[i].SetVersion(1)
 	}
 
-	err := d.Messages().Import(messages)
-	if err != nil {
-		panic(err)
+	if err := d.Messages().Append(messages); err != nil {
+		return err
 	}
-	err = d.Users().Import(users)
-	if err != nil {
-		panic(err)
+	if err := d.Users().Append(users); err != nil {
+		return err
 	}
-	err = d.Topics().Import(topics)
-	if err != nil {
-		panic(err)
+	if err := d.Topics().Append(topics); err != nil {
+		return err
 	}
-	err = d.Likes().Import(likes)
-	if err != nil {
-		panic(err)
+	if err := d.Likes().Append(likes); err != nil {
+		return err
 	}
+	return d.Revisions().Append(revisions)
 }
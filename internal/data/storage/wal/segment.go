@@ -0,0 +1,184 @@
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"seminarska/proto/datalink"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// compactBytes is how many bytes of dropped records a segment keeps before it
+// rewrites its file without them.
+const compactBytes = 4 << 20
+
+// Segment is a scratch file for chain messages that do not fit in memory. It
+// uses the framing of the log, but is not synced and is removed on Close.
+// Dropped records stay in the file until every record is dropped, which
+// empties it, or until they outgrow both compactAt and the records still
+// held, when the file is rewritten without them.
+type Segment struct {
+	mx        sync.Mutex
+	f         *os.File
+	w         *bufio.Writer
+	records   []segmentRecord // records not dropped yet, in order
+	size      int64
+	dropped   int64 // bytes of dropped records still in the file
+	compactAt int64
+}
+
+type segmentRecord struct {
+	index int32
+	size  int64
+}
+
+// OpenSegment creates a segment file in dir, or in the temporary directory if dir is empty.
+func OpenSegment(dir string) (*Segment, error) {
+	f, err := os.CreateTemp(dir, "replay-*.seg")
+	if err != nil {
+		return nil, err
+	}
+	return &Segment{f: f, w: bufio.NewWriter(f), compactAt: compactBytes}, nil
+}
+
+func (s *Segment) Append(messages ...*datalink.Message) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.f == nil {
+		return ErrClosed
+	}
+	for _, message := range messages {
+		if err := writeRecord(s.w, message); err != nil {
+			return err
+		}
+		size := int64(headerSize + proto.Size(message))
+		s.records = append(s.records, segmentRecord{message.GetMessageIndex(), size})
+		s.size += size
+	}
+	return nil
+}
+
+// ReadAfter returns the records after index that were not dropped.
+func (s *Segment) ReadAfter(index int32) ([]*datalink.Message, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.f == nil {
+		return nil, ErrClosed
+	}
+	if len(s.records) == 0 || s.records[len(s.records)-1].index <= index {
+		return nil, nil
+	}
+	return s.read(index)
+}
+
+// read returns the records after index, skipping the dropped ones that are
+// still in the file. The caller holds the lock.
+func (s *Segment) read(index int32) ([]*datalink.Message, error) {
+	if err := s.w.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	from := max(index, s.records[0].index-1)
+	var messages []*datalink.Message
+	r := bufio.NewReader(s.f)
+	for {
+		message, _, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if message.GetMessageIndex() > from {
+			messages = append(messages, message)
+		}
+	}
+	_, err := s.f.Seek(0, io.SeekEnd)
+	return messages, err
+}
+
+// DropBefore drops the records below index.
+func (s *Segment) DropBefore(index int32) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.f == nil {
+		return ErrClosed
+	}
+	n := 0
+	for n < len(s.records) && s.records[n].index < index {
+		s.size -= s.records[n].size
+		s.dropped += s.records[n].size
+		n++
+	}
+	s.records = s.records[n:]
+	if n == 0 {
+		return nil
+	}
+	if len(s.records) > 0 {
+		// a stalled successor keeps the segment from emptying, so the
+		// dropped prefix is cut off once it outweighs the rest
+		if s.dropped >= s.compactAt && s.dropped >= s.size {
+			return s.compact()
+		}
+		return nil
+	}
+	s.records, s.size, s.dropped = nil, 0, 0
+	s.w.Reset(s.f)
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := s.f.Seek(0, io.SeekStart)
+	return err
+}
+
+// compact moves the records not dropped to a new file, which replaces the
+// current one. If that fails, the current file is kept. The caller holds the lock.
+func (s *Segment) compact() error {
+	messages, err := s.read(s.records[0].index - 1)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.f.Name()), "replay-*.seg")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, message := range messages {
+		if err = writeRecord(w, message); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return errors.Join(err, f.Close(), os.Remove(f.Name()))
+	}
+	old := s.f
+	s.f, s.w, s.dropped = f, w, 0
+	return errors.Join(old.Close(), os.Remove(old.Name()))
+}
+
+// Size returns the number of records not dropped and their size in bytes.
+func (s *Segment) Size() (int, int64) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return len(s.records), s.size
+}
+
+func (s *Segment) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.f == nil {
+		return ErrClosed
+	}
+	err := errors.Join(s.f.Close(), os.Remove(s.f.Name()))
+	s.f = nil
+	return err
+}
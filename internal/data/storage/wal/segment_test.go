@@ -0,0 +1,115 @@
+package wal
+
+import (
+	"os"
+	"testing"
+
+	"seminarska/proto/datalink"
+	"seminarska/proto/razpravljalnica"
+)
+
+func TestSegment_AppendDrop(t *testing.T) {
+	s, err := OpenSegment(t.TempDir())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := int32(1); i <= 4; i++ {
+		msg := &datalink.Message{
+			MessageIndex: i,
+			Payload:      &datalink.Message_User{User: &razpravljalnica.User{Name: "u"}},
+		}
+		if err := s.Append(msg); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	got, err := s.ReadAfter(2)
+	if err != nil || len(got) != 2 || got[0].MessageIndex != 3 {
+		t.Fatalf("expected messages 3 and 4 got %v (%v)", got, err)
+	}
+
+	if err := s.DropBefore(3); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if n, _ := s.Size(); n != 2 {
+		t.Fatalf("expected 2 records got %d", n)
+	}
+	if got, _ := s.ReadAfter(0); len(got) != 2 || got[0].MessageIndex != 3 {
+		t.Fatalf("dropped records read back: %v", got)
+	}
+
+	// dropping every record empties the file, appends start over
+	if err := s.DropBefore(5); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if info, err := os.Stat(s.f.Name()); err != nil || info.Size() != 0 {
+		t.Fatalf("expected an empty file: %v", err)
+	}
+	if err := s.Append(&datalink.Message{MessageIndex: 5}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if got, _ := s.ReadAfter(0); len(got) != 1 || got[0].MessageIndex != 5 {
+		t.Fatalf("expected message 5 got %v", got)
+	}
+	if n, size := s.Size(); n != 1 || size == 0 {
+		t.Fatalf("expected 1 record got %d of %d bytes", n, size)
+	}
+
+	name := s.f.Name()
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed: %v", err)
+	}
+}
+
+func TestSegment_Compact(t *testing.T) {
+	s, err := OpenSegment(t.TempDir())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+	s.compactAt = 1
+	for i := int32(1); i <= 4; i++ {
+		if err := s.Append(&datalink.Message{MessageIndex: i}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	fileSize := func() int64 {
+		if err := s.w.Flush(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		info, err := os.Stat(s.f.Name())
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		return info.Size()
+	}
+	full := fileSize()
+
+	// the dropped record is outweighed by the rest, so it stays in the file
+	if err := s.DropBefore(2); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if fileSize() != full {
+		t.Fatalf("expected the file to keep its size")
+	}
+	name := s.f.Name()
+	if err := s.DropBefore(3); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if _, size := s.Size(); fileSize() != size {
+		t.Fatalf("expected the file to hold only the %d bytes not dropped, got %d", size, fileSize())
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected the old file to be removed: %v", err)
+	}
+
+	if err := s.Append(&datalink.Message{MessageIndex: 5}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	got, err := s.ReadAfter(0)
+	if err != nil || len(got) != 3 || got[0].MessageIndex != 3 || got[2].MessageIndex != 5 {
+		t.Fatalf("expected messages 3 to 5 got %v (%v)", got, err)
+	}
+}
@@ -0,0 +1,151 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"seminarska/proto/datalink"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".snap"
+)
+
+// SnapshotStore keeps the most recent database snapshots in a directory.
+// Each file holds [crc32 uint32][protobuf snapshot] and is named after its op count.
+type SnapshotStore struct {
+	dir    string
+	retain int
+}
+
+func OpenSnapshotStore(dir string, retain int) (*SnapshotStore, error) {
+	if retain < 1 {
+		return nil, errors.New("must retain at least one snapshot")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{dir: dir, retain: retain}, nil
+}
+
+// Save atomically writes the snapshot and removes snapshots beyond the retention limit.
+func (s *SnapshotStore) Save(snapshot *datalink.DatabaseSnapshot) error {
+	data, err := proto.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	var header [4]byte
+	binary.LittleEndian.PutUint32(header[:], crc32.ChecksumIEEE(data))
+
+	path := filepath.Join(s.dir, snapshotName(snapshot.GetOpCount()))
+	tmp, err := os.CreateTemp(s.dir, "tmp-"+snapshotPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(header[:], data...)); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return s.prune()
+}
+
+// Latest returns the newest snapshot that passes the checksum, or nil if there is none.
+func (s *SnapshotStore) Latest() (*datalink.DatabaseSnapshot, error) {
+	names, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		snapshot, err := readSnapshot(filepath.Join(s.dir, names[i]))
+		if err != nil {
+			log.Println("Skipping invalid snapshot", names[i], err)
+			continue
+		}
+		return snapshot, nil
+	}
+	return nil, nil
+}
+
+// Oldest returns the op count of the oldest retained snapshot, or 0 if there is none.
+// Log records up to this index are covered by every snapshot Latest can fall back to.
+func (s *SnapshotStore) Oldest() (int32, error) {
+	names, err := s.list()
+	if err != nil || len(names) == 0 {
+		return 0, err
+	}
+	var opCount int32
+	_, err = fmt.Sscanf(names[0], snapshotPrefix+"%d"+snapshotSuffix, &opCount)
+	return opCount, err
+}
+
+func (s *SnapshotStore) prune() error {
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+	for len(names) > s.retain {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+func (s *SnapshotStore) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), snapshotPrefix) && strings.HasSuffix(e.Name(), snapshotSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func snapshotName(opCount int32) string {
+	return fmt.Sprintf("%s%010d%s", snapshotPrefix, opCount, snapshotSuffix)
+}
+
+func readSnapshot(path string) (*datalink.DatabaseSnapshot, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 4 {
+		return nil, errCorrupt
+	}
+	data := raw[4:]
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(raw[:4]) {
+		return nil, errCorrupt
+	}
+	snapshot := &datalink.DatabaseSnapshot{}
+	if err := proto.Unmarshal(data, snapshot); err != nil {
+		return nil, errCorrupt
+	}
+	return snapshot, nil
+}
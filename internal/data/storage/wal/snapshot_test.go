@@ -0,0 +1,72 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"seminarska/proto/datalink"
+	"seminarska/proto/razpravljalnica"
+)
+
+func TestSnapshotStore_LatestAndRetention(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSnapshotStore(dir, 2)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if snap, err := s.Latest(); err != nil || snap != nil {
+		t.Fatalf("expected no snapshot, got %v %v", snap, err)
+	}
+	for _, op := range []int32{5, 10, 15} {
+		snap := &datalink.DatabaseSnapshot{
+			OpCount: op,
+			Users:   []*razpravljalnica.User{{Id: int64(op), Name: "u"}},
+		}
+		if err := s.Save(snap); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	latest, err := s.Latest()
+	if err != nil || latest.GetOpCount() != 15 || len(latest.GetUsers()) != 1 {
+		t.Fatalf("unexpected latest: %v %v", latest, err)
+	}
+	oldest, err := s.Oldest()
+	if err != nil || oldest != 10 {
+		t.Fatalf("expected oldest retained 10 got %d %v", oldest, err)
+	}
+}
+
+func TestSnapshotStore_FallbackOnCorruption(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenSnapshotStore(dir, 2)
+	_ = s.Save(&datalink.DatabaseSnapshot{OpCount: 1})
+	_ = s.Save(&datalink.DatabaseSnapshot{OpCount: 2})
+	if err := os.WriteFile(filepath.Join(dir, snapshotName(2)), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	latest, err := s.Latest()
+	if err != nil || latest.GetOpCount() != 1 {
+		t.Fatalf("expected fallback to op 1, got %v %v", latest, err)
+	}
+}
+
+func TestLog_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+	for i := int32(1); i <= 5; i++ {
+		_ = l.Append(&datalink.Message{MessageIndex: i})
+	}
+	if err := l.Compact(3); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	_ = l.Append(&datalink.Message{MessageIndex: 6})
+	got := replayAll(t, l)
+	if len(got) != 3 || got[0].GetMessageIndex() != 4 || got[2].GetMessageIndex() != 6 {
+		t.Fatalf("unexpected records after compaction: %v", got)
+	}
+}
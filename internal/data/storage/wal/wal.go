@@ -0,0 +1,243 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"seminarska/proto/datalink"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Log is an append-only file of confirmed chain messages.
+// Every record is framed as [length uint32][crc32 uint32][protobuf message].
+type Log struct {
+	mx   sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	base int32 // every confirmed message after base is in the log
+}
+
+const (
+	headerSize    = 8
+	maxRecordSize = 64 << 20
+)
+
+var (
+	ErrClosed    = errors.New("log closed")
+	ErrCompacted = errors.New("messages compacted away")
+	errCorrupt   = errors.New("corrupt record")
+)
+
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Replay calls fn for every intact record in the log, in order.
+// A torn or corrupt tail (e.g. a crash mid-append) is truncated away.
+func (l *Log) Replay(fn func(message *datalink.Message) error) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(l.f)
+	var offset int64
+	for {
+		message, n, err := readRecord(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				if err := l.f.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err := fn(message); err != nil {
+			return err
+		}
+		offset += n
+	}
+	_, err := l.f.Seek(offset, io.SeekStart)
+	l.w.Reset(l.f)
+	return err
+}
+
+// SetBase records that the log holds every confirmed message after index base,
+// e.g. those after the snapshot it is replayed on.
+func (l *Log) SetBase(base int32) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.base = base
+}
+
+// ReadAfter returns the messages after index, or ErrCompacted if some of them
+// are no longer in the log.
+func (l *Log) ReadAfter(index int32) ([]*datalink.Message, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.f == nil {
+		return nil, ErrClosed
+	}
+	if index < l.base {
+		return nil, ErrCompacted
+	}
+	if err := l.w.Flush(); err != nil {
+		return nil, err
+	}
+	// a separate handle keeps the write offset where it is
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var messages []*datalink.Message
+	r := bufio.NewReader(f)
+	for {
+		message, _, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		if message.GetMessageIndex() > index {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// Append durably writes the message to the end of the log.
+func (l *Log) Append(message *datalink.Message) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	if err := writeRecord(l.w, message); err != nil {
+		return err
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+func (l *Log) Close() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	err := errors.Join(l.w.Flush(), l.f.Close())
+	l.f = nil
+	return err
+}
+
+func writeRecord(w io.Writer, message *datalink.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readRecord(r io.Reader) (*datalink.Message, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, errCorrupt
+		}
+		return nil, 0, err
+	}
+	size := binary.LittleEndian.Uint32(header[:4])
+	if size > maxRecordSize {
+		return nil, 0, errCorrupt
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, errCorrupt
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, 0, errCorrupt
+	}
+	message := &datalink.Message{}
+	if err := proto.Unmarshal(data, message); err != nil {
+		return nil, 0, errCorrupt
+	}
+	return message, int64(headerSize + size), nil
+}
+
+// Compact rewrites the log without the records up to and including index through.
+func (l *Log) Compact(through int32) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), "tmp-"+filepath.Base(l.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	r := bufio.NewReader(l.f)
+	for {
+		message, _, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		if message.GetMessageIndex() <= through {
+			continue
+		}
+		if err := writeRecord(w, message); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := errors.Join(w.Flush(), tmp.Sync(), tmp.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		_ = f.Close()
+		return err
+	}
+	_ = l.f.Close()
+	l.f = f
+	l.w.Reset(f)
+	l.base = max(l.base, through)
+	return nil
+}
@@ -0,0 +1,127 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"seminarska/proto/datalink"
+	"seminarska/proto/razpravljalnica"
+)
+
+func replayAll(t *testing.T, l *Log) []*datalink.Message {
+	var out []*datalink.Message
+	if err := l.Replay(func(m *datalink.Message) error {
+		out = append(out, m)
+		return nil
+	}); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	return out
+}
+
+func TestLog_AppendReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := int32(1); i <= 3; i++ {
+		msg := &datalink.Message{
+			MessageIndex: i,
+			Payload:      &datalink.Message_User{User: &razpravljalnica.User{Name: "u"}},
+		}
+		if err := l.Append(msg); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	l, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer l.Close()
+	got := replayAll(t, l)
+	if len(got) != 3 || got[2].GetMessageIndex() != 3 || got[0].GetUser().GetName() != "u" {
+		t.Fatalf("unexpected replay: %v", got)
+	}
+	// appends after replay continue the log
+	if err := l.Append(&datalink.Message{MessageIndex: 4}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if got := replayAll(t, l); len(got) != 4 {
+		t.Fatalf("expected 4 records got %d", len(got))
+	}
+}
+
+func TestLog_TornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = l.Append(&datalink.Message{MessageIndex: 1})
+	_ = l.Append(&datalink.Message{MessageIndex: 2})
+	_ = l.Close()
+
+	// simulate a crash in the middle of the last append
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	l, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer l.Close()
+	got := replayAll(t, l)
+	if len(got) != 1 || got[0].GetMessageIndex() != 1 {
+		t.Fatalf("expected only intact record, got %v", got)
+	}
+	_ = l.Append(&datalink.Message{MessageIndex: 2})
+	if got := replayAll(t, l); len(got) != 2 || got[1].GetMessageIndex() != 2 {
+		t.Fatalf("expected torn tail to be overwritten, got %v", got)
+	}
+}
+
+func TestLog_ReadAfter(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "wal.log"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+	// message 3 failed, so it was never logged
+	for _, i := range []int32{1, 2, 4, 5} {
+		if err := l.Append(&datalink.Message{MessageIndex: i}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	got, err := l.ReadAfter(2)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(got) != 2 || got[0].GetMessageIndex() != 4 || got[1].GetMessageIndex() != 5 {
+		t.Fatalf("unexpected messages: %v", got)
+	}
+	if err := l.Compact(2); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if _, err := l.ReadAfter(1); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected compacted error got %v", err)
+	}
+	if got, err := l.ReadAfter(2); err != nil || len(got) != 2 {
+		t.Fatalf("expected 2 messages after the compaction point got %d (%v)", len(got), err)
+	}
+	// appends continue after reads
+	if err := l.Append(&datalink.Message{MessageIndex: 6}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if got := replayAll(t, l); len(got) != 3 || got[2].GetMessageIndex() != 6 {
+		t.Fatalf("unexpected log after read: %v", got)
+	}
+}